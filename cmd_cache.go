@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/arepala-uml/books-management-system/pkg/cache"
+	"github.com/arepala-uml/books-management-system/pkg/config"
+	"github.com/go-redis/redis/v8"
+)
+
+const cacheUsage = "usage: book-management-store cache warm | flush | verify | check [--sample N] | " +
+	"bench [--books N] [--redis] | cleanup [--other-encodings] [--dry-run]"
+
+// runCache handles "cache warm", "cache flush", "cache verify", "cache check", "cache bench" and "cache cleanup".
+// flush and cleanup only connect to Redis, and bench to nothing unless asked to.
+func runCache(args []string) error {
+	if len(args) == 0 {
+		return errors.New(cacheUsage)
+	}
+	switch args[0] {
+	case "check":
+		return runCacheCheck(args[1:])
+	case "bench":
+		return runCacheBench(args[1:])
+	case "cleanup":
+		return runCacheCleanup(args[1:])
+	}
+	if len(args) != 1 {
+		return errors.New(cacheUsage)
+	}
+	ctx := context.Background()
+
+	switch args[0] {
+	case "warm":
+		initLogging()
+		config.Connect()
+		defer config.ClosePostgres()
+		defer config.CloseRedis()
+		status, err := cache.Warm(ctx)
+		if err != nil {
+			return err
+		}
+		slog.Info("Cache warmed", "books", status.Warmed)
+	case "flush":
+		initLogging()
+		config.ConnectRedis()
+		defer config.CloseRedis()
+		deleted, err := cache.Flush(ctx)
+		if err != nil {
+			return err
+		}
+		slog.Info("Cache flushed", "keys", deleted)
+	case "verify":
+		initLogging()
+		config.Connect()
+		defer config.ClosePostgres()
+		defer config.CloseRedis()
+		report, err := cache.Verify(ctx)
+		if err != nil {
+			return err
+		}
+		slog.Info("Cache verified", "checked", report.Checked, "missing", report.Missing,
+			"stale", report.Stale, "orphaned", report.Orphaned)
+		if !report.Consistent() {
+			return fmt.Errorf("cache differs from Postgres: %d stale and %d orphaned books",
+				len(report.Stale), len(report.Orphaned))
+		}
+	default:
+		return errors.New(cacheUsage)
+	}
+	return nil
+}
+
+// runCacheCheck compares a random sample of the cached books with Postgres and deletes those that differ,
+// as the server does every CACHE_CHECK_INTERVAL
+func runCacheCheck(args []string) error {
+	fs := flag.NewFlagSet("cache check", flag.ExitOnError)
+	sample := fs.Int("sample", 0, "number of cached books to compare, CACHE_CHECK_SAMPLE_SIZE when not set")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		return errors.New(cacheUsage)
+	}
+
+	cfg := initLogging()
+	size := *sample
+	if size == 0 {
+		size = cfg.Cache.CheckSampleSize
+	} else if size < 0 {
+		return fmt.Errorf("--sample must be at least 1, got %d", size)
+	}
+	config.Connect()
+	defer config.ClosePostgres()
+	defer config.CloseRedis()
+
+	report, err := cache.Check(context.Background(), config.GetDB(), size)
+	if err != nil {
+		return err
+	}
+	slog.Info("Cache checked", "checked", report.Checked, "stale", report.Stale, "orphaned", report.Orphaned)
+	if !report.Consistent() {
+		return fmt.Errorf("deleted %d stale and %d orphaned books from the cache",
+			len(report.Stale), len(report.Orphaned))
+	}
+	return nil
+}
+
+// runCacheCleanup deletes the keys left in Redis by earlier versions of the book cache keys
+func runCacheCleanup(args []string) error {
+	fs := flag.NewFlagSet("cache cleanup", flag.ExitOnError)
+	otherEncodings := fs.Bool("other-encodings", false,
+		"also delete the keys of the current version in other encodings, once no instance uses them")
+	dryRun := fs.Bool("dry-run", false, "only count the keys that would be deleted")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		return errors.New(cacheUsage)
+	}
+
+	initLogging()
+	config.ConnectRedis()
+	defer config.CloseRedis()
+	counts, err := cache.CleanupKeys(context.Background(), *otherEncodings, *dryRun)
+	if err != nil {
+		return err
+	}
+	total := 0
+	for version, count := range counts {
+		slog.Info("Old cache keys", "version", version, "keys", count)
+		total += count
+	}
+	if *dryRun {
+		slog.Info("Cache cleanup dry run, nothing deleted", "keys", total)
+	} else {
+		slog.Info("Cache cleaned up", "keys", total)
+	}
+	return nil
+}
+
+// runCacheBench compares the cache encodings on generated books, in process and with --redis against Redis.
+// It prints the cost per book of each encoding.
+func runCacheBench(args []string) error {
+	fs := flag.NewFlagSet("cache bench", flag.ExitOnError)
+	count := fs.Int("books", 1000, "number of generated books to encode")
+	withRedis := fs.Bool("redis", false, "also write and read the books in Redis, under BENCH:* keys deleted afterwards")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		return errors.New(cacheUsage)
+	}
+	if *count < 1 {
+		return fmt.Errorf("--books must be at least 1, got %d", *count)
+	}
+
+	initLogging()
+	var client redis.UniversalClient
+	if *withRedis {
+		config.ConnectRedis()
+		defer config.CloseRedis()
+		client = config.GetRedisClient()
+	}
+	results, err := cache.Bench(context.Background(), client, generateBooks(*count))
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ENCODING\tBYTES\tENCODE\tDECODE\tREDIS MEMORY\tSET\tGET\tMGET")
+	for _, r := range results {
+		fmt.Fprintf(w, "%s\t%.1f\t%s\t%s\t%s\t%s\t%s\t%s\n", r.Encoding, r.Bytes, r.Encode, r.Decode,
+			benchValue(r.Memory, func() string { return fmt.Sprintf("%d B", r.Memory) }),
+			benchValue(r.Set, r.Set.String), benchValue(r.Get, r.Get.String), benchValue(r.GetMany, r.GetMany.String))
+	}
+	return w.Flush()
+}
+
+// benchValue formats a Redis measurement, "-" when it wasn't measured
+func benchValue[T int64 | time.Duration](value T, format func() string) string {
+	if value == 0 {
+		return "-"
+	}
+	return format()
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"log/slog"
+
+	"github.com/arepala-uml/books-management-system/pkg/kafka"
+)
+
+const kafkaUsage = "usage: book-management-store kafka topics create [--partitions N] [--replication-factor N]"
+
+// runKafka handles "kafka topics create", which creates KAFKA_TOPIC. It only connects to Kafka.
+func runKafka(args []string) error {
+	if len(args) < 2 || args[0] != "topics" || args[1] != "create" {
+		return errors.New(kafkaUsage)
+	}
+	fs := flag.NewFlagSet("kafka topics create", flag.ExitOnError)
+	partitions := fs.Int("partitions", 1, "number of partitions")
+	replicationFactor := fs.Int("replication-factor", 1, "number of replicas of each partition")
+	if err := fs.Parse(args[2:]); err != nil {
+		return err
+	}
+	if *partitions < 1 || *replicationFactor < 1 {
+		return errors.New("--partitions and --replication-factor must be at least 1")
+	}
+
+	cfg := initLogging()
+	created, err := kafka.CreateTopic(cfg.Kafka.Brokers(), cfg.Kafka.Topic, int32(*partitions), int16(*replicationFactor))
+	if err != nil {
+		return err
+	}
+	if !created {
+		slog.Info("Kafka topic already exists", "topic", cfg.Kafka.Topic)
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/arepala-uml/books-management-system/pkg/config"
+	"github.com/arepala-uml/books-management-system/pkg/migrations"
+)
+
+const migrateUsage = "usage: book-management-store migrate up | down [steps] | status"
+
+// runMigrate handles "migrate up", "migrate down [steps]", which rolls back one migration by default,
+// and "migrate status". It only connects to Postgres.
+func runMigrate(args []string) error {
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+	initLogging()
+	config.ConnectPostgres()
+	defer config.ClosePostgres()
+	ctx := context.Background()
+
+	switch args[0] {
+	case "up":
+		applied, err := migrations.Up(ctx, config.GetDB())
+		if err != nil {
+			return err
+		}
+		slog.Info("Migrations applied", "count", len(applied))
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < 1 {
+				return fmt.Errorf("steps must be a positive number, got %q", args[1])
+			}
+			steps = n
+		}
+		rolledBack, err := migrations.Down(ctx, config.GetDB(), steps)
+		if err != nil {
+			return err
+		}
+		slog.Info("Migrations rolled back", "count", len(rolledBack))
+	case "status":
+		states, err := migrations.Status(ctx, config.GetDB())
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT")
+		for _, state := range states {
+			appliedAt := "pending"
+			if state.AppliedAt != nil {
+				appliedAt = state.AppliedAt.Format("2006-01-02 15:04:05 MST")
+			}
+			fmt.Fprintf(w, "%04d\t%s\t%s\n", state.Version, state.Name, appliedAt)
+		}
+		return w.Flush()
+	default:
+		return errors.New(migrateUsage)
+	}
+	return nil
+}
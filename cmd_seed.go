@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+
+	"github.com/arepala-uml/books-management-system/pkg/cache"
+	"github.com/arepala-uml/books-management-system/pkg/config"
+	"github.com/arepala-uml/books-management-system/pkg/models"
+)
+
+var (
+	seedAdjectives = []string{"Silent", "Crimson", "Hidden", "Last", "Broken", "Golden", "Distant", "Quiet", "Burning", "Forgotten"}
+	seedNouns      = []string{"River", "Garden", "Empire", "Letter", "Harbor", "Mountain", "Library", "Winter", "Orchard", "Lantern"}
+	seedFirstNames = []string{"Ada", "Kofi", "Mei", "Lucas", "Amara", "Ivan", "Priya", "Mateo", "Sofia", "Noah"}
+	seedLastNames  = []string{"Okafor", "Lindqvist", "Tanaka", "Moreau", "Haddad", "Novak", "Reyes", "Sharma", "Walsh", "Costa"}
+)
+
+// runSeed inserts generated books into Postgres, for development and load tests. The books are neither
+// cached nor published to Kafka, but the cached book list is invalidated since it no longer has every book.
+func runSeed(args []string) error {
+	fs := flag.NewFlagSet("seed", flag.ExitOnError)
+	count := fs.Int("count", 100, "number of books to insert")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *count < 1 {
+		return fmt.Errorf("--count must be at least 1, got %d", *count)
+	}
+
+	initLogging()
+	config.Connect()
+	defer config.ClosePostgres()
+	defer config.CloseRedis()
+
+	ctx := context.Background()
+	books := generateBooks(*count)
+	if err := config.GetDB().WithContext(ctx).CreateInBatches(&books, 500).Error; err != nil {
+		return err
+	}
+	if err := cache.InvalidateList(ctx); err != nil {
+		return fmt.Errorf("books seeded but the cached book list could not be invalidated, run cache flush: %w", err)
+	}
+	slog.Info("Seeded books", "count", len(books), "first_id", books[0].ID, "last_id", books[len(books)-1].ID)
+	return nil
+}
+
+// generateBooks returns count books with made-up titles, authors and years
+func generateBooks(count int) []models.Book {
+	books := make([]models.Book, count)
+	for i := range books {
+		books[i] = models.Book{
+			Title:  fmt.Sprintf("The %s %s", pick(seedAdjectives), pick(seedNouns)),
+			Author: fmt.Sprintf("%s %s", pick(seedFirstNames), pick(seedLastNames)),
+			Year:   1900 + rand.IntN(125),
+		}
+	}
+	return books
+}
+
+func pick(values []string) string {
+	return values[rand.IntN(len(values))]
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/arepala-uml/books-management-system/pkg/auth"
+	"github.com/arepala-uml/books-management-system/pkg/cache"
+	"github.com/arepala-uml/books-management-system/pkg/config"
+	"github.com/arepala-uml/books-management-system/pkg/health"
+	"github.com/arepala-uml/books-management-system/pkg/kafka"
+	"github.com/arepala-uml/books-management-system/pkg/ledger"
+	"github.com/arepala-uml/books-management-system/pkg/lifecycle"
+	"github.com/arepala-uml/books-management-system/pkg/logging"
+	"github.com/arepala-uml/books-management-system/pkg/metrics"
+	"github.com/arepala-uml/books-management-system/pkg/migrations"
+	"github.com/arepala-uml/books-management-system/pkg/ratelimit"
+	"github.com/arepala-uml/books-management-system/pkg/routes"
+	"github.com/arepala-uml/books-management-system/pkg/tracing"
+	ginSwagger "github.com/swaggo/gin-swagger"
+	"github.com/swaggo/gin-swagger/swaggerFiles"
+
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
+)
+
+func reloadConfig() {
+	if err := config.Reload(); err != nil {
+		slog.Error("Failed to reload configuration", "error", err)
+	}
+}
+
+// subscribeReloads applies the settings that can change without a restart when the configuration is
+// reloaded. The settings read on every use, such as REDIS_EXPIRY_BOOKS and the FEATURE_* flags, need
+// no subscriber.
+func subscribeReloads() {
+	config.Subscribe("log-level", func(old, new *config.Config) error {
+		if old.Log.Level != new.Log.Level {
+			logging.SetLevel(new.Log.Level)
+			slog.Info("Log level changed", "level", new.Log.Level)
+		}
+		return nil
+	})
+	config.Subscribe("rate-limits", ratelimit.Reload)
+	config.Subscribe("postgres", config.ReconnectPostgres)
+	config.Subscribe("redis", config.ReconnectRedis)
+	config.Subscribe("kafka-producer", kafka.ReconnectProducer)
+}
+
+// setup connects every dependency of the server and prepares the database and authentication
+func setup() {
+	cfg := initLogging()
+	config.Watch(func(file string) {
+		slog.Info("Config file changed, reloading", "path", file)
+		reloadConfig()
+	})
+	// Postgres is the source of truth, the server starts without Redis and uses it once it is back
+	config.ConnectPostgres()
+	config.OpenRedis()
+	if err := config.UseGormPlugin(metrics.GormPlugin{}); err != nil {
+		logging.Fatal("Failed to register query metrics", "error", err)
+	}
+	if err := config.UseGormPlugin(tracing.GormPlugin{}); err != nil {
+		logging.Fatal("Failed to register query tracing", "error", err)
+	}
+	config.AddRedisHook(tracing.RedisHook{})
+	// Bring the database schema up to date, instances starting together wait for each other
+	if cfg.Migrations.OnStart {
+		applied, err := migrations.Up(context.Background(), config.GetDB())
+		if err != nil {
+			logging.Fatal("Failed to apply migrations", "error", err)
+		}
+		slog.Info("Database schema is up to date", "applied", len(applied))
+	}
+
+	if err := auth.Init(); err != nil {
+		logging.Fatal("Failed to initialise authentication", "error", err)
+	}
+	if err := auth.EnsureAdminUser(); err != nil {
+		logging.Fatal("Failed to create the initial user", "error", err)
+	}
+	if err := ratelimit.Init(); err != nil {
+		logging.Fatal("Failed to configure rate limits", "error", err)
+	}
+	subscribeReloads()
+}
+
+// serve runs the HTTP API together with the Kafka consumer and the fine accrual until it is stopped
+func serve(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	setup()
+	cfg := config.Get()
+
+	shutdownTracing, err := tracing.Init()
+	if err != nil {
+		logging.Fatal("Failed to initialise tracing", "error", err)
+	}
+
+	// The request logger of the logging package replaces the text access log of gin.Default
+	r, err := routes.NewEngine()
+	if err != nil {
+		logging.Fatal("Failed to set the trusted proxies", "error", err)
+	}
+	// Probes and scrapes would drown the traces of real requests
+	r.Use(otelgin.Middleware(tracing.ServiceName(), otelgin.WithFilter(func(req *http.Request) bool {
+		switch req.URL.Path {
+		case "/healthz", "/readyz", "/metrics":
+			return false
+		}
+		return true
+	})))
+	r.Use(logging.Middleware(), metrics.Middleware())
+
+	// Stop on Ctrl+C or when the orchestrator sends SIGTERM
+	signals, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	// Reload the configuration on SIGHUP too, e.g. after rotating a secret file
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	go func() {
+		for range hangups {
+			slog.Info("Received SIGHUP, reloading configuration")
+			reloadConfig()
+		}
+	}()
+
+	app := lifecycle.New()
+
+	app.Go("kafka-consumer", kafka.RunConsumer)
+
+	// Fill the book cache from Postgres in the background, the API serves meanwhile
+	if cfg.Cache.WarmOnStart {
+		app.Go("cache-warm", func(ctx context.Context) {
+			if _, err := cache.Warm(ctx); err != nil && !errors.Is(err, context.Canceled) {
+				slog.Error("Failed to warm the book cache", "error", err)
+			}
+		})
+	}
+
+	// Evict the books changed by other instances from the in-process cache
+	app.Go("cache-invalidation", cache.RunInvalidationListener)
+
+	// Compare a sample of the cached books with Postgres on a schedule
+	app.Go("cache-check", func(ctx context.Context) {
+		cache.StartConsistencyCheck(ctx, cfg.Cache.CheckInterval, cfg.Cache.CheckSampleSize)
+	})
+
+	// Charge overdue fines on a schedule
+	app.Go("fine-accrual", func(ctx context.Context) {
+		ledger.StartFineAccrual(ctx, cfg.Circulation.FineAccrualInterval)
+	})
+
+	// Register the routes for the Book Store API
+	routes.RegisterBookStoreRoutes(r, app)
+
+	// Swagger UI endpoint
+	r.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
+
+	hostname := cfg.Server.Address()
+	server := &http.Server{
+		Addr:              hostname,
+		Handler:           r,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	// Shutdown order: fail readiness and give the load balancer time to notice, stop taking requests
+	// and drain the in-flight ones, stop the workers that may still publish or query, then flush Kafka
+	// and close the connections everyone used
+	readinessDelay := cfg.Shutdown.ReadinessDelay
+	app.OnStop("readiness", readinessDelay+time.Second, func(ctx context.Context) error {
+		health.SetShuttingDown()
+		select {
+		case <-time.After(readinessDelay):
+		case <-ctx.Done():
+		}
+		return nil
+	})
+	app.OnStop("http-server", cfg.Shutdown.HTTPTimeout, server.Shutdown)
+	app.OnStop("background-workers", cfg.Shutdown.WorkersTimeout, app.StopWorkers)
+	app.OnStop("kafka-producer", cfg.Shutdown.KafkaTimeout, func(context.Context) error {
+		return kafka.CloseProducer()
+	})
+	app.OnStop("tracing", 5*time.Second, shutdownTracing)
+	app.OnStop("redis", 5*time.Second, func(context.Context) error { return config.CloseRedis() })
+	app.OnStop("postgres", 5*time.Second, func(context.Context) error { return config.ClosePostgres() })
+
+	serverErr := make(chan error, 1)
+	go func() {
+		slog.Info("Server running", "address", hostname)
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			serverErr <- err
+		}
+	}()
+
+	select {
+	case <-signals.Done():
+		slog.Info("Received shutdown signal, shutting down gracefully")
+	case err := <-serverErr:
+		slog.Error("Server failed", "error", err)
+	}
+	stop()
+
+	if err := app.Shutdown(cfg.Shutdown.Timeout); err != nil {
+		return fmt.Errorf("graceful shutdown incomplete: %w", err)
+	}
+	slog.Info("Server stopped")
+	return nil
+}
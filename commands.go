@@ -0,0 +1,34 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+)
+
+// command is a subcommand of the binary. Each command connects only to the dependencies it uses.
+type command struct {
+	name  string
+	usage string
+	run   func(args []string) error
+}
+
+var commands = []command{
+	{"serve", "serve                          run the API server (default)", serve},
+	{"migrate", "migrate up | down [steps] | status\n                                 apply, roll back or list the database migrations", runMigrate},
+	{"seed", "seed [--count N]               insert N generated books into Postgres", runSeed},
+	{"cache", "cache warm | flush | verify | check [--sample N] | bench [--books N] [--redis] | cleanup [--other-encodings] [--dry-run]\n                                 fill, empty or check the book cache against Postgres, compare its encodings or delete its old keys", runCache},
+	{"kafka", "kafka topics create [--partitions N] [--replication-factor N]\n                                 create KAFKA_TOPIC", runKafka},
+}
+
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintln(out, "usage: book-management-store [--config file] <command> [arguments]")
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, "commands:")
+	for _, cmd := range commands {
+		fmt.Fprintln(out, "  "+cmd.usage)
+	}
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, "flags:")
+	flag.PrintDefaults()
+}
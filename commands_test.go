@@ -0,0 +1,52 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/arepala-uml/books-management-system/pkg/config"
+	"github.com/arepala-uml/books-management-system/pkg/testutil"
+)
+
+// TestCommandArguments checks wrong arguments are refused before a command connects to anything, none of
+// which is reachable here
+func TestCommandArguments(t *testing.T) {
+	tests := []struct {
+		name string
+		run  func([]string) error
+		args []string
+	}{
+		{"migrate", runMigrate, nil},
+		{"seed", runSeed, []string{"--count", "0"}},
+		{"cache", runCache, nil},
+		{"cache", runCache, []string{"warm", "now"}},
+		{"cache", runCache, []string{"cleanup", "now"}},
+		{"cache", runCache, []string{"bench", "--books", "0"}},
+		{"kafka", runKafka, []string{"topics"}},
+		{"kafka", runKafka, []string{"topics", "create", "--partitions", "0"}},
+	}
+	for _, tt := range tests {
+		if err := tt.run(tt.args); err == nil {
+			t.Errorf("%s %v accepted wrong arguments", tt.name, tt.args)
+		}
+	}
+}
+
+// TestCacheCleanupConnectsToRedisOnly runs cache cleanup with Postgres and Kafka unreachable
+func TestCacheCleanupConnectsToRedisOnly(t *testing.T) {
+	server := testutil.Redis(t, map[string]string{
+		"LOG_FILE_PATH": filepath.Join(t.TempDir(), "app.log"),
+		"POSTGRES_HOST": "127.0.0.1",
+		"POSTGRES_PORT": "1",
+		"KAFKA_HOST":    "127.0.0.1",
+		"KAFKA_PORT":    "1",
+	})
+	server.Set(config.RedisKey("BOOKS_ID:1"), "{}")
+
+	if err := runCache([]string{"cleanup"}); err != nil {
+		t.Fatalf("cache cleanup error = %v", err)
+	}
+	if server.Exists(config.RedisKey("BOOKS_ID:1")) {
+		t.Error("cache cleanup kept a key written before the keys were versioned")
+	}
+}
@@ -1,93 +1,75 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"io"
-	"net/http"
+	"log/slog"
 	"os"
-	"path/filepath"
 
 	_ "github.com/arepala-uml/books-management-system/docs"
 
 	"github.com/arepala-uml/books-management-system/pkg/config"
-	"github.com/arepala-uml/books-management-system/pkg/kafka"
-	"github.com/arepala-uml/books-management-system/pkg/models"
-	"github.com/arepala-uml/books-management-system/pkg/routes"
-	"github.com/gin-gonic/gin"
-	ginSwagger "github.com/swaggo/gin-swagger"
-	"github.com/swaggo/gin-swagger/swaggerFiles"
-
-	"github.com/fsnotify/fsnotify"
-	"github.com/labstack/gommon/log"
-	"github.com/spf13/viper"
+	"github.com/arepala-uml/books-management-system/pkg/logging"
 )
 
-func InitConfig() {
-	// Get the current working directory
-	pwd, err := os.Getwd()
-	if err != nil {
-		log.Fatalf("Failed to get current working directory: %v", err)
-	}
-
-	// Build the full path to app.env dynamically
-	configPath := filepath.Join(pwd, "app.env")
-	log.Info(configPath)
+var configPath = flag.String("config", "", "path to the env file, defaults to app.env in the working directory or next to the binary")
 
-	viper.SetConfigFile(configPath)
-
-	if err := viper.ReadInConfig(); err != nil {
-		log.Fatalf("Error reading config file, %s", err)
+// InitConfig loads and validates the configuration, exiting with every problem found when it is invalid
+func InitConfig(path string) *config.Config {
+	cfg, err := config.Init(path)
+	if err != nil {
+		logging.Fatal("Failed to load configuration", "error", err)
 	}
-
-	viper.WatchConfig()
-	viper.OnConfigChange(func(e fsnotify.Event) {
-		log.Printf("Config file changed: %s", e.Name)
-	})
-
-	viper.Set("PWD", pwd)
+	return cfg
 }
 
-func init() {
-	InitConfig()
-	log.Info("creating logger for agent")
-	LogLocation := viper.GetString("LOG_FILE_PATH")
-	log.Infof("%s file is being used for logging ", LogLocation)
+// initLogging loads the configuration and writes the logs to stdout and LOG_FILE_PATH
+func initLogging() *config.Config {
+	cfg := InitConfig(*configPath)
+	LogLocation := cfg.Log.FilePath
 	f, err := os.OpenFile(LogLocation, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
 	if err != nil {
-		log.Fatalf("error opening file: %v", err)
+		logging.Fatal("Error opening log file", "path", LogLocation, "error", err)
 	}
 	wrt := io.MultiWriter(os.Stdout, f)
-	log.SetOutput(wrt)
-	config.Connect()
-	models.DB = config.GetDB()
-	// Auto-migrate the Book model to keep the database schema updated
-	models.DB.AutoMigrate(&models.Book{})
+	logging.Setup(wrt, cfg.Log.Level)
+	// Secrets are redacted when the configuration is logged
+	slog.Info("Configuration loaded", "path", cfg.File(), "config", cfg)
+	return cfg
 }
 
+// @title Books Management System
+// @description API documentation for managing books in the store
+// @securityDefinitions.apikey BearerAuth
+// @in header
+// @name Authorization
+// @description Access token from /auth/token, sent as "Bearer <token>"
+// @securityDefinitions.apikey ApiKeyAuth
+// @in header
+// @name X-API-Key
+// @description API key issued through /api-keys
 func main() {
-	fmt.Println("Hi")
-	r := gin.Default()
+	flag.Usage = usage
+	flag.Parse()
 
-	go func() {
-		brokerList := []string{fmt.Sprintf("%s:%s", viper.GetString("KAFKA_HOST"), viper.GetString("KAFKA_PORT"))}
-		topic := viper.GetString("KAFKA_TOPIC")
-		log.Infof("Broker list : %v", brokerList)
-		err := kafka.StartConsumer(brokerList, topic)
-		if err != nil {
-			log.Errorf("Error in consumer: %v", err)
-		} else {
-			log.Info("Kafka consumer started")
+	name := flag.Arg(0)
+	if name == "" {
+		name = "serve"
+	}
+	for _, cmd := range commands {
+		if cmd.name == name {
+			args := flag.Args()
+			if len(args) > 0 {
+				args = args[1:]
+			}
+			if err := cmd.run(args); err != nil {
+				logging.Fatal("Command failed", "command", name, "error", err)
+			}
+			return
 		}
-	}()
-
-	// Register the routes for the Book Store API
-	routes.RegisterBookStoreRoutes(r)
-
-	// Swagger UI endpoint
-	r.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
-
-	hostname := viper.GetString("SERVER_HOST") + ":" + viper.GetString("SERVER_PORT")
-	log.Info("Server running on ", hostname)
-
-	log.Fatal(http.ListenAndServe(hostname, r))
+	}
+	fmt.Fprintf(flag.CommandLine.Output(), "unknown command %q\n\n", name)
+	usage()
+	os.Exit(2)
 }
@@ -0,0 +1,197 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/arepala-uml/books-management-system/pkg/config"
+	"github.com/arepala-uml/books-management-system/pkg/models"
+	"github.com/go-redis/redis/v8"
+	"gorm.io/gorm"
+)
+
+const (
+	apiKeyPrefix      = "bms_"
+	apiKeyPrefixChars = 12
+)
+
+var ErrInvalidAPIKey = errors.New("invalid, expired or revoked API key")
+
+// scopePermissions lists the permissions granted by each API key scope
+var scopePermissions = map[string][]string{
+	models.APIKeyScopeRead: {PermBooksRead, PermMembersRead},
+	models.APIKeyScopeWrite: {
+		PermBooksRead, PermMembersRead,
+		PermBooksWrite, PermReviewsWrite, PermMembersWrite, PermLoansWrite, PermLedgerWrite,
+	},
+}
+
+// ValidScope reports whether scope can be granted to an API key
+func ValidScope(scope string) bool {
+	_, ok := scopePermissions[scope]
+	return ok
+}
+
+// cachedAPIKey is the result of validating an API key as stored in Redis.
+// Invalid keys are cached too so guessing keys doesn't reach Postgres.
+type cachedAPIKey struct {
+	Valid     bool       `json:"valid"`
+	ID        int        `json:"id,omitempty"`
+	Name      string     `json:"name,omitempty"`
+	Scopes    []string   `json:"scopes,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+func apiKeyCacheKey(hash string) string {
+	return config.RedisKey(fmt.Sprintf("API_KEY:%s", hash))
+}
+
+// IssueAPIKey creates a new API key and returns it with its plaintext value, which is not stored
+func IssueAPIKey(name string, scopes []string, expiresAt *time.Time, createdBy int) (models.APIKey, string, error) {
+	for _, scope := range scopes {
+		if !ValidScope(scope) {
+			return models.APIKey{}, "", fmt.Errorf("unknown scope %q", scope)
+		}
+	}
+
+	secret, err := randomToken()
+	if err != nil {
+		return models.APIKey{}, "", err
+	}
+	plaintext := apiKeyPrefix + secret
+
+	key := models.APIKey{
+		Name:      name,
+		Prefix:    plaintext[:apiKeyPrefixChars],
+		KeyHash:   hashToken(plaintext),
+		Scopes:    scopes,
+		CreatedBy: createdBy,
+		ExpiresAt: expiresAt,
+	}
+	if err := config.GetDB().Create(&key).Error; err != nil {
+		return models.APIKey{}, "", err
+	}
+	return key, plaintext, nil
+}
+
+// ListAPIKeys returns every API key, newest first
+func ListAPIKeys() ([]models.APIKey, error) {
+	keys := make([]models.APIKey, 0)
+	err := config.GetDB().Order("created_at DESC, id DESC").Find(&keys).Error
+	return keys, err
+}
+
+// RevokeAPIKey revokes an API key and drops its cached validation so it stops working immediately
+func RevokeAPIKey(id int) (models.APIKey, error) {
+	var key models.APIKey
+	if err := config.GetDB().First(&key, id).Error; err != nil {
+		return key, err
+	}
+	if key.RevokedAt == nil {
+		now := time.Now()
+		key.RevokedAt = &now
+		if err := config.GetDB().Model(&key).Update("revoked_at", now).Error; err != nil {
+			return key, err
+		}
+	}
+
+	if err := config.GetRedisClient().Del(context.Background(), apiKeyCacheKey(key.KeyHash)).Err(); err != nil {
+		slog.Error("Failed to remove revoked API key from the cache", "api_key_id", id, "error", err)
+	}
+	return key, nil
+}
+
+// negativeCacheTTL caps how long an unknown or revoked key stays cached as invalid. Guessed keys only
+// occupy Redis briefly, and the failed authentications are limited per client IP by the rate limiter.
+const negativeCacheTTL = 10 * time.Second
+
+// ValidateAPIKey resolves the principal of an API key. Validation results are cached in Redis
+// for API_KEY_CACHE_TTL seconds so the hot path doesn't query Postgres, and invalid keys for
+// negativeCacheTTL at most.
+func ValidateAPIKey(ctx context.Context, plaintext string) (*Principal, error) {
+	hash := hashToken(plaintext)
+	cacheKey := apiKeyCacheKey(hash)
+
+	var cached cachedAPIKey
+	data, err := config.GetRedisClient().Get(ctx, cacheKey).Bytes()
+	if err == nil && json.Unmarshal(data, &cached) == nil {
+		return principalForKey(ctx, cached)
+	} else if err != nil && err != redis.Nil {
+		slog.ErrorContext(ctx, "Failed to read API key from the cache", "error", err)
+	}
+
+	cached, err = loadAPIKey(ctx, hash)
+	if err != nil {
+		return nil, err
+	}
+
+	ttl := time.Duration(config.Get().Auth.APIKeyCacheTTLSeconds) * time.Second
+	if cached.ExpiresAt != nil && time.Until(*cached.ExpiresAt) < ttl {
+		ttl = time.Until(*cached.ExpiresAt)
+	}
+	if !cached.Valid {
+		ttl = min(ttl, negativeCacheTTL)
+	}
+	if ttl > 0 {
+		if data, err := json.Marshal(cached); err == nil {
+			if err := config.GetRedisClient().Set(ctx, cacheKey, data, ttl).Err(); err != nil {
+				slog.ErrorContext(ctx, "Failed to cache API key", "error", err)
+			}
+		}
+	}
+	return principalForKey(ctx, cached)
+}
+
+func loadAPIKey(ctx context.Context, hash string) (cachedAPIKey, error) {
+	var key models.APIKey
+	err := config.GetDB().WithContext(ctx).Where("key_hash = ?", hash).First(&key).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return cachedAPIKey{Valid: false}, nil
+	} else if err != nil {
+		return cachedAPIKey{}, err
+	}
+	if key.RevokedAt != nil {
+		return cachedAPIKey{Valid: false}, nil
+	}
+	return cachedAPIKey{
+		Valid:     true,
+		ID:        key.ID,
+		Name:      key.Name,
+		Scopes:    key.Scopes,
+		ExpiresAt: key.ExpiresAt,
+	}, nil
+}
+
+func principalForKey(ctx context.Context, cached cachedAPIKey) (*Principal, error) {
+	if !cached.Valid || (cached.ExpiresAt != nil && time.Now().After(*cached.ExpiresAt)) {
+		return nil, ErrInvalidAPIKey
+	}
+	touchAPIKey(ctx, cached.ID)
+	return &Principal{
+		Username: "api-key:" + cached.Name,
+		APIKeyID: cached.ID,
+		Scopes:   cached.Scopes,
+	}, nil
+}
+
+// touchAPIKey records when an API key was last used, writing to Postgres at most once a minute per key
+func touchAPIKey(ctx context.Context, id int) {
+	first, err := config.GetRedisClient().SetNX(ctx, config.RedisKey(fmt.Sprintf("API_KEY_USED:%d", id)), 1, time.Minute).Result()
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to throttle last-used tracking of API key", "api_key_id", id, "error", err)
+		return
+	}
+	if !first {
+		return
+	}
+	go func() {
+		err := config.GetDB().Model(&models.APIKey{}).Where("id = ?", id).Update("last_used_at", time.Now()).Error
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to record last use of API key", "api_key_id", id, "error", err)
+		}
+	}()
+}
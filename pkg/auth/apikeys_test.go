@@ -0,0 +1,100 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/arepala-uml/books-management-system/pkg/config"
+	"github.com/arepala-uml/books-management-system/pkg/models"
+	"github.com/arepala-uml/books-management-system/pkg/testutil"
+	"github.com/gin-gonic/gin"
+)
+
+func TestScopePermissions(t *testing.T) {
+	tests := []struct {
+		scopes     []string
+		permission string
+		want       bool
+	}{
+		{[]string{models.APIKeyScopeRead}, PermBooksRead, true},
+		{[]string{models.APIKeyScopeRead}, PermBooksWrite, false},
+		{[]string{models.APIKeyScopeRead}, PermReviewsWrite, false},
+		{[]string{models.APIKeyScopeWrite}, PermBooksWrite, true},
+		{[]string{models.APIKeyScopeWrite}, PermLoansWrite, true},
+		// Deleting, moderating and administering stay with users
+		{[]string{models.APIKeyScopeWrite}, PermBooksDelete, false},
+		{[]string{models.APIKeyScopeWrite}, PermReviewsModerate, false},
+		{[]string{models.APIKeyScopeRead, models.APIKeyScopeWrite}, PermUsersAdmin, false},
+		{[]string{"admin"}, PermBooksRead, false},
+		{nil, PermBooksRead, false},
+	}
+	for _, tt := range tests {
+		p := &Principal{APIKeyID: 1, Scopes: tt.scopes}
+		if got := p.Can(tt.permission); got != tt.want {
+			t.Errorf("API key with scopes %v Can(%s) = %t, want %t", tt.scopes, tt.permission, got, tt.want)
+		}
+	}
+
+	// The roles of a user don't apply to an API key
+	p := &Principal{APIKeyID: 1, Roles: []string{RoleAdmin}, Scopes: []string{models.APIKeyScopeRead}}
+	if p.Can(PermBooksWrite) {
+		t.Error("API key with a read scope gained the permissions of a role")
+	}
+}
+
+func TestRequirePermissionEnforcesScopes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Set(principalKey, &Principal{APIKeyID: 7, Username: "api-key:importer", Scopes: []string{models.APIKeyScopeRead}})
+	})
+	ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+	router.GET("/books", RequirePermission(PermBooksRead), ok)
+	router.POST("/books", RequirePermission(PermBooksWrite), ok)
+
+	for method, want := range map[string]int{http.MethodGet: http.StatusOK, http.MethodPost: http.StatusForbidden} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, "/books", nil))
+		if w.Code != want {
+			t.Errorf("%s /books with a read scope status = %d, want %d", method, w.Code, want)
+		}
+	}
+}
+
+func TestValidateAPIKeyRejectsCachedInvalidKeys(t *testing.T) {
+	testutil.Redis(t, nil)
+	ctx := context.Background()
+	past := time.Now().Add(-time.Minute)
+	cache := func(plaintext string, cached cachedAPIKey) {
+		t.Helper()
+		data, _ := json.Marshal(cached)
+		if err := config.GetRedisClient().Set(ctx, apiKeyCacheKey(hashToken(plaintext)), data, time.Minute).Err(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cache("bms_revoked", cachedAPIKey{Valid: false})
+	cache("bms_expired", cachedAPIKey{Valid: true, ID: 3, Scopes: []string{models.APIKeyScopeWrite}, ExpiresAt: &past})
+
+	for _, plaintext := range []string{"bms_revoked", "bms_expired"} {
+		if _, err := ValidateAPIKey(ctx, plaintext); !errors.Is(err, ErrInvalidAPIKey) {
+			t.Errorf("ValidateAPIKey(%s) error = %v, want %v", plaintext, err, ErrInvalidAPIKey)
+		}
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/books", Authenticate(), func(c *gin.Context) { c.Status(http.StatusOK) })
+	req := httptest.NewRequest(http.MethodGet, "/books", nil)
+	req.Header.Set("X-API-Key", "bms_expired")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") == "" {
+		t.Errorf("request with an expired API key = %d with WWW-Authenticate %q, want 401 with a challenge",
+			w.Code, w.Header().Get("WWW-Authenticate"))
+	}
+}
@@ -0,0 +1,173 @@
+package auth
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"os"
+
+	"github.com/arepala-uml/books-management-system/pkg/config"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// keySet holds the keys used to sign and verify access tokens
+type keySet struct {
+	algorithm  string
+	keyID      string
+	secret     []byte
+	privateKey *rsa.PrivateKey
+	publicKeys map[string]*rsa.PublicKey
+}
+
+var keys *keySet
+
+// Init loads the signing and verification keys configured in app.env.
+// HS256 uses JWT_SECRET. RS256 signs with JWT_PRIVATE_KEY_FILE and verifies with
+// JWT_PUBLIC_KEY_FILE and/or the RSA keys of JWT_JWKS_FILE, looked up by key id.
+func Init() error {
+	cfg := config.Get().Auth
+	ks := &keySet{
+		algorithm:  cfg.JWTAlgorithm,
+		keyID:      cfg.JWTKeyID,
+		publicKeys: make(map[string]*rsa.PublicKey),
+	}
+	if ks.algorithm == "" {
+		ks.algorithm = jwt.SigningMethodHS256.Alg()
+	}
+
+	switch ks.algorithm {
+	case jwt.SigningMethodHS256.Alg():
+		ks.secret = []byte(cfg.JWTSecret.Reveal())
+		if len(ks.secret) < 32 {
+			return errors.New("JWT_SECRET must be at least 32 bytes for HS256")
+		}
+	case jwt.SigningMethodRS256.Alg():
+		if err := ks.loadRSAKeys(); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unsupported JWT_ALGORITHM %q, expected HS256 or RS256", ks.algorithm)
+	}
+
+	keys = ks
+	slog.Info("JWT authentication initialised", "algorithm", ks.algorithm)
+	return nil
+}
+
+func (ks *keySet) loadRSAKeys() error {
+	cfg := config.Get().Auth
+	if path := cfg.JWTPrivateKeyFile; path != "" {
+		pem, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read JWT_PRIVATE_KEY_FILE: %w", err)
+		}
+		ks.privateKey, err = jwt.ParseRSAPrivateKeyFromPEM(pem)
+		if err != nil {
+			return fmt.Errorf("failed to parse JWT_PRIVATE_KEY_FILE: %w", err)
+		}
+		ks.publicKeys[ks.keyID] = &ks.privateKey.PublicKey
+	}
+
+	if path := cfg.JWTPublicKeyFile; path != "" {
+		pem, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read JWT_PUBLIC_KEY_FILE: %w", err)
+		}
+		publicKey, err := jwt.ParseRSAPublicKeyFromPEM(pem)
+		if err != nil {
+			return fmt.Errorf("failed to parse JWT_PUBLIC_KEY_FILE: %w", err)
+		}
+		ks.publicKeys[ks.keyID] = publicKey
+	}
+
+	if path := cfg.JWTJWKSFile; path != "" {
+		jwksKeys, err := loadJWKS(path)
+		if err != nil {
+			return err
+		}
+		for kid, publicKey := range jwksKeys {
+			ks.publicKeys[kid] = publicKey
+		}
+	}
+
+	if len(ks.publicKeys) == 0 {
+		return errors.New("RS256 requires JWT_PRIVATE_KEY_FILE, JWT_PUBLIC_KEY_FILE or JWT_JWKS_FILE")
+	}
+	if ks.privateKey == nil {
+		slog.Warn("JWT_PRIVATE_KEY_FILE is not set, tokens can be verified but not issued")
+	}
+	return nil
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// loadJWKS reads the RSA signing keys from a JSON Web Key Set file
+func loadJWKS(path string) (map[string]*rsa.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read JWT_JWKS_FILE: %w", err)
+	}
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("failed to parse JWT_JWKS_FILE: %w", err)
+	}
+
+	publicKeys := make(map[string]*rsa.PublicKey)
+	for _, key := range set.Keys {
+		if key.Kty != "RSA" || (key.Use != "" && key.Use != "sig") {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(key.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid modulus for JWKS key %q: %w", key.Kid, err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(key.E)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exponent for JWKS key %q: %w", key.Kid, err)
+		}
+		publicKeys[key.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	if len(publicKeys) == 0 {
+		return nil, errors.New("JWT_JWKS_FILE contains no RSA signing keys")
+	}
+	slog.Info("Loaded keys from JWKS file", "keys", len(publicKeys), "path", path)
+	return publicKeys, nil
+}
+
+// signingKey returns the key used to sign new access tokens
+func (ks *keySet) signingKey() (interface{}, error) {
+	if ks.algorithm == jwt.SigningMethodHS256.Alg() {
+		return ks.secret, nil
+	}
+	if ks.privateKey == nil {
+		return nil, errors.New("no private key configured to issue RS256 tokens")
+	}
+	return ks.privateKey, nil
+}
+
+// verificationKey is the jwt.Keyfunc resolving the key an access token was signed with
+func (ks *keySet) verificationKey(token *jwt.Token) (interface{}, error) {
+	if ks.algorithm == jwt.SigningMethodHS256.Alg() {
+		return ks.secret, nil
+	}
+	kid, _ := token.Header["kid"].(string)
+	if publicKey, ok := ks.publicKeys[kid]; ok {
+		return publicKey, nil
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
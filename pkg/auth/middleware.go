@@ -0,0 +1,83 @@
+package auth
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const principalKey = "principal"
+
+// Principal is the authenticated caller of a request
+type Principal struct {
+	UserID   int
+	Username string
+	// Roles are loaded from Postgres when a permission is first checked
+	Roles []string
+
+	// APIKeyID and Scopes are set instead of a user when authenticated with an API key
+	APIKeyID int
+	Scopes   []string
+}
+
+// Authenticate is a gin middleware rejecting requests without a valid bearer access token
+// or X-API-Key header. The authenticated principal is available to handlers through GetPrincipal.
+func Authenticate() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if apiKey := c.GetHeader("X-API-Key"); apiKey != "" {
+			principal, err := ValidateAPIKey(c.Request.Context(), apiKey)
+			if errors.Is(err, ErrInvalidAPIKey) {
+				unauthorized(c, "Invalid, expired or revoked API key")
+				return
+			} else if err != nil {
+				slog.ErrorContext(c.Request.Context(), "Failed to validate API key", "error", err)
+				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Error validating API key"})
+				return
+			}
+			c.Set(principalKey, principal)
+			c.Next()
+			return
+		}
+
+		header := c.GetHeader("Authorization")
+		tokenString, found := strings.CutPrefix(header, "Bearer ")
+		if !found || tokenString == "" {
+			unauthorized(c, "Missing bearer token")
+			return
+		}
+
+		claims, err := ParseAccessToken(tokenString)
+		if err != nil {
+			slog.InfoContext(c.Request.Context(), "Rejected access token", "error", err)
+			unauthorized(c, "Invalid or expired token")
+			return
+		}
+		userID, err := strconv.Atoi(claims.Subject)
+		if err != nil {
+			unauthorized(c, "Invalid or expired token")
+			return
+		}
+
+		c.Set(principalKey, &Principal{UserID: userID, Username: claims.Username})
+		c.Next()
+	}
+}
+
+// GetPrincipal returns the principal authenticated by the Authenticate middleware
+func GetPrincipal(c *gin.Context) (*Principal, bool) {
+	value, ok := c.Get(principalKey)
+	if !ok {
+		return nil, false
+	}
+	principal, ok := value.(*Principal)
+	return principal, ok
+}
+
+func unauthorized(c *gin.Context, message string) {
+	c.Header("WWW-Authenticate", `Bearer realm="books-management-system"`)
+	c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": message})
+}
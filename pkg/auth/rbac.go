@@ -0,0 +1,141 @@
+package auth
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+
+	"github.com/arepala-uml/books-management-system/pkg/config"
+	"github.com/arepala-uml/books-management-system/pkg/models"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Roles that can be assigned to users
+const (
+	RoleViewer    = "viewer"
+	RoleLibrarian = "librarian"
+	RoleAdmin     = "admin"
+)
+
+// Permissions checked by RequirePermission
+const (
+	PermBooksRead       = "books:read"
+	PermBooksWrite      = "books:write"
+	PermBooksDelete     = "books:delete"
+	PermReviewsWrite    = "reviews:write"
+	PermReviewsModerate = "reviews:moderate"
+	PermMembersRead     = "members:read"
+	PermMembersWrite    = "members:write"
+	PermLoansWrite      = "loans:write"
+	PermLedgerWrite     = "ledger:write"
+	PermUsersAdmin      = "users:admin"
+	PermCacheAdmin      = "cache:admin"
+)
+
+var viewerPermissions = []string{PermBooksRead, PermReviewsWrite}
+
+var librarianPermissions = append([]string{
+	PermBooksWrite, PermReviewsModerate, PermMembersRead, PermMembersWrite, PermLoansWrite, PermLedgerWrite,
+}, viewerPermissions...)
+
+var adminPermissions = append([]string{PermBooksDelete, PermUsersAdmin, PermCacheAdmin}, librarianPermissions...)
+
+// rolePermissions lists the permissions granted by each role
+var rolePermissions = map[string][]string{
+	RoleViewer:    viewerPermissions,
+	RoleLibrarian: librarianPermissions,
+	RoleAdmin:     adminPermissions,
+}
+
+// ValidRole reports whether role is one of the defined roles
+func ValidRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+// Can reports whether any role of the principal, or any scope of its API key, grants the permission
+func (p *Principal) Can(permission string) bool {
+	if p.APIKeyID != 0 {
+		for _, scope := range p.Scopes {
+			for _, granted := range scopePermissions[scope] {
+				if granted == permission {
+					return true
+				}
+			}
+		}
+		return false
+	}
+
+	for _, role := range p.Roles {
+		for _, granted := range rolePermissions[role] {
+			if granted == permission {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// RequirePermission is a gin middleware allowing only principals holding the permission.
+// It must run after Authenticate.
+func RequirePermission(permission string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		principal, ok := GetPrincipal(c)
+		if !ok {
+			unauthorized(c, "Missing bearer token")
+			return
+		}
+
+		if principal.APIKeyID == 0 && principal.Roles == nil {
+			roles, err := UserRoles(principal.UserID)
+			if err != nil {
+				slog.ErrorContext(c.Request.Context(), "Failed to load roles of user", "user_id", principal.UserID, "error", err)
+				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Error checking permissions"})
+				return
+			}
+			principal.Roles = roles
+		}
+
+		if !principal.Can(permission) {
+			slog.InfoContext(c.Request.Context(), "Permission denied", "username", principal.Username, "permission", permission,
+				"method", c.Request.Method)
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"error":   "Forbidden",
+				"details": []string{fmt.Sprintf("missing permission %s", permission)},
+			})
+			return
+		}
+		c.Next()
+	}
+}
+
+// UserRoles returns the roles assigned to a user
+func UserRoles(userID int) ([]string, error) {
+	roles := make([]string, 0)
+	err := config.GetDB().Model(&models.UserRole{}).Where("user_id = ?", userID).Order("role").Pluck("role", &roles).Error
+	return roles, err
+}
+
+// SetUserRoles replaces the roles assigned to a user
+func SetUserRoles(userID int, roles []string) error {
+	for _, role := range roles {
+		if !ValidRole(role) {
+			return fmt.Errorf("unknown role %q", role)
+		}
+	}
+	sort.Strings(roles)
+
+	return config.GetDB().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&models.UserRole{}).Error; err != nil {
+			return err
+		}
+		for _, role := range roles {
+			if err := tx.Create(&models.UserRole{UserID: userID, Role: role}).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,86 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRolePermissions(t *testing.T) {
+	tests := []struct {
+		roles      []string
+		permission string
+		want       bool
+	}{
+		{[]string{RoleViewer}, PermBooksRead, true},
+		{[]string{RoleViewer}, PermReviewsWrite, true},
+		{[]string{RoleViewer}, PermBooksWrite, false},
+		{[]string{RoleViewer}, PermReviewsModerate, false},
+		{[]string{RoleLibrarian}, PermBooksRead, true},
+		{[]string{RoleLibrarian}, PermReviewsModerate, true},
+		{[]string{RoleLibrarian}, PermLedgerWrite, true},
+		{[]string{RoleLibrarian}, PermBooksDelete, false},
+		{[]string{RoleLibrarian}, PermUsersAdmin, false},
+		{[]string{RoleAdmin}, PermBooksDelete, true},
+		{[]string{RoleAdmin}, PermCacheAdmin, true},
+		{[]string{RoleAdmin}, PermBooksRead, true},
+		// Permissions add up across roles, unknown roles grant none
+		{[]string{RoleViewer, RoleLibrarian}, PermLoansWrite, true},
+		{[]string{"superuser"}, PermBooksRead, false},
+		{nil, PermBooksRead, false},
+	}
+	for _, tt := range tests {
+		p := &Principal{UserID: 1, Roles: tt.roles}
+		if got := p.Can(tt.permission); got != tt.want {
+			t.Errorf("user with roles %v Can(%s) = %t, want %t", tt.roles, tt.permission, got, tt.want)
+		}
+	}
+
+	for _, role := range []string{RoleViewer, RoleLibrarian, RoleAdmin} {
+		if !ValidRole(role) {
+			t.Errorf("ValidRole(%s) = false", role)
+		}
+	}
+	if ValidRole("superuser") {
+		t.Error("ValidRole() accepted an undefined role")
+	}
+}
+
+func TestRequirePermission(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+	route := func(principal *Principal) *gin.Engine {
+		router := gin.New()
+		if principal != nil {
+			router.Use(func(c *gin.Context) { c.Set(principalKey, principal) })
+		}
+		router.GET("/books", RequirePermission(PermBooksRead), ok)
+		router.DELETE("/books/:id", RequirePermission(PermBooksDelete), ok)
+		return router
+	}
+
+	tests := []struct {
+		name      string
+		principal *Principal
+		method    string
+		target    string
+		want      int
+	}{
+		{"unauthenticated", nil, http.MethodGet, "/books", http.StatusUnauthorized},
+		{"viewer reads", &Principal{UserID: 1, Roles: []string{RoleViewer}}, http.MethodGet, "/books", http.StatusOK},
+		{"viewer deletes", &Principal{UserID: 1, Roles: []string{RoleViewer}}, http.MethodDelete, "/books/1", http.StatusForbidden},
+		{"librarian deletes", &Principal{UserID: 2, Roles: []string{RoleLibrarian}}, http.MethodDelete, "/books/1", http.StatusForbidden},
+		{"admin deletes", &Principal{UserID: 3, Roles: []string{RoleAdmin}}, http.MethodDelete, "/books/1", http.StatusOK},
+		// A user whose roles were loaded as none can't do anything
+		{"user without roles", &Principal{UserID: 4, Roles: []string{}}, http.MethodGet, "/books", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		route(tt.principal).ServeHTTP(w, httptest.NewRequest(tt.method, tt.target, nil))
+		if w.Code != tt.want {
+			t.Errorf("%s: %s %s status = %d, want %d", tt.name, tt.method, tt.target, w.Code, tt.want)
+		}
+	}
+}
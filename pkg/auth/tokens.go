@@ -0,0 +1,208 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/arepala-uml/books-management-system/pkg/config"
+	"github.com/arepala-uml/books-management-system/pkg/models"
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrInvalidCredentials = errors.New("invalid username or password")
+	ErrInvalidToken       = errors.New("invalid or expired token")
+)
+
+// Claims are the claims carried by an access token
+type Claims struct {
+	Username string `json:"username"`
+	jwt.RegisteredClaims
+}
+
+// TokenPair is an access token together with the refresh token to renew it
+type TokenPair struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+func accessTokenTTL() time.Duration {
+	return config.Get().Auth.AccessTokenTTL
+}
+
+func refreshTokenTTL() time.Duration {
+	return config.Get().Auth.RefreshTokenTTL
+}
+
+// Login checks the password of a local user and issues a new token pair
+func Login(username string, password string) (TokenPair, error) {
+	var user models.User
+	if err := config.GetDB().Where("username = ?", username).First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			// Compare against a dummy hash so unknown users take as long as wrong passwords
+			bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+			return TokenPair{}, ErrInvalidCredentials
+		}
+		return TokenPair{}, err
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)); err != nil {
+		return TokenPair{}, ErrInvalidCredentials
+	}
+
+	var pair TokenPair
+	err := config.GetDB().Transaction(func(tx *gorm.DB) error {
+		var err error
+		pair, err = issueTokenPair(tx, user)
+		return err
+	})
+	return pair, err
+}
+
+// Refresh exchanges a refresh token for a new token pair. Refresh tokens are single use:
+// the presented token is revoked, and presenting an already revoked token revokes every
+// refresh token of the user since the token has probably been stolen.
+func Refresh(refreshToken string) (TokenPair, error) {
+	var pair TokenPair
+	reusedBy := 0
+	err := config.GetDB().Transaction(func(tx *gorm.DB) error {
+		var stored models.RefreshToken
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("token_hash = ?", hashToken(refreshToken)).
+			First(&stored).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrInvalidToken
+		} else if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		if stored.RevokedAt != nil {
+			reusedBy = stored.UserID
+			return ErrInvalidToken
+		}
+		if now.After(stored.ExpiresAt) {
+			return ErrInvalidToken
+		}
+		if err := tx.Model(&stored).Update("revoked_at", now).Error; err != nil {
+			return err
+		}
+
+		var user models.User
+		if err := tx.First(&user, stored.UserID).Error; err != nil {
+			return err
+		}
+		pair, err = issueTokenPair(tx, user)
+		return err
+	})
+
+	if reusedBy != 0 {
+		slog.Warn("Revoked refresh token was presented again, revoking all refresh tokens of the user", "user_id", reusedBy)
+		revokeErr := config.GetDB().Model(&models.RefreshToken{}).
+			Where("user_id = ? AND revoked_at IS NULL", reusedBy).
+			Update("revoked_at", time.Now()).Error
+		if revokeErr != nil {
+			slog.Error("Failed to revoke refresh tokens of user", "user_id", reusedBy, "error", revokeErr)
+		}
+	}
+	return pair, err
+}
+
+func issueTokenPair(tx *gorm.DB, user models.User) (TokenPair, error) {
+	accessToken, err := issueAccessToken(user)
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	refreshToken, err := randomToken()
+	if err != nil {
+		return TokenPair{}, err
+	}
+	stored := models.RefreshToken{
+		UserID:    user.ID,
+		TokenHash: hashToken(refreshToken),
+		ExpiresAt: time.Now().Add(refreshTokenTTL()),
+	}
+	if err := tx.Create(&stored).Error; err != nil {
+		return TokenPair{}, err
+	}
+
+	return TokenPair{
+		AccessToken:  accessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(accessTokenTTL().Seconds()),
+		RefreshToken: refreshToken,
+	}, nil
+}
+
+func issueAccessToken(user models.User) (string, error) {
+	key, err := keys.signingKey()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	claims := Claims{
+		Username: user.Username,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   strconv.Itoa(user.ID),
+			Issuer:    config.Get().Auth.JWTIssuer,
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(accessTokenTTL())),
+		},
+	}
+	if audience := config.Get().Auth.JWTAudience; audience != "" {
+		claims.Audience = jwt.ClaimStrings{audience}
+	}
+
+	token := jwt.NewWithClaims(jwt.GetSigningMethod(keys.algorithm), claims)
+	if keys.keyID != "" {
+		token.Header["kid"] = keys.keyID
+	}
+	return token.SignedString(key)
+}
+
+// ParseAccessToken verifies the signature and claims of an access token
+func ParseAccessToken(tokenString string) (*Claims, error) {
+	options := []jwt.ParserOption{
+		jwt.WithValidMethods([]string{keys.algorithm}),
+		jwt.WithExpirationRequired(),
+	}
+	if issuer := config.Get().Auth.JWTIssuer; issuer != "" {
+		options = append(options, jwt.WithIssuer(issuer))
+	}
+	if audience := config.Get().Auth.JWTAudience; audience != "" {
+		options = append(options, jwt.WithAudience(audience))
+	}
+
+	claims := &Claims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, keys.verificationKey, options...)
+	if err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+func randomToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
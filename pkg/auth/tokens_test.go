@@ -0,0 +1,137 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/arepala-uml/books-management-system/pkg/config"
+	"github.com/arepala-uml/books-management-system/pkg/models"
+	"github.com/arepala-uml/books-management-system/pkg/testutil"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// setupTokens loads the configuration with an issuer and an audience and the HS256 keys
+func setupTokens(t *testing.T) {
+	t.Helper()
+	testutil.Config(t, map[string]string{"JWT_ISSUER": "books-test", "JWT_AUDIENCE": "books-api"})
+	if err := Init(); err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+}
+
+// sign returns a token with the claims signed by method with secret
+func sign(t *testing.T, method jwt.SigningMethod, claims jwt.MapClaims, secret string) string {
+	t.Helper()
+	signed, err := jwt.NewWithClaims(method, claims).SignedString([]byte(secret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func TestAccessTokenRoundTrip(t *testing.T) {
+	setupTokens(t)
+	token, err := issueAccessToken(models.User{ID: 7, Username: "ada"})
+	if err != nil {
+		t.Fatalf("issueAccessToken() error = %v", err)
+	}
+	claims, err := ParseAccessToken(token)
+	if err != nil {
+		t.Fatalf("ParseAccessToken() error = %v", err)
+	}
+	if claims.Subject != "7" || claims.Username != "ada" || claims.Issuer != "books-test" {
+		t.Errorf("ParseAccessToken() = %+v, want user 7 ada issued by books-test", claims)
+	}
+	if ttl := time.Until(claims.ExpiresAt.Time); ttl <= 0 || ttl > config.Get().Auth.AccessTokenTTL {
+		t.Errorf("token expires in %s, want within JWT_ACCESS_TOKEN_TTL", ttl)
+	}
+}
+
+func TestParseAccessTokenRejects(t *testing.T) {
+	setupTokens(t)
+	secret := config.Get().Auth.JWTSecret.Reveal()
+	now := time.Now()
+	claims := func(changes jwt.MapClaims) jwt.MapClaims {
+		c := jwt.MapClaims{"sub": "7", "username": "ada", "iss": "books-test", "aud": "books-api",
+			"iat": now.Unix(), "exp": now.Add(time.Minute).Unix()}
+		for key, value := range changes {
+			if value == nil {
+				delete(c, key)
+			} else {
+				c[key] = value
+			}
+		}
+		return c
+	}
+
+	tests := map[string]string{
+		"expired":          testutil.Token(t, 7, -time.Minute),
+		"without expiry":   sign(t, jwt.SigningMethodHS256, claims(jwt.MapClaims{"exp": nil}), secret),
+		"another secret":   sign(t, jwt.SigningMethodHS256, claims(nil), strings.Repeat("x", 32)),
+		"another method":   sign(t, jwt.SigningMethodHS512, claims(nil), secret),
+		"another issuer":   sign(t, jwt.SigningMethodHS256, claims(jwt.MapClaims{"iss": "elsewhere"}), secret),
+		"another audience": sign(t, jwt.SigningMethodHS256, claims(jwt.MapClaims{"aud": "other-api"}), secret),
+		"unsigned":         "eyJhbGciOiJub25lIn0.eyJzdWIiOiI3In0.",
+	}
+	if _, err := ParseAccessToken(sign(t, jwt.SigningMethodHS256, claims(nil), secret)); err != nil {
+		t.Fatalf("ParseAccessToken() of a valid token error = %v", err)
+	}
+	for name, token := range tests {
+		if _, err := ParseAccessToken(token); err == nil {
+			t.Errorf("ParseAccessToken() accepted a token %s", name)
+		}
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	setupTokens(t)
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/me", Authenticate(), func(c *gin.Context) {
+		principal, _ := GetPrincipal(c)
+		c.JSON(http.StatusOK, gin.H{"user_id": principal.UserID})
+	})
+
+	tests := []struct {
+		name          string
+		authorization string
+		want          int
+	}{
+		{"valid token", "Bearer " + testutil.Token(t, 7, time.Minute), http.StatusOK},
+		{"no header", "", http.StatusUnauthorized},
+		{"another scheme", "Basic " + testutil.Token(t, 7, time.Minute), http.StatusUnauthorized},
+		{"expired token", "Bearer " + testutil.Token(t, 7, -time.Minute), http.StatusUnauthorized},
+		{"malformed token", "Bearer not-a-token", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/me", nil)
+		if tt.authorization != "" {
+			req.Header.Set("Authorization", tt.authorization)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.name, w.Code, tt.want)
+		}
+		if tt.want == http.StatusUnauthorized && !strings.HasPrefix(w.Header().Get("WWW-Authenticate"), "Bearer") {
+			t.Errorf("%s: WWW-Authenticate = %q, want a Bearer challenge", tt.name, w.Header().Get("WWW-Authenticate"))
+		}
+		if tt.want == http.StatusOK && !strings.Contains(w.Body.String(), `"user_id":7`) {
+			t.Errorf("%s: response = %s, want the principal of user 7", tt.name, w.Body)
+		}
+	}
+}
+
+func TestSecretRequired(t *testing.T) {
+	testutil.Config(t, nil)
+	for _, secret := range []string{"", "too-short"} {
+		t.Setenv("JWT_SECRET", secret)
+		if _, err := config.Init(""); err == nil || !strings.Contains(err.Error(), "JWT_SECRET") {
+			t.Errorf("loading JWT_SECRET %q error = %v, want JWT_SECRET refused", secret, err)
+		}
+	}
+}
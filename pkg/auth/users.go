@@ -0,0 +1,54 @@
+package auth
+
+import (
+	"errors"
+	"log/slog"
+
+	"github.com/arepala-uml/books-management-system/pkg/config"
+	"github.com/arepala-uml/books-management-system/pkg/models"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// dummyHash is compared against when a username doesn't exist, to keep login timing uniform
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("books-management-system"), bcrypt.DefaultCost)
+
+// CreateUser stores a new local user with a bcrypt hash of its password
+func CreateUser(username string, password string) (models.User, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return models.User{}, err
+	}
+	user := models.User{Username: username, PasswordHash: string(hash)}
+	err = config.GetDB().Create(&user).Error
+	return user, err
+}
+
+// EnsureAdminUser creates the user AUTH_ADMIN_USERNAME with AUTH_ADMIN_PASSWORD and the admin role
+// when it doesn't exist yet, so a fresh installation has an account to sign in with
+func EnsureAdminUser() error {
+	cfg := config.Get().Auth
+	username := cfg.AdminUsername
+	password := cfg.AdminPassword.Reveal()
+	if username == "" || password == "" {
+		return nil
+	}
+
+	var user models.User
+	err := config.GetDB().Where("username = ?", username).First(&user).Error
+	if err == nil {
+		return nil
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+
+	user, err = CreateUser(username, password)
+	if err != nil {
+		return err
+	}
+	if err := SetUserRoles(user.ID, []string{RoleAdmin}); err != nil {
+		return err
+	}
+	slog.Info("Created the initial user", "username", username)
+	return nil
+}
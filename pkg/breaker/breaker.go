@@ -0,0 +1,143 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/arepala-uml/books-management-system/pkg/metrics"
+)
+
+// States of a circuit
+const (
+	StateClosed = "closed"
+	StateOpen   = "open"
+)
+
+// ErrOpen is returned instead of calling a dependency whose circuit is open
+var ErrOpen = errors.New("circuit breaker is open")
+
+// Settings tune every breaker, they are read on each failure and probe so a reload applies them live
+type Settings struct {
+	// FailureThreshold is the number of consecutive failures opening the circuit
+	FailureThreshold int
+	// ProbeInterval is how often the dependency is probed while the circuit is open
+	ProbeInterval time.Duration
+}
+
+// Breaker stops the calls to a dependency after consecutive failures so requests don't wait on it,
+// and probes it in the background until it answers again to close the circuit
+type Breaker struct {
+	name     string
+	settings func() Settings
+	probe    func(ctx context.Context) error
+	failures atomic.Int64
+	open     atomic.Bool
+
+	closeMu sync.Mutex
+	onClose []func()
+}
+
+var (
+	registryMu sync.Mutex
+	registry   = make(map[string]*Breaker)
+)
+
+// New returns the breaker of the dependency name, probed with probe while open
+func New(name string, settings func() Settings, probe func(ctx context.Context) error) *Breaker {
+	b := &Breaker{name: name, settings: settings, probe: probe}
+	registryMu.Lock()
+	registry[name] = b
+	registryMu.Unlock()
+	metrics.SetCircuitOpen(name, false)
+	return b
+}
+
+// Get returns the breaker of the dependency name, or nil when it has none
+func Get(name string) *Breaker {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	return registry[name]
+}
+
+// Allow returns ErrOpen while the circuit is open
+func (b *Breaker) Allow() error {
+	if b.open.Load() {
+		return fmt.Errorf("%s: %w", b.name, ErrOpen)
+	}
+	return nil
+}
+
+// Record counts the outcome of a call. A success closes the circuit, and FailureThreshold failures
+// in a row open it.
+func (b *Breaker) Record(err error) {
+	if err == nil {
+		b.failures.Store(0)
+		b.close()
+		return
+	}
+	if b.failures.Add(1) >= int64(b.settings().FailureThreshold) {
+		b.Trip(err)
+	}
+}
+
+// Trip opens the circuit and starts probing the dependency in the background
+func (b *Breaker) Trip(cause error) {
+	if !b.open.CompareAndSwap(false, true) {
+		return
+	}
+	slog.Warn("Circuit breaker opened, the service runs without the dependency", "dependency", b.name,
+		"error", cause)
+	metrics.SetCircuitOpen(b.name, true)
+	go b.recover()
+}
+
+// OnClose registers fn to be called in the background whenever the circuit closes, e.g. to drop
+// what went stale while the dependency was skipped
+func (b *Breaker) OnClose(fn func()) {
+	b.closeMu.Lock()
+	defer b.closeMu.Unlock()
+	b.onClose = append(b.onClose, fn)
+}
+
+// State is StateOpen while the circuit is open, StateClosed otherwise
+func (b *Breaker) State() string {
+	if b.open.Load() {
+		return StateOpen
+	}
+	return StateClosed
+}
+
+func (b *Breaker) close() {
+	if b.open.CompareAndSwap(true, false) {
+		slog.Info("Circuit breaker closed, the dependency is back", "dependency", b.name)
+		metrics.SetCircuitOpen(b.name, false)
+		b.closeMu.Lock()
+		defer b.closeMu.Unlock()
+		for _, fn := range b.onClose {
+			go fn()
+		}
+	}
+}
+
+// recover probes the dependency every ProbeInterval until it answers or a call closes the circuit
+func (b *Breaker) recover() {
+	for b.open.Load() {
+		interval := b.settings().ProbeInterval
+		time.Sleep(interval)
+
+		ctx, cancel := context.WithTimeout(context.Background(), interval)
+		err := b.probe(ctx)
+		cancel()
+		if err == nil {
+			b.failures.Store(0)
+			b.close()
+			return
+		}
+		slog.Debug("Dependency is still unavailable", "dependency", b.name, "error", err)
+	}
+}
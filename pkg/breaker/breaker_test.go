@@ -0,0 +1,86 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBreaker(t *testing.T) {
+	var down atomic.Bool
+	down.Store(true)
+	var probes atomic.Int64
+	settings := func() Settings { return Settings{FailureThreshold: 3, ProbeInterval: 10 * time.Millisecond} }
+	b := New("test", settings, func(ctx context.Context) error {
+		probes.Add(1)
+		if down.Load() {
+			return errors.New("connection refused")
+		}
+		return nil
+	})
+	closed := make(chan struct{}, 1)
+	b.OnClose(func() { closed <- struct{}{} })
+	failure := errors.New("connection refused")
+
+	// A success in between resets the count of consecutive failures
+	b.Record(failure)
+	b.Record(failure)
+	b.Record(nil)
+	b.Record(failure)
+	b.Record(failure)
+	if err := b.Allow(); err != nil || b.State() != StateClosed {
+		t.Fatalf("circuit is %s after failures below the threshold, Allow() = %v", b.State(), err)
+	}
+
+	b.Record(failure)
+	if err := b.Allow(); !errors.Is(err, ErrOpen) || b.State() != StateOpen {
+		t.Fatalf("circuit is %s after 3 failures in a row, Allow() = %v, want %v", b.State(), err, ErrOpen)
+	}
+	deadline := time.Now().Add(time.Second)
+	for probes.Load() < 2 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if b.State() != StateOpen {
+		t.Fatal("circuit closed while the probe fails")
+	}
+
+	down.Store(false)
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("circuit didn't close once the probe succeeded")
+	}
+	if err := b.Allow(); err != nil || b.State() != StateClosed {
+		t.Errorf("circuit is %s after recovering, Allow() = %v", b.State(), err)
+	}
+	if Get("test") != b {
+		t.Error("Get() doesn't return the registered breaker")
+	}
+}
+
+func TestBreakerClosedByCall(t *testing.T) {
+	settings := func() Settings { return Settings{FailureThreshold: 1, ProbeInterval: time.Hour} }
+	b := New("call", settings, func(ctx context.Context) error { return nil })
+	closed := make(chan struct{}, 1)
+	b.OnClose(func() { closed <- struct{}{} })
+
+	b.Trip(errors.New("timeout"))
+	if b.State() != StateOpen {
+		t.Fatal("Trip() didn't open the circuit")
+	}
+	// Calls allowed through despite the open circuit, e.g. PING, close it without waiting for the probe
+	b.Record(nil)
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("a successful call didn't close the circuit")
+	}
+	b.Record(nil)
+	select {
+	case <-closed:
+		t.Error("OnClose callbacks ran again for a closed circuit")
+	case <-time.After(20 * time.Millisecond):
+	}
+}
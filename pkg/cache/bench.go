@@ -0,0 +1,156 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/arepala-uml/books-management-system/pkg/config"
+	"github.com/arepala-uml/books-management-system/pkg/models"
+	"github.com/go-redis/redis/v8"
+)
+
+// benchKeyPrefix keeps the keys written by a benchmark apart from the cached books
+func benchKeyPrefix() string {
+	return config.RedisKey("BENCH:")
+}
+
+// BenchResult is the cost per book of an encoding. The Redis timings are zero when the benchmark
+// ran without Redis or Redis can't store the encoding.
+type BenchResult struct {
+	Encoding string
+	// Bytes is the average size of an encoded book
+	Bytes  float64
+	Encode time.Duration
+	Decode time.Duration
+	// Memory is the memory Redis used for the first book, as reported by MEMORY USAGE
+	Memory int64
+	// Set is the time to write a book in pipelines of batchSize books
+	Set time.Duration
+	// Get is the time to read a book with a round trip of its own
+	Get time.Duration
+	// GetMany is the time to read a book with MGET, or JSON.MGET, of batchSize books
+	GetMany time.Duration
+}
+
+// Bench measures each encoding on books, in process and, with client, against Redis. The keys it
+// writes in Redis are deleted once measured.
+func Bench(ctx context.Context, client redis.UniversalClient, books []models.Book) ([]BenchResult, error) {
+	if len(books) == 0 {
+		return nil, errors.New("no books to benchmark")
+	}
+	results := make([]BenchResult, 0, len(Encodings))
+	for _, encoding := range Encodings {
+		c := codecs[encoding]
+		result, err := benchCodec(c, books)
+		if err != nil {
+			return nil, err
+		}
+		if client != nil {
+			if encoding == EncodingJSON {
+				supported, err := HasRedisJSON(ctx, client)
+				if err != nil {
+					return nil, err
+				} else if !supported {
+					results = append(results, result)
+					continue
+				}
+			}
+			if err := benchRedis(ctx, client, c, books, &result); err != nil {
+				return nil, fmt.Errorf("benchmarking %s in Redis: %w", encoding, err)
+			}
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+func benchCodec(c codec, books []models.Book) (BenchResult, error) {
+	result := BenchResult{Encoding: c.name()}
+	encoded := make([][]byte, len(books))
+
+	// Warm up the encoder caches of the book type before timing
+	for _, book := range books[:min(len(books), batchSize)] {
+		data, err := c.encode(book)
+		if err != nil {
+			return result, err
+		}
+		var decoded models.Book
+		if err := c.decode(data, &decoded); err != nil {
+			return result, err
+		}
+	}
+
+	start := time.Now()
+	for i, book := range books {
+		data, err := c.encode(book)
+		if err != nil {
+			return result, err
+		}
+		encoded[i] = data
+	}
+	result.Encode = time.Since(start) / time.Duration(len(books))
+
+	var total int
+	start = time.Now()
+	for _, data := range encoded {
+		var book models.Book
+		if err := c.decode(data, &book); err != nil {
+			return result, err
+		}
+		total += len(data)
+	}
+	result.Decode = time.Since(start) / time.Duration(len(books))
+	result.Bytes = float64(total) / float64(len(books))
+	return result, nil
+}
+
+func benchRedis(ctx context.Context, client redis.UniversalClient, c codec, books []models.Book, result *BenchResult) error {
+	keys := make([]string, len(books))
+	for i := range books {
+		keys[i] = fmt.Sprintf("%s%s:%d", benchKeyPrefix(), c.name(), i)
+	}
+	defer func() {
+		for start := 0; start < len(keys); start += batchSize {
+			unlinkKeys(context.WithoutCancel(ctx), client, keys[start:min(start+batchSize, len(keys))]...)
+		}
+	}()
+
+	start := time.Now()
+	for first := 0; first < len(books); first += batchSize {
+		pipe := client.Pipeline()
+		for i := first; i < min(first+batchSize, len(books)); i++ {
+			if err := c.set(ctx, pipe, keys[i], books[i], time.Hour); err != nil {
+				return err
+			}
+		}
+		if _, err := pipe.Exec(ctx); err != nil {
+			return err
+		}
+	}
+	result.Set = time.Since(start) / time.Duration(len(books))
+
+	start = time.Now()
+	for _, key := range keys {
+		if _, err := c.get(ctx, client, key); err != nil {
+			return err
+		}
+	}
+	result.Get = time.Since(start) / time.Duration(len(books))
+
+	start = time.Now()
+	for first := 0; first < len(keys); first += batchSize {
+		if _, err := getBooks(ctx, c, client, keys[first:min(first+batchSize, len(keys))]); err != nil {
+			return err
+		}
+	}
+	result.GetMany = time.Since(start) / time.Duration(len(books))
+
+	memory, err := client.MemoryUsage(ctx, keys[0]).Result()
+	if err != nil {
+		return err
+	}
+	result.Memory = memory
+	return nil
+}
@@ -0,0 +1,161 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/arepala-uml/books-management-system/pkg/config"
+	"github.com/arepala-uml/books-management-system/pkg/metrics"
+	"github.com/arepala-uml/books-management-system/pkg/models"
+	"github.com/go-redis/redis/v8"
+	"gorm.io/gorm"
+)
+
+// Check compares up to size cached books, picked at random, with their rows in db and deletes
+// those that differ. A stale book is read from Postgres again on its next lookup, and the book list
+// stays uncached until the next warm-up since it would miss it.
+func Check(ctx context.Context, db *gorm.DB, size int) (VerifyReport, error) {
+	var report VerifyReport
+	keys, err := sampleKeys(ctx, size)
+	if err != nil {
+		return report, err
+	}
+
+	// Read the cache before Postgres, so a book updated in between is reported stale rather than
+	// its stale copy reported current
+	cached := make(map[int]models.Book, len(keys))
+	ids := make([]int, 0, len(keys))
+	for _, key := range keys {
+		id, err := strconv.Atoi(strings.TrimPrefix(key, cacheKey("BOOKS_ID:")))
+		if err != nil {
+			continue
+		}
+		book, err := loadBook(ctx, key)
+		if errors.Is(err, redis.Nil) {
+			// Expired, the index keeps the book until the next warm-up or flush
+			continue
+		} else if err != nil {
+			slog.WarnContext(ctx, "Cached book can't be read", "book_id", id, "error", err)
+			report.Stale = append(report.Stale, id)
+			continue
+		}
+		cached[id] = *book
+		ids = append(ids, id)
+	}
+	report.Checked = len(cached)
+
+	if len(ids) > 0 {
+		var books []models.Book
+		if err := db.WithContext(ctx).Where("id IN ?", ids).Find(&books).Error; err != nil {
+			return report, err
+		}
+		for _, book := range books {
+			if cached[book.ID] != book {
+				report.Stale = append(report.Stale, book.ID)
+			}
+			delete(cached, book.ID)
+		}
+		for id := range cached {
+			report.Orphaned = append(report.Orphaned, id)
+		}
+	}
+
+	metrics.ObserveCacheInconsistencies("stale", len(report.Stale))
+	metrics.ObserveCacheInconsistencies("orphaned", len(report.Orphaned))
+	if report.Consistent() {
+		return report, nil
+	}
+	slog.WarnContext(ctx, "Cached books differ from Postgres, deleting them", "stale", report.Stale, "orphaned", report.Orphaned)
+	return report, repair(ctx, report)
+}
+
+// StartConsistencyCheck runs Check on a sample of size books every interval until ctx is done
+func StartConsistencyCheck(ctx context.Context, interval time.Duration, size int) {
+	if interval <= 0 {
+		slog.Warn("Cache consistency check is disabled as CACHE_CHECK_INTERVAL is not set")
+		return
+	}
+	slog.Info("Starting cache consistency check", "interval", interval.String(), "sample_size", size)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if !enabled() {
+			continue
+		}
+		report, err := Check(ctx, config.GetDB(), size)
+		if err != nil && !errors.Is(err, context.Canceled) {
+			slog.Error("Error in cache consistency check", "error", err)
+			continue
+		}
+		slog.Debug("Cache consistency checked", "checked", report.Checked, "stale", len(report.Stale),
+			"orphaned", len(report.Orphaned))
+	}
+}
+
+// sampleKeys returns up to size book keys chosen uniformly among the indexed books, reading each
+// at a random rank of the id index in a single pipeline
+func sampleKeys(ctx context.Context, size int) ([]string, error) {
+	client := config.GetRedisClient()
+	count, err := client.ZCard(ctx, indexKey(SortID)).Result()
+	if err != nil || count == 0 {
+		return nil, err
+	}
+	ranks := make(map[int64]bool, size)
+	for len(ranks) < min(size, int(count)) {
+		ranks[rand.Int64N(count)] = true
+	}
+	pipe := client.Pipeline()
+	cmds := make([]*redis.StringSliceCmd, 0, len(ranks))
+	for rank := range ranks {
+		cmds = append(cmds, pipe.ZRange(ctx, indexKey(SortID), rank, rank))
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+	keys := make([]string, 0, len(cmds))
+	for _, cmd := range cmds {
+		for _, member := range cmd.Val() {
+			if id, err := strconv.Atoi(member); err == nil {
+				keys = append(keys, bookKey(id))
+			}
+		}
+	}
+	return keys, nil
+}
+
+// repair deletes the stale and orphaned books of a report. Dropping a stale book leaves the list
+// incomplete, so it is invalidated too.
+func repair(ctx context.Context, report VerifyReport) error {
+	ids := append(append([]int{}, report.Stale...), report.Orphaned...)
+	keys := make([]string, 0, len(ids))
+	for _, id := range ids {
+		keys = append(keys, bookKey(id))
+	}
+	if len(report.Stale) > 0 {
+		if err := invalidateList(ctx); err != nil {
+			return err
+		}
+	}
+	if err := unindexBooks(ctx, config.GetRedisClient(), ids...); err != nil {
+		return err
+	}
+	if _, err := unlinkKeys(ctx, config.GetRedisClient(), keys...); err != nil {
+		return err
+	}
+	for _, id := range ids {
+		invalidate(ctx, fmt.Sprint(id))
+	}
+	return nil
+}
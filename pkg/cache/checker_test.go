@@ -0,0 +1,45 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/arepala-uml/books-management-system/pkg/models"
+)
+
+func TestCheck(t *testing.T) {
+	db := setupWarm(t, 3)
+	ctx := context.Background()
+	if err := warm(ctx, db); err != nil {
+		t.Fatal(err)
+	}
+	// Book 2 is changed and book 3 deleted in Postgres behind the back of the cache
+	if err := db.Model(&models.Book{ID: 2}).Update("title", "Dune").Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Delete(&models.Book{}, 3).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := Check(ctx, db, 10)
+	if err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+	if report.Checked != 3 || fmt.Sprint(report.Stale) != "[2]" || fmt.Sprint(report.Orphaned) != "[3]" {
+		t.Errorf("Check() = %+v, want 3 checked, book 2 stale and book 3 orphaned", report)
+	}
+	for id, want := range map[string]bool{"1": true, "2": false, "3": false} {
+		if _, err := GetBookFromCache(ctx, id); (err == nil) != want {
+			t.Errorf("book %s cached after Check() = %t, want %t", id, err == nil, want)
+		}
+	}
+
+	// The books read again from Postgres are consistent
+	if err := warm(ctx, db); err != nil {
+		t.Fatal(err)
+	}
+	if report, err := Check(ctx, db, 10); err != nil || !report.Consistent() || report.Checked != 2 {
+		t.Errorf("Check() after a warm-up = %+v, %v, want 2 consistent books", report, err)
+	}
+}
@@ -0,0 +1,245 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/arepala-uml/books-management-system/pkg/config"
+	"github.com/arepala-uml/books-management-system/pkg/models"
+	"github.com/go-redis/redis/v8"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Encodings of the cached books, set with CACHE_ENCODING
+const (
+	// EncodingAuto picks EncodingJSON when Redis has the RedisJSON module, EncodingString otherwise
+	EncodingAuto = "auto"
+	// EncodingJSON stores RedisJSON documents with JSON.SET and JSON.GET
+	EncodingJSON = "json"
+	// EncodingString stores JSON strings with SET and GET, for Redis without modules
+	EncodingString = "string"
+	// EncodingMsgpack stores MessagePack strings with SET and GET, for Redis without modules
+	EncodingMsgpack = "msgpack"
+)
+
+// Encodings lists the encodings that can be set, other than EncodingAuto
+var Encodings = []string{EncodingJSON, EncodingString, EncodingMsgpack}
+
+// redisClient is a Redis client or pipeline
+type redisClient interface {
+	redis.Cmdable
+	Do(ctx context.Context, args ...interface{}) *redis.Cmd
+}
+
+// codec reads and writes books in one encoding. Keys are plain Redis keys, so expiring, scanning and
+// deleting them doesn't depend on the encoding.
+type codec interface {
+	name() string
+	encode(book models.Book) ([]byte, error)
+	decode(data []byte, book *models.Book) error
+	// get returns redis.Nil when the key doesn't exist
+	get(ctx context.Context, client redisClient, key string) (*models.Book, error)
+	// getArgs is the command get sends for key, to read keys one by one in a pipeline
+	getArgs(key string) []interface{}
+	// getMany returns the books at keys in one round trip, skipping the missing ones
+	getMany(ctx context.Context, client redisClient, keys []string) ([]models.Book, error)
+	// set writes the book with its expiry, 0 keeping it until evicted. On a pipeline it only queues the commands.
+	set(ctx context.Context, client redisClient, key string, book models.Book, expiry time.Duration) error
+}
+
+// redisJSONCodec stores books as RedisJSON documents
+type redisJSONCodec struct{}
+
+func (redisJSONCodec) name() string { return EncodingJSON }
+
+func (redisJSONCodec) encode(book models.Book) ([]byte, error) { return json.Marshal(book) }
+
+func (redisJSONCodec) decode(data []byte, book *models.Book) error { return json.Unmarshal(data, book) }
+
+func (redisJSONCodec) getArgs(key string) []interface{} { return []interface{}{"JSON.GET", key, "."} }
+
+func (c redisJSONCodec) get(ctx context.Context, client redisClient, key string) (*models.Book, error) {
+	data, err := client.Do(ctx, c.getArgs(key)...).Text()
+	if err != nil {
+		return nil, err
+	}
+	var book models.Book
+	if err := c.decode([]byte(data), &book); err != nil {
+		return nil, fmt.Errorf("cached data for %s is not a valid book: %w", key, err)
+	}
+	return &book, nil
+}
+
+func (c redisJSONCodec) getMany(ctx context.Context, client redisClient, keys []string) ([]models.Book, error) {
+	args := make([]interface{}, 0, len(keys)+3)
+	args = append(args, "JSON.MGET")
+	for _, key := range keys {
+		args = append(args, key)
+	}
+	args = append(args, ".")
+	values, err := client.Do(ctx, args...).Slice()
+	if err != nil {
+		return nil, err
+	}
+	return decodeMany(c, keys, values)
+}
+
+func (c redisJSONCodec) set(ctx context.Context, client redisClient, key string, book models.Book, expiry time.Duration) error {
+	data, err := c.encode(book)
+	if err != nil {
+		return err
+	}
+	if err := client.Do(ctx, "JSON.SET", key, ".", string(data)).Err(); err != nil {
+		return err
+	}
+	if expiry > 0 {
+		return client.Expire(ctx, key, expiry).Err()
+	}
+	return nil
+}
+
+// stringCodec stores books as strings with SET and GET, marshalled as JSON or MessagePack
+type stringCodec struct {
+	encoding  string
+	marshal   func(v interface{}) ([]byte, error)
+	unmarshal func(data []byte, v interface{}) error
+}
+
+func (c stringCodec) name() string { return c.encoding }
+
+func (c stringCodec) encode(book models.Book) ([]byte, error) { return c.marshal(book) }
+
+func (c stringCodec) decode(data []byte, book *models.Book) error { return c.unmarshal(data, book) }
+
+func (stringCodec) getArgs(key string) []interface{} { return []interface{}{"GET", key} }
+
+func (c stringCodec) get(ctx context.Context, client redisClient, key string) (*models.Book, error) {
+	data, err := client.Get(ctx, key).Bytes()
+	if err != nil {
+		return nil, err
+	}
+	var book models.Book
+	if err := c.decode(data, &book); err != nil {
+		return nil, fmt.Errorf("cached data for %s is not a valid book: %w", key, err)
+	}
+	return &book, nil
+}
+
+func (c stringCodec) getMany(ctx context.Context, client redisClient, keys []string) ([]models.Book, error) {
+	values, err := client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+	return decodeMany(c, keys, values)
+}
+
+func (c stringCodec) set(ctx context.Context, client redisClient, key string, book models.Book, expiry time.Duration) error {
+	data, err := c.encode(book)
+	if err != nil {
+		return err
+	}
+	return client.Set(ctx, key, data, expiry).Err()
+}
+
+// decodeMany decodes the replies of an MGET, or of a get per key, nil for the missing keys. A book that can't be decoded
+// is logged and skipped like a missing one.
+func decodeMany(c codec, keys []string, values []interface{}) ([]models.Book, error) {
+	books := make([]models.Book, 0, len(values))
+	for i, value := range values {
+		if value == nil {
+			continue
+		}
+		data, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("unexpected reply %T for %s", value, keys[i])
+		}
+		var book models.Book
+		if err := c.decode([]byte(data), &book); err != nil {
+			slog.Error("Cached data is not a valid book", "key", keys[i], "error", err)
+			continue
+		}
+		books = append(books, book)
+	}
+	return books, nil
+}
+
+// msgpackMarshal encodes with the json tags, so both plain encodings use the same field names
+func msgpackMarshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func msgpackUnmarshal(data []byte, v interface{}) error {
+	dec := msgpack.NewDecoder(bytes.NewReader(data))
+	dec.SetCustomStructTag("json")
+	return dec.Decode(v)
+}
+
+var codecs = map[string]codec{
+	EncodingJSON:    redisJSONCodec{},
+	EncodingString:  stringCodec{encoding: EncodingString, marshal: json.Marshal, unmarshal: json.Unmarshal},
+	EncodingMsgpack: stringCodec{encoding: EncodingMsgpack, marshal: msgpackMarshal, unmarshal: msgpackUnmarshal},
+}
+
+// detected remembers the encoding picked by EncodingAuto for a Redis client, detected again when a
+// reload connects another one
+var detected struct {
+	mu     sync.Mutex
+	client redis.UniversalClient
+	codec  codec
+}
+
+// currentCodec returns the codec of CACHE_ENCODING. For EncodingAuto it asks Redis whether it has
+// the RedisJSON module, which fails while Redis is unavailable.
+func currentCodec(ctx context.Context) (codec, error) {
+	encoding := config.Get().Cache.Encoding
+	if encoding != EncodingAuto {
+		return codecs[encoding], nil
+	}
+
+	client := config.GetRedisClient()
+	detected.mu.Lock()
+	defer detected.mu.Unlock()
+	if detected.client == client && detected.codec != nil {
+		return detected.codec, nil
+	}
+	supported, err := HasRedisJSON(ctx, client)
+	if err != nil {
+		return nil, fmt.Errorf("detecting the cache encoding: %w", err)
+	}
+	detected.client, detected.codec = client, codecs[EncodingString]
+	if supported {
+		detected.codec = codecs[EncodingJSON]
+	}
+	slog.Info("Detected the cache encoding", "encoding", detected.codec.name())
+	return detected.codec, nil
+}
+
+// Encoding returns the encoding the cached books are stored in
+func Encoding(ctx context.Context) (string, error) {
+	c, err := currentCodec(ctx)
+	if err != nil {
+		return "", err
+	}
+	return c.name(), nil
+}
+
+// HasRedisJSON reports whether Redis has the RedisJSON module
+func HasRedisJSON(ctx context.Context, client redisClient) (bool, error) {
+	// COMMAND INFO returns a nil entry for commands the server doesn't know
+	info, err := client.Do(ctx, "COMMAND", "INFO", "JSON.GET").Slice()
+	if err != nil {
+		return false, err
+	}
+	return len(info) > 0 && info[0] != nil, nil
+}
@@ -0,0 +1,157 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/arepala-uml/books-management-system/pkg/models"
+	"github.com/go-redis/redis/v8"
+)
+
+var testBook = models.Book{ID: 42, Title: "Dune", Author: "Frank Herbert", Year: 1965, AverageRating: 4.25, RatingCount: 8}
+
+// testBooks returns n books with distinct fields
+func testBooks(n int) []models.Book {
+	books := make([]models.Book, n)
+	for i := range books {
+		books[i] = models.Book{
+			ID:            i + 1,
+			Title:         fmt.Sprintf("Title %d", i+1),
+			Author:        fmt.Sprintf("Author %d", i%50),
+			Year:          1900 + i%120,
+			AverageRating: float64(i%5) + 0.5,
+			RatingCount:   i % 200,
+		}
+	}
+	return books
+}
+
+func TestCodecRoundTrip(t *testing.T) {
+	for _, encoding := range Encodings {
+		t.Run(encoding, func(t *testing.T) {
+			c := codecs[encoding]
+			if c.name() != encoding {
+				t.Errorf("name() = %q, want %q", c.name(), encoding)
+			}
+			data, err := c.encode(testBook)
+			if err != nil {
+				t.Fatalf("encode() error = %v", err)
+			}
+			var got models.Book
+			if err := c.decode(data, &got); err != nil {
+				t.Fatalf("decode() error = %v", err)
+			}
+			if got != testBook {
+				t.Errorf("decode(encode()) = %+v, want %+v", got, testBook)
+			}
+			if err := c.decode([]byte("\xc1not a book"), &got); err == nil {
+				t.Error("decode() of invalid data returned no error")
+			}
+		})
+	}
+}
+
+// TestMsgpackFieldNames checks MessagePack uses the json tags, so both plain encodings name fields alike
+func TestMsgpackFieldNames(t *testing.T) {
+	data, err := msgpackMarshal(testBook)
+	if err != nil {
+		t.Fatalf("msgpackMarshal() error = %v", err)
+	}
+	var fields map[string]interface{}
+	if err := msgpackUnmarshal(data, &fields); err != nil {
+		t.Fatalf("msgpackUnmarshal() error = %v", err)
+	}
+	for _, name := range []string{"id", "title", "author", "year", "average_rating", "rating_count"} {
+		if _, ok := fields[name]; !ok {
+			t.Errorf("encoded book has no %q field: %v", name, fields)
+		}
+	}
+}
+
+// TestStringCodecs stores books through the plain encodings. The in-memory Redis has no RedisJSON
+// module, so the json encoding is covered by TestCodecRoundTrip only.
+func TestStringCodecs(t *testing.T) {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+	ctx := context.Background()
+
+	for _, encoding := range []string{EncodingString, EncodingMsgpack} {
+		t.Run(encoding, func(t *testing.T) {
+			c := codecs[encoding]
+			key := encoding + ":42"
+			if _, err := c.get(ctx, client, key); !errors.Is(err, redis.Nil) {
+				t.Errorf("get() of a missing key error = %v, want redis.Nil", err)
+			}
+			if err := c.set(ctx, client, key, testBook, time.Minute); err != nil {
+				t.Fatalf("set() error = %v", err)
+			}
+			if ttl := server.TTL(key); ttl != time.Minute {
+				t.Errorf("TTL = %s, want %s", ttl, time.Minute)
+			}
+			got, err := c.get(ctx, client, key)
+			if err != nil || *got != testBook {
+				t.Fatalf("get() = %+v, %v, want %+v", got, err, testBook)
+			}
+
+			// getMany skips missing keys and data that isn't a book
+			if err := server.Set(encoding+":bad", "\xc1not a book"); err != nil {
+				t.Fatal(err)
+			}
+			books, err := c.getMany(ctx, client, []string{key, encoding + ":missing", encoding + ":bad"})
+			if err != nil {
+				t.Fatalf("getMany() error = %v", err)
+			}
+			if len(books) != 1 || books[0] != testBook {
+				t.Errorf("getMany() = %+v, want only %+v", books, testBook)
+			}
+			if _, err := c.get(ctx, client, encoding+":bad"); err == nil {
+				t.Error("get() of data that isn't a book returned no error")
+			}
+		})
+	}
+}
+
+func BenchmarkEncode(b *testing.B) {
+	books := testBooks(1000)
+	for _, encoding := range Encodings {
+		b.Run(encoding, func(b *testing.B) {
+			c := codecs[encoding]
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := c.encode(books[i%len(books)]); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkDecode(b *testing.B) {
+	books := testBooks(1000)
+	for _, encoding := range Encodings {
+		b.Run(encoding, func(b *testing.B) {
+			c := codecs[encoding]
+			encoded := make([][]byte, len(books))
+			for i, book := range books {
+				data, err := c.encode(book)
+				if err != nil {
+					b.Fatal(err)
+				}
+				encoded[i] = data
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				var book models.Book
+				if err := c.decode(encoded[i%len(encoded)], &book); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,145 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/arepala-uml/books-management-system/pkg/models"
+	"github.com/go-redis/redis/v8"
+)
+
+// Each sort order of the book list has a sorted set BOOKS_INDEX:<field> holding the id of every cached
+// book, scored by the field. Members are zero padded ids, so books with the same score are ordered by
+// id, as Postgres orders them, and a page of the list is a ZRANGE of the index instead of a scan.
+
+// Fields the book list can be sorted by
+const (
+	SortID     = "id"
+	SortYear   = "year"
+	SortRating = "rating"
+)
+
+// SortFields lists the fields the book list can be sorted by
+var SortFields = []string{SortID, SortYear, SortRating}
+
+type sortField struct {
+	column string
+	score  func(book models.Book) float64
+}
+
+var sortFields = map[string]sortField{
+	SortID:     {column: "id", score: func(book models.Book) float64 { return float64(book.ID) }},
+	SortYear:   {column: "year", score: func(book models.Book) float64 { return float64(book.Year) }},
+	SortRating: {column: "average_rating", score: func(book models.Book) float64 { return book.AverageRating }},
+}
+
+// BookSort is the order of the book list, by a field of SortFields then by id
+type BookSort struct {
+	Field string
+	Desc  bool
+}
+
+// ParseSort parses the sort of the book list, a field of SortFields prefixed with - for a descending order
+func ParseSort(value string) (BookSort, error) {
+	sort := BookSort{Field: strings.TrimPrefix(value, "-"), Desc: strings.HasPrefix(value, "-")}
+	if sort.Field == "" {
+		sort.Field = SortID
+	}
+	if _, ok := sortFields[sort.Field]; !ok {
+		return sort, fmt.Errorf("sort must be one of %s, optionally prefixed with -", strings.Join(SortFields, ", "))
+	}
+	return sort, nil
+}
+
+// OrderBy is the ORDER BY clause of Postgres giving the books in the same order as the index
+func (s BookSort) OrderBy() string {
+	column := sortFields[s.Field].column
+	if s.Desc {
+		return column + " DESC, id DESC"
+	}
+	return column + ", id"
+}
+
+func indexKey(field string) string {
+	return cacheKey("BOOKS_INDEX:" + field)
+}
+
+// indexKeys returns the key of every index
+func indexKeys() []string {
+	keys := make([]string, 0, len(SortFields))
+	for _, field := range SortFields {
+		keys = append(keys, indexKey(field))
+	}
+	return keys
+}
+
+func indexMember(id int) string {
+	return fmt.Sprintf("%010d", id)
+}
+
+// indexBooks adds books to every index, or moves them when their fields changed. On a pipeline it
+// only queues the commands.
+func indexBooks(ctx context.Context, client redis.Cmdable, books ...models.Book) error {
+	if len(books) == 0 {
+		return nil
+	}
+	for _, field := range SortFields {
+		members := make([]*redis.Z, len(books))
+		for i, book := range books {
+			members[i] = &redis.Z{Score: sortFields[field].score(book), Member: indexMember(book.ID)}
+		}
+		if err := client.ZAdd(ctx, indexKey(field), members...).Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unindexBooks removes the books with ids from every index
+func unindexBooks(ctx context.Context, client redis.UniversalClient, ids ...int) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	members := make([]interface{}, len(ids))
+	for i, id := range ids {
+		members[i] = indexMember(id)
+	}
+	pipe := client.Pipeline()
+	for _, key := range indexKeys() {
+		pipe.ZRem(ctx, key, members...)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// indexPage returns the keys of the books on a page of the list, limit books from offset. A negative
+// limit returns every book from offset, and a negative offset starts from the first book, as in Postgres.
+func indexPage(ctx context.Context, client redis.UniversalClient, sort BookSort, limit, offset int) ([]string, error) {
+	if limit == 0 {
+		return nil, nil
+	}
+	offset = max(offset, 0)
+	stop := int64(-1)
+	if limit > 0 {
+		stop = int64(offset + limit - 1)
+	}
+	zrange := client.ZRange
+	if sort.Desc {
+		zrange = client.ZRevRange
+	}
+	members, err := zrange(ctx, indexKey(sort.Field), int64(offset), stop).Result()
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, 0, len(members))
+	for _, member := range members {
+		id, err := strconv.Atoi(member)
+		if err != nil {
+			return nil, fmt.Errorf("book index %s has an invalid member %q", indexKey(sort.Field), member)
+		}
+		keys = append(keys, bookKey(id))
+	}
+	return keys, nil
+}
@@ -0,0 +1,174 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/arepala-uml/books-management-system/pkg/config"
+	"github.com/arepala-uml/books-management-system/pkg/models"
+	"github.com/go-redis/redis/v8"
+)
+
+// storeAll caches books one by one and marks the book list complete, as a warm-up does
+func storeAll(t *testing.T, books ...models.Book) {
+	t.Helper()
+	ctx := context.Background()
+	for _, book := range books {
+		if err := StoreBookInCache(ctx, book); err != nil {
+			t.Fatalf("StoreBookInCache(%d) error = %v", book.ID, err)
+		}
+	}
+	since, err := generation(ctx, generationList)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := markComplete(ctx, 0, 0, since); err != nil {
+		t.Fatalf("markComplete() error = %v", err)
+	}
+}
+
+func ids(books []models.Book) []int {
+	ids := make([]int, len(books))
+	for i, book := range books {
+		ids[i] = book.ID
+	}
+	return ids
+}
+
+func TestBookListOrder(t *testing.T) {
+	setup(t, nil)
+	ctx := context.Background()
+	storeAll(t,
+		models.Book{ID: 3, Title: "Emma", Year: 1815, AverageRating: 4.5},
+		models.Book{ID: 1, Title: "Dune", Year: 1965, AverageRating: 4},
+		models.Book{ID: 2, Title: "Ulysses", Year: 1922, AverageRating: 4},
+		models.Book{ID: 10, Title: "Beloved", Year: 1987, AverageRating: 3},
+	)
+
+	tests := []struct {
+		sort          string
+		limit, offset int
+		want          []int
+	}{
+		{"id", -1, 0, []int{1, 2, 3, 10}},
+		{"-id", 2, 0, []int{10, 3}},
+		{"year", 2, 1, []int{2, 1}},
+		// Books with the same rating are ordered by id, as in Postgres
+		{"rating", -1, 0, []int{10, 1, 2, 3}},
+		{"-rating", 3, 0, []int{3, 2, 1}},
+		{"id", 10, 4, []int{}},
+	}
+	for _, tt := range tests {
+		sort, err := ParseSort(tt.sort)
+		if err != nil {
+			t.Fatalf("ParseSort(%q) error = %v", tt.sort, err)
+		}
+		books, err := GetBooksFromCache(ctx, sort, tt.limit, tt.offset)
+		if err != nil {
+			t.Errorf("GetBooksFromCache(%s, %d, %d) error = %v", tt.sort, tt.limit, tt.offset, err)
+			continue
+		}
+		if got := ids(books); fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("GetBooksFromCache(%s, %d, %d) = %v, want %v", tt.sort, tt.limit, tt.offset, got, tt.want)
+		}
+	}
+
+	// A changed book moves in the index
+	if err := StoreBookInCache(ctx, models.Book{ID: 10, Title: "Beloved", Year: 1987, AverageRating: 5}); err != nil {
+		t.Fatalf("StoreBookInCache() error = %v", err)
+	}
+	books, err := GetBooksFromCache(ctx, BookSort{Field: SortRating, Desc: true}, 1, 0)
+	if err != nil || len(books) != 1 || books[0].ID != 10 {
+		t.Errorf("top rated book = %v, %v, want book 10", ids(books), err)
+	}
+
+	// A deleted book leaves the list without invalidating it
+	if err := DeleteBookFromCache(ctx, "3"); err != nil {
+		t.Fatalf("DeleteBookFromCache() error = %v", err)
+	}
+	books, err = GetBooksFromCache(ctx, BookSort{Field: SortID}, -1, 0)
+	if got := ids(books); err != nil || fmt.Sprint(got) != "[1 2 10]" {
+		t.Errorf("book list after deletion = %v, %v, want [1 2 10]", got, err)
+	}
+}
+
+func TestBookListNeedsCompleteMarker(t *testing.T) {
+	setup(t, nil)
+	ctx := context.Background()
+	if err := StoreBookInCache(ctx, models.Book{ID: 1, Title: "Dune"}); err != nil {
+		t.Fatalf("StoreBookInCache() error = %v", err)
+	}
+	// Without a warm-up the cache may miss books of Postgres
+	if _, err := GetBooksFromCache(ctx, BookSort{Field: SortID}, -1, 0); !errors.Is(err, redis.Nil) {
+		t.Errorf("GetBooksFromCache() before a warm-up error = %v, want redis.Nil", err)
+	}
+
+	storeAll(t)
+	if _, err := GetBooksFromCache(ctx, BookSort{Field: SortID}, -1, 0); err != nil {
+		t.Fatalf("GetBooksFromCache() after a warm-up error = %v", err)
+	}
+	if err := InvalidateList(ctx); err != nil {
+		t.Fatalf("InvalidateList() error = %v", err)
+	}
+	if _, err := GetBooksFromCache(ctx, BookSort{Field: SortID}, -1, 0); !errors.Is(err, redis.Nil) {
+		t.Errorf("GetBooksFromCache() after InvalidateList error = %v, want redis.Nil", err)
+	}
+}
+
+func TestMissingIndexedBookInvalidatesList(t *testing.T) {
+	server := setup(t, nil)
+	ctx := context.Background()
+	storeAll(t, models.Book{ID: 1, Title: "Dune"}, models.Book{ID: 2, Title: "Emma"})
+
+	// Evicted by Redis while still indexed
+	server.Del(bookKey(2))
+	if _, err := GetBooksFromCache(ctx, BookSort{Field: SortID}, -1, 0); !errors.Is(err, redis.Nil) {
+		t.Errorf("GetBooksFromCache() with an evicted book error = %v, want redis.Nil", err)
+	}
+	if server.Exists(completeKey()) {
+		t.Error("the book list is still marked complete")
+	}
+}
+
+// TestFailedStoresInvalidateList checks a book that can't be cached drops the book list, which would
+// otherwise serve its previous version or miss it. The in-memory Redis has no RedisJSON module, so
+// storing with the json encoding fails.
+func TestFailedStoresInvalidateList(t *testing.T) {
+	server := setup(t, map[string]string{"CACHE_ENCODING": EncodingJSON})
+	ctx := context.Background()
+	book := models.Book{ID: 1, Title: "Dune"}
+
+	for name, store := range map[string]func() error{
+		"StoreBookInCache":  func() error { return StoreBookInCache(ctx, book) },
+		"StoreBooksInCache": func() error { return StoreBooksInCache(ctx, []models.Book{book}) },
+	} {
+		if err := server.Set(completeKey(), "1"); err != nil {
+			t.Fatal(err)
+		}
+		if err := store(); err == nil {
+			t.Errorf("%s() returned no error", name)
+		}
+		if server.Exists(completeKey()) {
+			t.Errorf("%s() failed without invalidating the book list", name)
+		}
+	}
+}
+
+func TestStoreWithCacheDisabled(t *testing.T) {
+	server := setup(t, nil)
+	ctx := context.Background()
+	storeAll(t, models.Book{ID: 1, Title: "Dune"})
+
+	t.Setenv("FEATURE_BOOK_CACHE", "false")
+	if _, err := config.Init(""); err != nil {
+		t.Fatalf("loading the configuration: %v", err)
+	}
+	if err := StoreBookInCache(ctx, models.Book{ID: 1, Title: "Dune Messiah"}); err != nil {
+		t.Fatalf("StoreBookInCache() error = %v", err)
+	}
+	if server.Exists(completeKey()) || server.Exists(bookKey(1)) {
+		t.Error("the cached book and the book list outlive a change made with the cache disabled")
+	}
+}
@@ -0,0 +1,144 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/arepala-uml/books-management-system/pkg/config"
+	"github.com/arepala-uml/books-management-system/pkg/models"
+	"github.com/go-redis/redis/v8"
+)
+
+// keyVersion is the version of the keys of the book cache. Bump it when a change to models.Book or to a
+// codec makes the books cached by the previous version unreadable or incomplete: the new version reads
+// and writes keys of its own, so it never serves the old shapes, and `cache cleanup` deletes the old keys.
+// Switching CACHE_ENCODING needs no bump, the encoding is part of the keys too.
+const keyVersion = 1
+
+// keyEncoding is the CACHE_ENCODING the keys are written with. Keys written with auto don't say which
+// encoding it picked, which only changes when Redis gains or loses the RedisJSON module.
+func keyEncoding() string {
+	return config.Get().Cache.Encoding
+}
+
+// cacheKey returns the key of the book cache named name, as <REDIS_KEY_PREFIX>v<keyVersion>:<CACHE_ENCODING>:<name>.
+// A reload switching CACHE_ENCODING moves to keys of its own, so a book is never read with another codec.
+func cacheKey(name string) string {
+	return config.RedisKey(fmt.Sprintf("v%d:%s:%s", keyVersion, keyEncoding(), name))
+}
+
+// keyNamespace is the version and encoding of the keys, as v<keyVersion>/<CACHE_ENCODING>
+func keyNamespace() string {
+	return fmt.Sprintf("v%d/%s", keyVersion, keyEncoding())
+}
+
+// The keys of an encoding switched away from aren't updated by the instances that switched, so the books
+// left there by an earlier switch are flushed when the cache moves back to them
+func init() {
+	config.Subscribe("cache-encoding", func(old, new *config.Config) error {
+		if old.Cache.Encoding != new.Cache.Encoding {
+			go flushStale("CACHE_ENCODING changed")
+		}
+		return nil
+	})
+}
+
+var patternEscaper = strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`, `[`, `\[`, `]`, `\]`)
+
+// keyPattern returns the SCAN pattern of the keys starting with prefix, matched literally
+func keyPattern(prefix string) string {
+	return patternEscaper.Replace(prefix) + "*"
+}
+
+// A Redis Cluster spreads the keys over its masters: SCAN only walks the node it is sent to, and
+// commands on several keys fail unless they all hash to the same slot. The helpers below walk every
+// master and split multi-key commands per key in cluster mode, and send them as is otherwise.
+
+// scanKeys calls fn with the keys matching pattern, batchSize keys at most at a time. In cluster mode
+// every master is scanned, and the calls to fn are serialised.
+func scanKeys(ctx context.Context, client redis.UniversalClient, pattern string, fn func(keys []string) error) error {
+	cluster, ok := client.(*redis.ClusterClient)
+	if !ok {
+		return scanNode(ctx, client, pattern, fn)
+	}
+	var mu sync.Mutex
+	return cluster.ForEachMaster(ctx, func(ctx context.Context, node *redis.Client) error {
+		return scanNode(ctx, node, pattern, func(keys []string) error {
+			mu.Lock()
+			defer mu.Unlock()
+			return fn(keys)
+		})
+	})
+}
+
+func scanNode(ctx context.Context, client redis.Cmdable, pattern string, fn func(keys []string) error) error {
+	iter := client.Scan(ctx, 0, pattern, batchSize).Iterator()
+	keys := make([]string, 0, batchSize)
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+		if len(keys) == batchSize {
+			if err := fn(keys); err != nil {
+				return err
+			}
+			keys = keys[:0]
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	return fn(keys)
+}
+
+// getBooks returns the books at keys in one round trip, skipping the missing ones. In cluster mode
+// each key is read with a command of its own in a pipeline instead of an MGET.
+func getBooks(ctx context.Context, c codec, client redis.UniversalClient, keys []string) ([]models.Book, error) {
+	if _, ok := client.(*redis.ClusterClient); !ok {
+		return c.getMany(ctx, client, keys)
+	}
+	pipe := client.Pipeline()
+	cmds := make([]*redis.Cmd, len(keys))
+	for i, key := range keys {
+		cmds[i] = pipe.Do(ctx, c.getArgs(key)...)
+	}
+	// Exec fails with redis.Nil for a missing key, so each command is checked instead
+	pipe.Exec(ctx)
+	values := make([]interface{}, len(keys))
+	for i, cmd := range cmds {
+		value, err := cmd.Result()
+		if errors.Is(err, redis.Nil) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		values[i] = value
+	}
+	return decodeMany(c, keys, values)
+}
+
+// unlinkKeys deletes keys in the background of Redis and returns the number deleted. In cluster mode
+// each key is unlinked with a command of its own in a pipeline.
+func unlinkKeys(ctx context.Context, client redis.UniversalClient, keys ...string) (int64, error) {
+	if len(keys) == 0 {
+		return 0, nil
+	}
+	if _, ok := client.(*redis.ClusterClient); !ok {
+		return client.Unlink(ctx, keys...).Result()
+	}
+	pipe := client.Pipeline()
+	cmds := make([]*redis.IntCmd, len(keys))
+	for i, key := range keys {
+		cmds[i] = pipe.Unlink(ctx, key)
+	}
+	_, err := pipe.Exec(ctx)
+	var deleted int64
+	for _, cmd := range cmds {
+		deleted += cmd.Val()
+	}
+	return deleted, err
+}
@@ -0,0 +1,152 @@
+package cache
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	mathrand "math/rand/v2"
+	"time"
+
+	"github.com/arepala-uml/books-management-system/pkg/config"
+	"github.com/arepala-uml/books-management-system/pkg/metrics"
+	"github.com/arepala-uml/books-management-system/pkg/models"
+	"github.com/go-redis/redis/v8"
+	"golang.org/x/sync/singleflight"
+	"gorm.io/gorm"
+)
+
+// lockPollInterval is how often an instance waiting for another one to load a book checks the cache
+const lockPollInterval = 50 * time.Millisecond
+
+// loads coalesces the concurrent loads of a book within this instance
+var loads singleflight.Group
+
+// releaseLock deletes a load lock only if this instance still holds it
+var releaseLock = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+// BookLoader reads a book from the source of truth, returning gorm.ErrRecordNotFound when it doesn't exist
+type BookLoader func(ctx context.Context) (*models.Book, error)
+
+// LoadBook returns the book from the cache, or loads and caches it on a miss. One request per instance
+// loads a missing book while the others wait for it, and a lock in Redis lets only one instance load it.
+// Books that don't exist are remembered for CACHE_NOT_FOUND_TTL seconds and return gorm.ErrRecordNotFound.
+func LoadBook(ctx context.Context, id string, load BookLoader) (*models.Book, error) {
+	if !enabled() || !config.RedisAvailable() {
+		return load(ctx)
+	}
+	if book, err := cachedBook(ctx, id); book != nil || err != nil {
+		return book, err
+	}
+
+	// The shared load runs on behalf of every waiting request, so it isn't cancelled with the first one
+	result := loads.DoChan(id, func() (interface{}, error) {
+		return loadOnce(context.WithoutCancel(ctx), id, load)
+	})
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case res := <-result:
+		if res.Err != nil {
+			return nil, res.Err
+		}
+		return res.Val.(*models.Book), nil
+	}
+}
+
+// cachedBook returns the cached book, gorm.ErrRecordNotFound when the book is known not to exist,
+// or nothing on a miss. Redis errors count as a miss so the book is still served from Postgres.
+func cachedBook(ctx context.Context, id string) (*models.Book, error) {
+	if book, err := GetBookFromCache(ctx, id); err == nil {
+		return book, nil
+	}
+	missing, err := config.GetRedisClient().Exists(ctx, notFoundKey(id)).Result()
+	if err == nil && missing == 1 {
+		metrics.ObserveCache("get_book", metrics.CacheNegativeHit)
+		return nil, gorm.ErrRecordNotFound
+	}
+	return nil, nil
+}
+
+// loadOnce loads the book under a lock in Redis. When another instance holds the lock, it waits for that
+// instance to cache the book, and loads it itself if that takes longer than CACHE_LOAD_LOCK_TIMEOUT.
+func loadOnce(ctx context.Context, id string, load BookLoader) (*models.Book, error) {
+	timeout := config.Get().Cache.LoadLockTimeout
+	lockKey := cacheKey("LOCK:BOOKS_ID:" + id)
+	token := lockToken()
+
+	locked, err := config.GetRedisClient().SetNX(ctx, lockKey, token, timeout).Result()
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to take the book load lock, loading without it", "key", lockKey, "error", err)
+		return loadAndStore(ctx, id, load)
+	}
+	if locked {
+		defer func() {
+			if err := releaseLock.Run(ctx, config.GetRedisClient(), []string{lockKey}, token).Err(); err != nil {
+				slog.WarnContext(ctx, "Failed to release the book load lock", "key", lockKey, "error", err)
+			}
+		}()
+		// Another instance may have cached it between our miss and taking the lock
+		if book, err := cachedBook(ctx, id); book != nil || err != nil {
+			return book, err
+		}
+		return loadAndStore(ctx, id, load)
+	}
+
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		time.Sleep(lockPollInterval)
+		if book, err := cachedBook(ctx, id); book != nil || err != nil {
+			return book, err
+		}
+	}
+	slog.WarnContext(ctx, "Timed out waiting for another instance to load the book", "key", lockKey)
+	return loadAndStore(ctx, id, load)
+}
+
+func loadAndStore(ctx context.Context, id string, load BookLoader) (*models.Book, error) {
+	book, err := load(ctx)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		if ttl := config.Get().Cache.NotFoundTTLSeconds; ttl > 0 {
+			if err := config.GetRedisClient().Set(ctx, notFoundKey(id), 1, time.Duration(ttl)*time.Second).Err(); err != nil {
+				slog.WarnContext(ctx, "Failed to cache that the book doesn't exist", "book_id", id, "error", err)
+			}
+		}
+		return nil, err
+	} else if err != nil {
+		return nil, err
+	}
+	// Read through, not a change: the other instances keep their copy
+	StoreBooksInCache(ctx, []models.Book{*book})
+	return book, nil
+}
+
+// notFoundKey remembers that a book doesn't exist. It is kept apart from BOOKS_ID:* so scans of the
+// cached books never see it.
+func notFoundKey(id string) string {
+	return cacheKey(fmt.Sprintf("BOOKS_NOT_FOUND:%s", id))
+}
+
+// expiry returns REDIS_EXPIRY_BOOKS plus up to CACHE_TTL_JITTER_PERCENT percent more, so books cached together
+// don't all expire together. The jitter only lengthens the expiry.
+func expiry() int {
+	cfg := config.Get()
+	base := cfg.Redis.ExpiryBooks
+	if base <= 0 || cfg.Cache.TTLJitterPercent <= 0 {
+		return base
+	}
+	return base + mathrand.IntN(base*cfg.Cache.TTLJitterPercent/100+1)
+}
+
+func lockToken() string {
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
@@ -0,0 +1,84 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/arepala-uml/books-management-system/pkg/models"
+	"gorm.io/gorm"
+)
+
+func TestLoadBookCoalescesMisses(t *testing.T) {
+	setup(t, nil)
+	ctx := context.Background()
+	var loads atomic.Int32
+	release := make(chan struct{})
+	load := func(context.Context) (*models.Book, error) {
+		loads.Add(1)
+		<-release
+		return &models.Book{ID: 1, Title: "Dune"}, nil
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			book, err := LoadBook(ctx, "1", load)
+			if err == nil && book.Title != "Dune" {
+				err = errors.New("loaded " + book.Title)
+			}
+			errs <- err
+		}()
+	}
+	// Requests arriving after the load find the book in the cache
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("LoadBook() error = %v", err)
+		}
+	}
+	if n := loads.Load(); n != 1 {
+		t.Errorf("the book was loaded %d times, want once", n)
+	}
+}
+
+func TestLoadBookNotFound(t *testing.T) {
+	server := setup(t, map[string]string{"CACHE_NOT_FOUND_TTL": "30"})
+	ctx := context.Background()
+	missing := func(context.Context) (*models.Book, error) { return nil, gorm.ErrRecordNotFound }
+	unexpected := func(context.Context) (*models.Book, error) {
+		t.Error("the book was loaded again instead of read from the cache")
+		return nil, gorm.ErrRecordNotFound
+	}
+
+	if _, err := LoadBook(ctx, "1", missing); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Fatalf("LoadBook() of a missing book error = %v, want gorm.ErrRecordNotFound", err)
+	}
+	if !server.Exists(notFoundKey("1")) {
+		t.Fatal("that the book doesn't exist wasn't cached")
+	}
+	if _, err := LoadBook(ctx, "1", unexpected); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Fatalf("LoadBook() of a book known to be missing error = %v, want gorm.ErrRecordNotFound", err)
+	}
+
+	// Creating the book drops the negative entry, so it is found right away
+	if err := StoreBookInCache(ctx, models.Book{ID: 1, Title: "Dune"}); err != nil {
+		t.Fatal(err)
+	}
+	if server.Exists(notFoundKey("1")) {
+		t.Error("the book is still cached as missing after it was created")
+	}
+	book, err := LoadBook(ctx, "1", unexpected)
+	if err != nil || book.Title != "Dune" {
+		t.Errorf("LoadBook() after the book was created = %+v, %v, want Dune", book, err)
+	}
+}
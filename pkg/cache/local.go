@@ -0,0 +1,209 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/arepala-uml/books-management-system/pkg/config"
+	"github.com/arepala-uml/books-management-system/pkg/models"
+)
+
+// invalidationChannel carries "<instance>/<key namespace>:<book id>" for every changed book, or
+// "<instance>/<key namespace>:*" when every book is, so the other instances evict it from their L1 cache.
+// It isn't versioned, so instances caching under different key versions or encodings hear each other's
+// changes.
+func invalidationChannel() string {
+	return config.RedisKey("BOOKS_INVALIDATE")
+}
+
+// instanceID tells the invalidations published by this instance apart from those of the others
+var instanceID = lockToken()
+
+// publisher is the sender of the invalidations published by this instance, with the keys it caches under
+func publisher() string {
+	return instanceID + "/" + keyNamespace()
+}
+
+type localEntry struct {
+	id      string
+	book    models.Book
+	expires time.Time
+}
+
+// local is the in-process L1 cache in front of Redis, an LRU of up to CACHE_L1_SIZE books kept for
+// CACHE_L1_TTL. A missed invalidation serves a stale book for at most the TTL.
+var local = struct {
+	mu      sync.Mutex
+	order   *list.List
+	entries map[string]*list.Element
+}{order: list.New(), entries: make(map[string]*list.Element)}
+
+// localEnabled reports whether the L1 cache holds any book, per CACHE_L1_SIZE
+func localEnabled() bool {
+	return config.Get().Cache.L1Size > 0
+}
+
+func localGet(id string) (models.Book, bool) {
+	local.mu.Lock()
+	defer local.mu.Unlock()
+	elem, ok := local.entries[id]
+	if !ok {
+		return models.Book{}, false
+	}
+	entry := elem.Value.(*localEntry)
+	if time.Now().After(entry.expires) {
+		local.order.Remove(elem)
+		delete(local.entries, id)
+		return models.Book{}, false
+	}
+	local.order.MoveToFront(elem)
+	return entry.book, true
+}
+
+func localSet(id string, book models.Book) {
+	cfg := config.Get().Cache
+	if cfg.L1Size <= 0 {
+		return
+	}
+	local.mu.Lock()
+	defer local.mu.Unlock()
+	expires := time.Now().Add(cfg.L1TTL)
+	if elem, ok := local.entries[id]; ok {
+		entry := elem.Value.(*localEntry)
+		entry.book, entry.expires = book, expires
+		local.order.MoveToFront(elem)
+		return
+	}
+	local.entries[id] = local.order.PushFront(&localEntry{id: id, book: book, expires: expires})
+	// CACHE_L1_SIZE may have shrunk with a reload
+	for local.order.Len() > cfg.L1Size {
+		oldest := local.order.Back()
+		local.order.Remove(oldest)
+		delete(local.entries, oldest.Value.(*localEntry).id)
+	}
+}
+
+func localEvict(id string) {
+	local.mu.Lock()
+	defer local.mu.Unlock()
+	if elem, ok := local.entries[id]; ok {
+		local.order.Remove(elem)
+		delete(local.entries, id)
+	}
+}
+
+func localPurge() {
+	local.mu.Lock()
+	defer local.mu.Unlock()
+	local.order.Init()
+	clear(local.entries)
+}
+
+// invalidate evicts a book, or every book for "*", from the L1 cache of this instance and of the others.
+// A failed publish is only logged, the other instances serve their copy until it expires.
+func invalidate(ctx context.Context, id string) {
+	if id == "*" {
+		localPurge()
+	} else {
+		localEvict(id)
+	}
+	if err := config.GetRedisClient().Publish(ctx, invalidationChannel(), publisher()+":"+id).Err(); err != nil {
+		slog.WarnContext(ctx, "Failed to publish the invalidation of a cached book", "book_id", id, "error", err)
+	}
+}
+
+// RunInvalidationListener evicts the books changed by other instances from the L1 cache until the
+// context is cancelled. It subscribes again every BREAKER_PROBE_INTERVAL after an error, and when a
+// reload changes the Redis server.
+func RunInvalidationListener(ctx context.Context) {
+	restart := make(chan struct{}, 1)
+	config.Subscribe("cache-invalidation", func(old, new *config.Config) error {
+		if old.Redis != new.Redis {
+			select {
+			case restart <- struct{}{}:
+			default:
+			}
+		}
+		return nil
+	})
+
+	for {
+		err := listen(ctx, restart)
+		if ctx.Err() != nil {
+			return
+		}
+		if err == nil {
+			// Restarted by a reload
+			continue
+		}
+		slog.Error("Error in the cache invalidation listener, subscribing again", "error", err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(config.Get().Breaker.ProbeInterval):
+		}
+	}
+}
+
+func listen(ctx context.Context, restart <-chan struct{}) error {
+	pubsub := config.GetRedisClient().Subscribe(ctx, invalidationChannel())
+	defer pubsub.Close()
+	if _, err := pubsub.Receive(ctx); err != nil {
+		return err
+	}
+	// Invalidations published while unsubscribed are lost
+	localPurge()
+	slog.Info("Listening for cache invalidations", "channel", invalidationChannel())
+
+	messages := pubsub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-restart:
+			return nil
+		case msg, ok := <-messages:
+			if !ok {
+				return errors.New("subscription closed")
+			}
+			sender, id, found := strings.Cut(msg.Payload, ":")
+			instance, namespace, _ := strings.Cut(sender, "/")
+			if !found || instance == instanceID {
+				continue
+			}
+			if id == "*" {
+				localPurge()
+				continue
+			}
+			localEvict(id)
+			if namespace != keyNamespace() {
+				forgetChange(ctx, id)
+			}
+		}
+	}
+}
+
+// forgetChange drops a book changed by an instance caching under another key version or encoding, such
+// as during a rollout or a reload, since its write didn't reach the keys of this instance. The book may be new or have moved
+// in the list, so the list is invalidated too.
+func forgetChange(ctx context.Context, id string) {
+	bookID, err := strconv.Atoi(id)
+	if err != nil {
+		return
+	}
+	client := config.GetRedisClient()
+	err = errors.Join(invalidateList(ctx), unindexBooks(ctx, client, bookID))
+	if err == nil {
+		_, err = unlinkKeys(ctx, client, bookKey(bookID), notFoundKey(id))
+	}
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to drop a book changed under another cache key version", "book_id", id,
+			"error", err)
+	}
+}
@@ -0,0 +1,155 @@
+package cache
+
+import (
+	"context"
+	"maps"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/arepala-uml/books-management-system/pkg/config"
+	"github.com/arepala-uml/books-management-system/pkg/models"
+	"github.com/arepala-uml/books-management-system/pkg/testutil"
+)
+
+// setup enables the book cache in the string encoding over an in-memory Redis, without the L1 cache
+// unless settings size it, and empties the L1 cache left by the previous test
+func setup(t *testing.T, settings map[string]string) *miniredis.Miniredis {
+	t.Helper()
+	env := map[string]string{"FEATURE_BOOK_CACHE": "true", "CACHE_ENCODING": EncodingString, "CACHE_L1_SIZE": "0"}
+	maps.Copy(env, settings)
+	server := testutil.Redis(t, env)
+	localPurge()
+	return server
+}
+
+// eventually fails the test unless cond holds within a second
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		if cond() {
+			return
+		}
+	}
+	t.Fatalf("timed out waiting for %s", what)
+}
+
+func TestLocalCache(t *testing.T) {
+	setup(t, map[string]string{"CACHE_L1_SIZE": "2", "CACHE_L1_TTL": "100ms"})
+
+	localSet("1", models.Book{ID: 1, Title: "Dune"})
+	localSet("2", models.Book{ID: 2, Title: "Emma"})
+	// Reading book 1 makes book 2 the least recently used
+	if book, ok := localGet("1"); !ok || book.Title != "Dune" {
+		t.Fatalf("localGet(1) = %+v, %t, want Dune", book, ok)
+	}
+	localSet("3", models.Book{ID: 3, Title: "Ulysses"})
+	if _, ok := localGet("2"); ok {
+		t.Error("the least recently used book outlived a full L1 cache")
+	}
+	for _, id := range []string{"1", "3"} {
+		if _, ok := localGet(id); !ok {
+			t.Errorf("book %s was evicted, want it kept", id)
+		}
+	}
+
+	localEvict("1")
+	if _, ok := localGet("1"); ok {
+		t.Error("localGet() returned an evicted book")
+	}
+	time.Sleep(150 * time.Millisecond)
+	if _, ok := localGet("3"); ok {
+		t.Error("localGet() returned a book past CACHE_L1_TTL")
+	}
+}
+
+func TestLocalCacheDisabled(t *testing.T) {
+	setup(t, nil)
+	localSet("1", models.Book{ID: 1})
+	if _, ok := localGet("1"); ok {
+		t.Error("a book was kept in memory with CACHE_L1_SIZE=0")
+	}
+}
+
+func TestInvalidationListener(t *testing.T) {
+	server := setup(t, map[string]string{"CACHE_L1_SIZE": "10", "CACHE_L1_TTL": "1m"})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		RunInvalidationListener(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	eventually(t, "the listener to subscribe", func() bool {
+		return server.PubSubNumSub(invalidationChannel())[invalidationChannel()] == 1
+	})
+
+	publish := func(payload string) {
+		t.Helper()
+		if err := config.GetRedisClient().Publish(ctx, invalidationChannel(), payload).Err(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for id := 1; id <= 3; id++ {
+		localSet(string(rune('0'+id)), models.Book{ID: id})
+	}
+
+	// Changes of another instance evict the book, those of this instance were evicted when made
+	publish(publisher() + ":1")
+	publish("another-instance/" + keyNamespace() + ":2")
+	eventually(t, "book 2 to be evicted", func() bool {
+		_, ok := localGet("2")
+		return !ok
+	})
+	if _, ok := localGet("1"); !ok {
+		t.Error("an invalidation published by this instance evicted the book again")
+	}
+
+	publish("another-instance/" + keyNamespace() + ":*")
+	eventually(t, "every book to be evicted", func() bool {
+		_, one := localGet("1")
+		_, three := localGet("3")
+		return !one && !three
+	})
+
+	// A change cached under other keys didn't reach those of this instance, which drop the book
+	if err := StoreBookInCache(ctx, models.Book{ID: 4, Title: "Dune"}); err != nil {
+		t.Fatalf("StoreBookInCache() error = %v", err)
+	}
+	publish("another-instance/v0/" + EncodingString + ":4")
+	eventually(t, "book 4 to be dropped from Redis", func() bool { return !server.Exists(bookKey(4)) })
+	if _, ok := localGet("4"); ok {
+		t.Error("a change cached under other keys left the book in the L1 cache")
+	}
+}
+
+// TestReadThroughDoesNotPublish checks books read from Postgres leave the L1 copies of the other
+// instances alone, while changes evict them
+func TestReadThroughDoesNotPublish(t *testing.T) {
+	setup(t, nil)
+	ctx := context.Background()
+	pubsub := config.GetRedisClient().Subscribe(ctx, invalidationChannel())
+	defer pubsub.Close()
+	if _, err := pubsub.Receive(ctx); err != nil {
+		t.Fatal(err)
+	}
+	messages := pubsub.Channel()
+
+	if err := StoreBooksInCache(ctx, []models.Book{{ID: 1, Title: "Dune"}}); err != nil {
+		t.Fatalf("StoreBooksInCache() error = %v", err)
+	}
+	if err := StoreBookInCache(ctx, models.Book{ID: 2, Title: "Emma"}); err != nil {
+		t.Fatalf("StoreBookInCache() error = %v", err)
+	}
+	select {
+	case msg := <-messages:
+		if want := publisher() + ":2"; msg.Payload != want {
+			t.Errorf("published %q, want only the change %q", msg.Payload, want)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("StoreBookInCache() published no invalidation")
+	}
+}
@@ -0,0 +1,180 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/arepala-uml/books-management-system/pkg/config"
+	"github.com/arepala-uml/books-management-system/pkg/models"
+	"github.com/go-redis/redis/v8"
+	"gorm.io/gorm"
+)
+
+// batchSize is the number of books read from Postgres, or keys from Redis, at a time
+const batchSize = 500
+
+func bookKeyPattern() string {
+	return keyPattern(cacheKey("BOOKS_ID:"))
+}
+
+func notFoundKeyPattern() string {
+	return keyPattern(cacheKey("BOOKS_NOT_FOUND:"))
+}
+
+// While the circuit breaker of Redis is open, writes only reach Postgres and the cache isn't told, so
+// every cached book, index and not found marker may be stale once Redis is back
+func init() {
+	config.OnRedisRecovered(func() { flushStale("Redis recovered") })
+}
+
+// flushStale flushes the book cache after reason made it stale, logging the outcome
+func flushStale(reason string) {
+	ctx := context.Background()
+	deleted, err := Flush(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to flush the stale book cache", "reason", reason, "deleted", deleted, "error", err)
+		return
+	}
+	slog.InfoContext(ctx, "Flushed the stale book cache", "reason", reason, "deleted", deleted)
+}
+
+// Flush deletes every cached book, the book index and the books remembered as not found, and returns the
+// number of books deleted. Other keys in Redis are left alone.
+func Flush(ctx context.Context) (int, error) {
+	client := config.GetRedisClient()
+	if err := invalidateList(ctx); err != nil {
+		return 0, err
+	}
+	if _, err := unlinkKeys(ctx, client, indexKeys()...); err != nil {
+		return 0, err
+	}
+	deleted := 0
+	err := scanKeys(ctx, client, bookKeyPattern(), func(keys []string) error {
+		n, err := unlinkKeys(ctx, client, keys...)
+		deleted += int(n)
+		return err
+	})
+	if err == nil {
+		err = scanKeys(ctx, client, notFoundKeyPattern(), func(keys []string) error {
+			_, err := unlinkKeys(ctx, client, keys...)
+			return err
+		})
+	}
+	invalidate(ctx, "*")
+	return deleted, err
+}
+
+// VerifyReport is the outcome of comparing the cache with Postgres
+type VerifyReport struct {
+	// Checked is the number of books compared
+	Checked int
+	// Missing books aren't cached, which is expected for books not read since they expired
+	Missing int
+	// Stale books are cached with values that differ from Postgres
+	Stale []int
+	// Orphaned books are cached but no longer exist in Postgres
+	Orphaned []int
+}
+
+// Consistent reports whether every cached book matches Postgres
+func (r VerifyReport) Consistent() bool {
+	return len(r.Stale) == 0 && len(r.Orphaned) == 0
+}
+
+// Verify compares every cached book with its row in Postgres
+func Verify(ctx context.Context) (VerifyReport, error) {
+	var report VerifyReport
+	inPostgres := make(map[int]bool)
+
+	var books []models.Book
+	err := config.GetDB().WithContext(ctx).Order("id").FindInBatches(&books, batchSize, func(tx *gorm.DB, batch int) error {
+		for _, book := range books {
+			inPostgres[book.ID] = true
+			report.Checked++
+			cached, err := loadBook(ctx, bookKey(book.ID))
+			if errors.Is(err, redis.Nil) {
+				report.Missing++
+				continue
+			} else if err != nil {
+				slog.WarnContext(ctx, "Cached book can't be read", "book_id", book.ID, "error", err)
+				report.Stale = append(report.Stale, book.ID)
+				continue
+			}
+			if *cached != book {
+				report.Stale = append(report.Stale, book.ID)
+			}
+		}
+		return nil
+	}).Error
+	if err != nil {
+		return report, err
+	}
+
+	err = scanKeys(ctx, config.GetRedisClient(), bookKeyPattern(), func(keys []string) error {
+		for _, key := range keys {
+			id, err := strconv.Atoi(strings.TrimPrefix(key, cacheKey("BOOKS_ID:")))
+			if err != nil || !inPostgres[id] {
+				// Books created after the scan of Postgres started are reported too, rerun to rule them out
+				report.Orphaned = append(report.Orphaned, id)
+			}
+		}
+		return nil
+	})
+	return report, err
+}
+
+// cacheKeyName matches the name of a key of the book cache, after REDIS_KEY_PREFIX, with the version
+// and encoding that wrote it. Keys written before they were versioned have neither, and keys of version
+// 1 written before the encoding was part of the keys have no encoding.
+var cacheKeyName = regexp.MustCompile(`^(?:v(\d+):(?:(auto|json|string|msgpack):)?)?(?:BOOKS_(?:ID|NOT_FOUND|INDEX):|BOOKS_CACHE_COMPLETE$|LOCK:BOOKS_ID:)`)
+
+// CleanupKeys deletes the keys of the book cache written by versions before keyVersion, and before keys
+// were versioned or carried their encoding, in the namespace of REDIS_KEY_PREFIX. Keys of keyVersion with
+// another CACHE_ENCODING may still be used by instances configured differently, they are deleted only with
+// otherEncodings. Keys of later versions are always kept. It returns the number of keys by version and
+// encoding, "unversioned" for those without a version, and with dryRun only counts them.
+func CleanupKeys(ctx context.Context, otherEncodings, dryRun bool) (map[string]int, error) {
+	client := config.GetRedisClient()
+	prefix := config.RedisKey("")
+	counts := make(map[string]int)
+	cleanup := func(keys []string) error {
+		old := make([]string, 0, len(keys))
+		for _, key := range keys {
+			match := cacheKeyName.FindStringSubmatch(strings.TrimPrefix(key, prefix))
+			if match == nil {
+				continue
+			}
+			version := "unversioned"
+			if match[1] != "" {
+				n, err := strconv.Atoi(match[1])
+				if err != nil || n > keyVersion {
+					continue
+				}
+				if n == keyVersion && match[2] != "" && (match[2] == keyEncoding() || !otherEncodings) {
+					continue
+				}
+				version = "v" + match[1]
+				if match[2] != "" {
+					version += ":" + match[2]
+				}
+			}
+			counts[version]++
+			old = append(old, key)
+		}
+		if dryRun {
+			return nil
+		}
+		_, err := unlinkKeys(ctx, client, old...)
+		return err
+	}
+	for _, pattern := range []string{"v*", "BOOKS_*", "LOCK:BOOKS_ID:*"} {
+		if err := scanKeys(ctx, client, patternEscaper.Replace(prefix)+pattern, cleanup); err != nil {
+			return counts, err
+		}
+	}
+	return counts, nil
+}
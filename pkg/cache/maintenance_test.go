@@ -0,0 +1,51 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+func TestCleanupKeys(t *testing.T) {
+	current := fmt.Sprintf("v%d:%s:", keyVersion, EncodingString)
+	tests := []struct {
+		key string
+		// deleted tells whether the key is deleted by default and with --other-encodings
+		deleted, otherEncodings bool
+	}{
+		{"app:BOOKS_ID:1", true, true},
+		{fmt.Sprintf("app:v%d:BOOKS_ID:1", keyVersion), true, true},
+		{"app:" + current + "BOOKS_ID:1", false, false},
+		{"app:" + current + "BOOKS_CACHE_COMPLETE", false, false},
+		// Instances configured with another encoding may still use these
+		{fmt.Sprintf("app:v%d:%s:BOOKS_ID:1", keyVersion, EncodingMsgpack), false, true},
+		{fmt.Sprintf("app:v%d:%s:BOOKS_ID:1", keyVersion+1, EncodingString), false, false},
+		{"app:API_KEY:abc", false, false},
+		{"other:BOOKS_ID:1", false, false},
+	}
+	for _, otherEncodings := range []bool{false, true} {
+		server := setup(t, map[string]string{"REDIS_KEY_PREFIX": "app:"})
+		for _, tt := range tests {
+			server.Set(tt.key, "{}")
+		}
+
+		if _, err := CleanupKeys(context.Background(), otherEncodings, true); err != nil {
+			t.Fatalf("CleanupKeys() dry run error = %v", err)
+		}
+		if n := len(server.Keys()); n != len(tests) {
+			t.Errorf("a dry run left %d of the %d keys", n, len(tests))
+		}
+		if _, err := CleanupKeys(context.Background(), otherEncodings, false); err != nil {
+			t.Fatalf("CleanupKeys() error = %v", err)
+		}
+		for _, tt := range tests {
+			want := tt.deleted
+			if otherEncodings {
+				want = tt.otherEncodings
+			}
+			if server.Exists(tt.key) == want {
+				t.Errorf("CleanupKeys(otherEncodings %t) deleted %s = %t, want %t", otherEncodings, tt.key, !want, want)
+			}
+		}
+	}
+}
@@ -2,122 +2,200 @@ package cache
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
 
 	"github.com/arepala-uml/books-management-system/pkg/config"
+	"github.com/arepala-uml/books-management-system/pkg/metrics"
 	"github.com/arepala-uml/books-management-system/pkg/models"
-	"github.com/arepala-uml/books-management-system/pkg/utils"
-	"github.com/labstack/gommon/log"
-	"github.com/spf13/viper"
 
 	"github.com/go-redis/redis/v8"
 )
 
-var ctx = context.Background()
+// enabled reports whether FEATURE_BOOK_CACHE is on. While it is off, lookups miss and stores drop
+// the cached copy instead, so nothing stale is served once it is turned back on.
+func enabled() bool {
+	return config.Get().Features.BookCache
+}
 
-func GetBookFromCache(id string) (*models.Book, error) {
-	// Construct the Redis key in the format "BOOKS_ID:<ID_NUMBER>"
-	redisKey := fmt.Sprintf("BOOKS_ID:%s", id)
-	bookData, err := utils.ReJSONGet(redisKey, ".")
-	if err != nil {
-		log.Errorf("Error getting book from Redis: %v", err)
+func GetBookFromCache(ctx context.Context, id string) (*models.Book, error) {
+	if !enabled() {
+		return nil, redis.Nil
 	}
-
-	var book models.Book
-	if dataMap, ok := bookData.(map[string]interface{}); ok {
-
-		bookBytes, err := json.Marshal(dataMap)
-		if err != nil {
-			log.Printf("Error marshaling book data to JSON: %v", err)
-			return nil, err
+	if localEnabled() {
+		if book, ok := localGet(id); ok {
+			metrics.ObserveCacheTier(metrics.CacheTierLocal, metrics.CacheHit)
+			metrics.ObserveCache("get_book", metrics.CacheHit)
+			return &book, nil
 		}
+		metrics.ObserveCacheTier(metrics.CacheTierLocal, metrics.CacheMiss)
+	}
 
-		err = json.Unmarshal(bookBytes, &book)
-		if err != nil {
-			log.Printf("Error unmarshaling book data: %v", err)
-			return nil, err
-		}
-	} else {
-		log.Error("Retrieved data is not a valid book format")
+	// Construct the Redis key in the format "v<version>:BOOKS_ID:<ID_NUMBER>"
+	redisKey := cacheKey("BOOKS_ID:" + id)
+	book, err := loadBook(ctx, redisKey)
+	if errors.Is(err, redis.Nil) {
+		metrics.ObserveCacheTier(metrics.CacheTierRedis, metrics.CacheMiss)
+		metrics.ObserveCache("get_book", metrics.CacheMiss)
+		return nil, err
+	} else if err != nil {
+		slog.ErrorContext(ctx, "Error getting book from Redis", "key", redisKey, "error", err)
+		metrics.ObserveCacheTier(metrics.CacheTierRedis, metrics.CacheError)
+		metrics.ObserveCache("get_book", metrics.CacheError)
 		return nil, err
 	}
-	return &book, nil
+	metrics.ObserveCacheTier(metrics.CacheTierRedis, metrics.CacheHit)
+	metrics.ObserveCache("get_book", metrics.CacheHit)
+	localSet(fmt.Sprint(book.ID), *book)
+	return book, nil
 }
 
-func GetBooksFromCache() ([]models.Book, error) {
-	books := make([]models.Book, 0)
-
-	iter := config.RedisClient.Scan(ctx, 0, "BOOKS_ID:*", 0).Iterator()
-	for iter.Next(ctx) {
-		redisKey := iter.Val()
-		bookData, err := utils.ReJSONGet(redisKey, ".")
-		if err == redis.Nil {
-			continue
-		} else if err != nil {
-			log.Errorf("Error fetching book data from Redis for key %s: %v", redisKey, err)
-			continue
-		}
-
-		if dataMap, ok := bookData.(map[string]interface{}); ok {
-			bookBytes, err := json.Marshal(dataMap)
-			if err != nil {
-				log.Errorf("Error marshaling book data to JSON: %v", err)
-				continue
-			}
-			var book models.Book
-			err = json.Unmarshal(bookBytes, &book)
-			if err != nil {
-				log.Errorf("Error unmarshaling book data: %v", err)
-				continue
-			}
-			books = append(books, book)
-		} else {
-			log.Printf("Retrieved data for key %s is not a valid book format", redisKey)
+// GetBooksFromCache returns a page of the book list in the order of sort, limit books from offset. The
+// cache holds every book only after a warm-up, until then it returns redis.Nil rather than a partial list.
+func GetBooksFromCache(ctx context.Context, sort BookSort, limit, offset int) ([]models.Book, error) {
+	if !enabled() {
+		return nil, redis.Nil
+	}
+	complete, err := config.GetRedisClient().Exists(ctx, completeKey()).Result()
+	if err != nil {
+		slog.ErrorContext(ctx, "Error checking whether the book list is cached", "error", err)
+		metrics.ObserveCache("get_books", metrics.CacheError)
+		return nil, err
+	} else if complete == 0 {
+		metrics.ObserveCache("get_books", metrics.CacheMiss)
+		return nil, redis.Nil
+	}
+	c, err := currentCodec(ctx)
+	if err != nil {
+		metrics.ObserveCache("get_books", metrics.CacheError)
+		return nil, err
+	}
+	// Read the ids of the page from the index, then the books in one round trip
+	client := config.GetRedisClient()
+	keys, err := indexPage(ctx, client, sort, limit, offset)
+	books := make([]models.Book, 0, len(keys))
+	if err == nil && len(keys) > 0 {
+		books, err = getBooks(ctx, c, client, keys)
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Error fetching the books from Redis", "error", err)
+		metrics.ObserveCache("get_books", metrics.CacheError)
+		return nil, err
+	}
+	if len(books) != len(keys) {
+		// Evicted by Redis, the list is read from Postgres until the next warm-up
+		slog.WarnContext(ctx, "Indexed books are missing from the cache, invalidating the book list",
+			"missing", len(keys)-len(books))
+		metrics.ObserveCache("get_books", metrics.CacheMiss)
+		if err := invalidateList(ctx); err != nil {
+			slog.ErrorContext(ctx, "Failed to invalidate the book list", "error", err)
 		}
+		return nil, redis.Nil
 	}
+	metrics.ObserveCache("get_books", metrics.CacheHit)
+	return books, nil
+}
+
+func bookKey(id int) string {
+	return cacheKey(fmt.Sprintf("BOOKS_ID:%d", id))
+}
 
-	if err := iter.Err(); err != nil {
-		log.Printf("Error iterating over Redis keys: %v", err)
+// loadBook reads and decodes the book cached at redisKey, returning redis.Nil when it isn't cached
+func loadBook(ctx context.Context, redisKey string) (*models.Book, error) {
+	c, err := currentCodec(ctx)
+	if err != nil {
 		return nil, err
 	}
-
-	return books, nil
+	return c.get(ctx, config.GetRedisClient(), redisKey)
 }
 
-func StoreBookInCache(book models.Book) error {
-	redisKey := fmt.Sprintf("BOOKS_ID:%d", book.ID)
-	err := utils.ReJSONSet(redisKey, ".", book, viper.GetInt("REDIS_EXPIRY_BOOKS"))
+// StoreBookInCache records a change to a book: it caches and indexes the new version, and publishes
+// an invalidation so the other instances evict their copy. Books read from Postgres without being
+// changed are cached with StoreBooksInCache instead.
+func StoreBookInCache(ctx context.Context, book models.Book) error {
+	if !enabled() {
+		// The list would miss this change once the cache is back on
+		if err := invalidateList(ctx); err != nil {
+			return err
+		}
+		return DeleteBookFromCache(ctx, fmt.Sprint(book.ID))
+	}
+	redisKey := bookKey(book.ID)
+	// Counted before the write, so a warm-up caching a copy read before the change sees it once the
+	// copy overwrote this one
+	err := bumpGeneration(ctx, generationBooks)
+	var c codec
+	if err == nil {
+		c, err = currentCodec(ctx)
+	}
+	if err == nil {
+		err = c.set(ctx, config.GetRedisClient(), redisKey, book, time.Duration(expiry())*time.Second)
+	}
 	if err != nil {
-		log.Errorf("Failed to set data for the key - %s, %v", redisKey, err)
+		// The list would keep the book where it was, and the previous version may still be cached
+		slog.ErrorContext(ctx, "Failed to set data for the key, invalidating the book list", "key", redisKey, "error", err)
+		return errors.Join(err, invalidateList(ctx))
+	}
+	if err := indexBooks(ctx, config.GetRedisClient(), book); err != nil {
+		// The list would miss the book, or show it where it was
+		slog.ErrorContext(ctx, "Failed to index the book, invalidating the book list", "book_id", book.ID, "error", err)
+		return errors.Join(err, invalidateList(ctx))
+	}
+	// The book may have been looked up before it was created
+	if err := config.GetRedisClient().Del(ctx, notFoundKey(fmt.Sprint(book.ID))).Err(); err != nil {
+		slog.ErrorContext(ctx, "Failed to forget that the book didn't exist", "book_id", book.ID, "error", err)
 		return err
 	}
-	log.Printf("Book cached successfully with ID: %d", book.ID)
+	// Other instances read the new book from Redis on their next lookup
+	invalidate(ctx, fmt.Sprint(book.ID))
+	localSet(fmt.Sprint(book.ID), book)
+	slog.InfoContext(ctx, "Book cached successfully", "book_id", book.ID)
 	return nil
 }
 
-func StoreBooksInCache(books []models.Book) error {
+// StoreBooksInCache fills the cache with books read from Postgres, in a single round trip. They aren't
+// changes, so no invalidation is published and the copies of the other instances stay in use.
+func StoreBooksInCache(ctx context.Context, books []models.Book) error {
+	if !enabled() || len(books) == 0 {
+		// Books read from Postgres aren't changes, there is no cached copy they make stale
+		return nil
+	}
+	if err := storeBooks(ctx, books); err != nil {
+		// Some books may be cached but missing from the index
+		slog.ErrorContext(ctx, "Error storing books in cache, invalidating the book list", "count", len(books), "error", err)
+		return errors.Join(err, invalidateList(ctx))
+	}
 	for _, book := range books {
-		err := StoreBookInCache(book)
-		if err != nil {
-			log.Printf("Error storing book %d in cache: %v", book.ID, err)
-			return err
-		}
+		localSet(fmt.Sprint(book.ID), book)
 	}
-	log.Info("All books cached successfully")
+	slog.InfoContext(ctx, "All books cached successfully", "count", len(books))
 	return nil
 }
 
-func DeleteBookFromCache(id string) error {
-	redisKey := fmt.Sprintf("BOOKS_ID:%s", id)
-	if utils.RedisKeyExists(redisKey) {
-		log.Infof("Deleting Book with redis key from redis %v", redisKey)
-		err := utils.ReJSONDel(redisKey, ".")
-		if err != nil {
-			log.Printf("Error deleting book from cache: %v", err)
+func DeleteBookFromCache(ctx context.Context, id string) error {
+	// Other instances evict their copy once it is gone from Redis
+	defer invalidate(ctx, id)
+	redisKey := cacheKey("BOOKS_ID:" + id)
+	// Counted first, so a warm-up caching a copy read before the deletion drops it again
+	if err := bumpGeneration(ctx, generationBooks); err != nil {
+		slog.ErrorContext(ctx, "Error counting the deletion of a book", "book_id", id, "error", err)
+		return err
+	}
+	// Unindexed before it is deleted, so a racing store can leave an indexed book missing, which
+	// invalidates the list when read, but never a cached book missing from the list
+	if bookID, err := strconv.Atoi(id); err == nil {
+		if err := unindexBooks(ctx, config.GetRedisClient(), bookID); err != nil {
+			slog.ErrorContext(ctx, "Error removing book from the index", "book_id", id, "error", err)
 			return err
 		}
 	}
-	log.Infof("Book removed from cache with ID: %s", id)
+	if err := config.GetRedisClient().Del(ctx, redisKey).Err(); err != nil {
+		slog.ErrorContext(ctx, "Error deleting book from cache", "key", redisKey, "error", err)
+		return err
+	}
+	slog.InfoContext(ctx, "Book removed from cache", "book_id", id)
 	return nil
 }
@@ -0,0 +1,352 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/arepala-uml/books-management-system/pkg/config"
+	"github.com/arepala-uml/books-management-system/pkg/models"
+	"github.com/go-redis/redis/v8"
+	"gorm.io/gorm"
+)
+
+// completeKey marks the cached books as the complete set of books, so GetBooksFromCache can serve the
+// list from the cache. It is set by a warm-up and expires no later than the first book it cached.
+func completeKey() string {
+	return cacheKey("BOOKS_CACHE_COMPLETE")
+}
+
+// generationKey counts the changes to cached books in its field generationBooks and the invalidations
+// of the book list in generationList, so a warm-up notices those made while it runs. A single hash keeps
+// both on one node of a Redis Cluster.
+func generationKey() string {
+	return cacheKey("BOOKS_CACHE_GENERATION")
+}
+
+const (
+	generationBooks = "books"
+	generationList  = "list"
+)
+
+// bumpGeneration counts a change of the kind field
+func bumpGeneration(ctx context.Context, field string) error {
+	return config.GetRedisClient().HIncrBy(ctx, generationKey(), field, 1).Err()
+}
+
+// generation returns the count of the changes of the kind field
+func generation(ctx context.Context, field string) (int64, error) {
+	count, err := config.GetRedisClient().HGet(ctx, generationKey(), field).Int64()
+	if errors.Is(err, redis.Nil) {
+		return 0, nil
+	}
+	return count, err
+}
+
+// warmAttempts is how often a batch of books is read again from Postgres when books change while it is
+// cached, before it is left out of the warm-up
+const warmAttempts = 3
+
+// progressInterval is how often a running warm-up logs its progress
+const progressInterval = 5 * time.Second
+
+// ErrWarmRunning is returned when a warm-up is requested while one is running in this instance
+var ErrWarmRunning = errors.New("a cache warm-up is already running")
+
+// WarmStatus is the progress of the current or last cache warm-up
+type WarmStatus struct {
+	Running    bool       `json:"running"`
+	Total      int64      `json:"total"`
+	Warmed     int64      `json:"warmed"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	Error      string     `json:"error,omitempty"`
+}
+
+var warmer struct {
+	mu     sync.Mutex
+	status WarmStatus
+}
+
+// WarmProgress returns the progress of the current or last warm-up
+func WarmProgress() WarmStatus {
+	warmer.mu.Lock()
+	defer warmer.mu.Unlock()
+	return warmer.status
+}
+
+// Warm caches every book of Postgres and marks the cache as complete. Books are read in batches of
+// CACHE_WARM_BATCH_SIZE, at most CACHE_WARM_ROWS_PER_SECOND, and written with one pipeline per batch.
+// It returns the final status, and ErrWarmRunning when a warm-up is already running.
+func Warm(ctx context.Context) (WarmStatus, error) {
+	if err := beginWarm(); err != nil {
+		return WarmProgress(), err
+	}
+	err := warm(ctx, config.GetDB())
+	return endWarm(err), err
+}
+
+// StartWarm starts a warm-up as a background worker with start, such as lifecycle.Lifecycle.Go so
+// a shutdown cancels it and waits for it, and returns its initial status
+func StartWarm(start func(name string, worker func(ctx context.Context))) (WarmStatus, error) {
+	if err := beginWarm(); err != nil {
+		return WarmProgress(), err
+	}
+	start("cache-warm", func(ctx context.Context) {
+		endWarm(warm(ctx, config.GetDB()))
+	})
+	return WarmProgress(), nil
+}
+
+func beginWarm() error {
+	warmer.mu.Lock()
+	defer warmer.mu.Unlock()
+	if warmer.status.Running {
+		return ErrWarmRunning
+	}
+	now := time.Now()
+	warmer.status = WarmStatus{Running: true, StartedAt: &now}
+	return nil
+}
+
+func endWarm(err error) WarmStatus {
+	warmer.mu.Lock()
+	defer warmer.mu.Unlock()
+	now := time.Now()
+	warmer.status.Running = false
+	warmer.status.FinishedAt = &now
+	if err != nil {
+		warmer.status.Error = err.Error()
+	}
+	return warmer.status
+}
+
+func setProgress(update func(status *WarmStatus)) {
+	warmer.mu.Lock()
+	defer warmer.mu.Unlock()
+	update(&warmer.status)
+}
+
+func warm(ctx context.Context, db *gorm.DB) error {
+	cfg := config.Get()
+	started := time.Now()
+
+	// The index is rebuilt from scratch, dropping the books it kept after they expired
+	if err := invalidateList(ctx); err != nil {
+		return err
+	}
+	if _, err := unlinkKeys(ctx, config.GetRedisClient(), indexKeys()...); err != nil {
+		return err
+	}
+	// The list is only marked complete when it wasn't invalidated since, and each batch is checked
+	// against the changes made since the one before it was written
+	listGeneration, err := generation(ctx, generationList)
+	if err != nil {
+		return err
+	}
+	booksGeneration, err := generation(ctx, generationBooks)
+	if err != nil {
+		return err
+	}
+	complete := true
+
+	var total int64
+	if err := db.WithContext(ctx).Model(&models.Book{}).Count(&total).Error; err != nil {
+		return err
+	}
+	setProgress(func(status *WarmStatus) { status.Total = total })
+	slog.InfoContext(ctx, "Warming the book cache", "books", total, "batch_size", cfg.Cache.WarmBatchSize,
+		"rows_per_second", cfg.Cache.WarmRowsPerSecond)
+
+	// Pace the batches so the reads stay under CACHE_WARM_ROWS_PER_SECOND
+	var pace time.Duration
+	if cfg.Cache.WarmRowsPerSecond > 0 {
+		pace = time.Duration(cfg.Cache.WarmBatchSize) * time.Second / time.Duration(cfg.Cache.WarmRowsPerSecond)
+	}
+	var warmed int64
+	lastReport := started
+	batchStarted := started
+
+	var books []models.Book
+	err = db.WithContext(ctx).Order("id").
+		FindInBatches(&books, cfg.Cache.WarmBatchSize, func(tx *gorm.DB, batch int) error {
+			stored, err := storeBatch(ctx, db, books, &booksGeneration)
+			if err != nil {
+				return err
+			}
+			complete = complete && stored
+			warmed += int64(len(books))
+			setProgress(func(status *WarmStatus) { status.Warmed = warmed })
+			if time.Since(lastReport) >= progressInterval {
+				slog.InfoContext(ctx, "Warming the book cache", "warmed", warmed, "books", total)
+				lastReport = time.Now()
+			}
+
+			wait := pace - time.Since(batchStarted)
+			if wait > 0 {
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case <-time.After(wait):
+				}
+			}
+			batchStarted = time.Now()
+			return nil
+		}).Error
+	if err != nil {
+		slog.ErrorContext(ctx, "Cache warm-up failed", "warmed", warmed, "books", total, "error", err)
+		return err
+	}
+
+	if !complete {
+		slog.WarnContext(ctx, "Books kept changing during the cache warm-up, the book list stays uncached")
+	} else if err := markComplete(ctx, cfg.Redis.ExpiryBooks, time.Since(started), listGeneration); err != nil {
+		return err
+	}
+	slog.InfoContext(ctx, "Cache warm-up finished", "warmed", warmed, "duration", time.Since(started).String())
+	return nil
+}
+
+// storeBooks writes and indexes a batch of books, with their expiry, in a single round trip
+func storeBooks(ctx context.Context, books []models.Book) error {
+	c, err := currentCodec(ctx)
+	if err != nil {
+		return err
+	}
+	pipe := config.GetRedisClient().Pipeline()
+	for _, book := range books {
+		if err := c.set(ctx, pipe, bookKey(book.ID), book, time.Duration(expiry())*time.Second); err != nil {
+			return err
+		}
+	}
+	if err := indexBooks(ctx, pipe, books...); err != nil {
+		return err
+	}
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// storeBatch caches a batch of books read from Postgres when the books generation was *since. A book
+// changed in between may have been read before the change and cached after it, so the batch is read and
+// cached again until no book changed meanwhile, and left out after warmAttempts. It reports whether the
+// batch was cached, and moves *since to the generation it was checked against.
+func storeBatch(ctx context.Context, db *gorm.DB, books []models.Book, since *int64) (bool, error) {
+	ids := make([]int, len(books))
+	for i, book := range books {
+		ids[i] = book.ID
+	}
+	for attempt := 1; ; attempt++ {
+		if err := storeBooks(ctx, books); err != nil {
+			return false, err
+		}
+		current, err := generation(ctx, generationBooks)
+		if err != nil {
+			return false, err
+		}
+		changed := current != *since
+		*since = current
+		if !changed {
+			return true, nil
+		}
+
+		// Deleted books are dropped, the others are cached again as they are now
+		var fresh []models.Book
+		if err := db.WithContext(ctx).Where("id IN ?", ids).Order("id").Find(&fresh).Error; err != nil {
+			return false, err
+		}
+		drop := make(map[int]bool, len(ids))
+		for _, id := range ids {
+			drop[id] = true
+		}
+		for _, book := range fresh {
+			delete(drop, book.ID)
+		}
+		if attempt == warmAttempts {
+			for _, book := range fresh {
+				drop[book.ID] = true
+			}
+			fresh = nil
+		}
+		if err := dropBooks(ctx, drop); err != nil {
+			return false, err
+		}
+		if attempt == warmAttempts {
+			slog.WarnContext(ctx, "Books kept changing while they were cached, leaving them out of the warm-up",
+				"first_id", ids[0], "last_id", ids[len(ids)-1])
+			return false, nil
+		}
+		books = fresh
+	}
+}
+
+// dropBooks removes the books with the given ids from the index and the cache
+func dropBooks(ctx context.Context, ids map[int]bool) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	unindexed := make([]int, 0, len(ids))
+	keys := make([]string, 0, len(ids))
+	for id := range ids {
+		unindexed = append(unindexed, id)
+		keys = append(keys, bookKey(id))
+	}
+	if err := unindexBooks(ctx, config.GetRedisClient(), unindexed...); err != nil {
+		return err
+	}
+	_, err := unlinkKeys(ctx, config.GetRedisClient(), keys...)
+	return err
+}
+
+// markComplete sets completeKey to expire with the first book cached by a warm-up that took elapsed,
+// unless the list generation moved past since, i.e. the list was invalidated during the warm-up. Nothing
+// is marked when the first books already expired.
+func markComplete(ctx context.Context, expiry int, elapsed time.Duration, since int64) error {
+	var ttl time.Duration
+	if expiry > 0 {
+		ttl = time.Duration(expiry)*time.Second - elapsed
+		if ttl <= 0 {
+			slog.WarnContext(ctx, "Cache warm-up took longer than REDIS_EXPIRY_BOOKS, the book list stays uncached")
+			return nil
+		}
+	}
+	invalidated := func() (bool, error) {
+		current, err := generation(ctx, generationList)
+		return current != since, err
+	}
+	if changed, err := invalidated(); err != nil || changed {
+		if changed {
+			slog.WarnContext(ctx, "The book list was invalidated during the cache warm-up, it stays uncached")
+		}
+		return err
+	}
+	if err := config.GetRedisClient().Set(ctx, completeKey(), 1, ttl).Err(); err != nil {
+		return err
+	}
+	// An invalidation between the check and the mark would be undone by it. Checking again after the mark
+	// catches it, invalidations after the check drop the mark themselves.
+	changed, err := invalidated()
+	if changed {
+		slog.WarnContext(ctx, "The book list was invalidated during the cache warm-up, it stays uncached")
+	}
+	if err != nil || changed {
+		return errors.Join(err, config.GetRedisClient().Del(ctx, completeKey()).Err())
+	}
+	return nil
+}
+
+// InvalidateList makes the book list be read from Postgres until the next warm-up, after books were
+// written to Postgres without going through the cache
+func InvalidateList(ctx context.Context) error {
+	return invalidateList(ctx)
+}
+
+// invalidateList drops completeKey so the book list is read from Postgres until the next warm-up. The
+// list generation is counted first, so a running warm-up doesn't mark the list complete again.
+func invalidateList(ctx context.Context) error {
+	if err := bumpGeneration(ctx, generationList); err != nil {
+		return err
+	}
+	return config.GetRedisClient().Del(ctx, completeKey()).Err()
+}
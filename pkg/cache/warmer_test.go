@@ -0,0 +1,121 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/arepala-uml/books-management-system/pkg/models"
+	"github.com/arepala-uml/books-management-system/pkg/testutil"
+	"gorm.io/gorm"
+)
+
+// setupWarm returns a database with books 1 to n over the cache of setup
+func setupWarm(t *testing.T, n int) *gorm.DB {
+	t.Helper()
+	setup(t, nil)
+	db := testutil.DB(t, &models.Book{})
+	for i := 1; i <= n; i++ {
+		book := models.Book{ID: i, Title: fmt.Sprintf("Title %d", i), Author: "Author", Year: 1900 + i}
+		if err := db.Create(&book).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	return db
+}
+
+func TestWarm(t *testing.T) {
+	db := setupWarm(t, 5)
+	ctx := context.Background()
+	// Left by an earlier warm-up and gone from Postgres since
+	if err := storeBooks(ctx, []models.Book{{ID: 9, Title: "Deleted"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := warm(ctx, db); err != nil {
+		t.Fatalf("warm() error = %v", err)
+	}
+	books, err := GetBooksFromCache(ctx, BookSort{Field: SortID}, -1, 0)
+	if got := ids(books); err != nil || fmt.Sprint(got) != "[1 2 3 4 5]" {
+		t.Errorf("book list after warm() = %v, %v, want [1 2 3 4 5]", got, err)
+	}
+}
+
+func TestMarkCompleteAfterInvalidation(t *testing.T) {
+	server := setup(t, nil)
+	ctx := context.Background()
+	since, err := generation(ctx, generationList)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A seed or a failed store during the warm-up
+	if err := InvalidateList(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := markComplete(ctx, 0, 0, since); err != nil {
+		t.Fatalf("markComplete() error = %v", err)
+	}
+	if server.Exists(completeKey()) {
+		t.Error("the book list was marked complete after an invalidation during the warm-up")
+	}
+
+	// Changes to books keep the list complete, they are written through to it
+	since, _ = generation(ctx, generationList)
+	if err := StoreBookInCache(ctx, models.Book{ID: 1, Title: "Dune"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := markComplete(ctx, 0, 0, since); err != nil {
+		t.Fatalf("markComplete() error = %v", err)
+	}
+	if !server.Exists(completeKey()) {
+		t.Error("the book list wasn't marked complete")
+	}
+}
+
+// TestStoreBatchAfterChanges checks a batch read from Postgres before books changed doesn't overwrite
+// the changes written through the cache
+func TestStoreBatchAfterChanges(t *testing.T) {
+	db := setupWarm(t, 3)
+	ctx := context.Background()
+	since, err := generation(ctx, generationBooks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var batch []models.Book
+	if err := db.Order("id").Find(&batch).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	// Book 1 is updated and book 2 deleted after the batch was read, and both are written through first
+	updated := batch[0]
+	updated.Title = "Dune"
+	if err := db.Save(&updated).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := StoreBookInCache(ctx, updated); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Delete(&models.Book{}, 2).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := DeleteBookFromCache(ctx, "2"); err != nil {
+		t.Fatal(err)
+	}
+
+	stored, err := storeBatch(ctx, db, batch, &since)
+	if err != nil || !stored {
+		t.Fatalf("storeBatch() = %t, %v, want the batch stored", stored, err)
+	}
+	listGeneration, _ := generation(ctx, generationList)
+	if err := markComplete(ctx, 0, 0, listGeneration); err != nil {
+		t.Fatal(err)
+	}
+	books, err := GetBooksFromCache(ctx, BookSort{Field: SortID}, -1, 0)
+	if got := ids(books); err != nil || fmt.Sprint(got) != "[1 3]" {
+		t.Fatalf("book list = %v, %v, want [1 3]", got, err)
+	}
+	if books[0].Title != "Dune" {
+		t.Errorf("book 1 = %q, want the update %q", books[0].Title, "Dune")
+	}
+}
@@ -0,0 +1,86 @@
+package config
+
+import (
+	"context"
+	"errors"
+
+	"github.com/arepala-uml/books-management-system/pkg/breaker"
+	"github.com/go-redis/redis/v8"
+)
+
+// redisBreaker fails Redis commands fast while Redis is down, so requests fall back to Postgres
+// without waiting on it
+var redisBreaker = breaker.New("redis", BreakerSettings, func(ctx context.Context) error {
+	client := GetRedisClient()
+	if client == nil {
+		return errors.New("not connected")
+	}
+	return client.Ping(ctx).Err()
+})
+
+// RedisAvailable reports whether the circuit breaker of Redis is closed
+func RedisAvailable() bool {
+	return redisBreaker.Allow() == nil
+}
+
+// OnRedisRecovered registers fn to be called in the background whenever Redis is available again
+// after its circuit breaker opened
+func OnRedisRecovered(fn func()) {
+	redisBreaker.OnClose(fn)
+}
+
+// BreakerSettings returns the current settings of the circuit breakers
+func BreakerSettings() breaker.Settings {
+	cfg := Get().Breaker
+	return breaker.Settings{FailureThreshold: cfg.FailureThreshold, ProbeInterval: cfg.ProbeInterval}
+}
+
+// breakerHook runs every Redis command through redisBreaker. PING always goes through, so the
+// readiness probe reports the actual state of Redis.
+type breakerHook struct{}
+
+func (breakerHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	if cmd.Name() == "ping" {
+		return ctx, nil
+	}
+	return ctx, redisBreaker.Allow()
+}
+
+func (breakerHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	recordRedis(cmd.Err())
+	return nil
+}
+
+func (breakerHook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	return ctx, redisBreaker.Allow()
+}
+
+func (breakerHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	for _, cmd := range cmds {
+		if unavailable(cmd.Err()) {
+			recordRedis(cmd.Err())
+			return nil
+		}
+	}
+	recordRedis(nil)
+	return nil
+}
+
+func recordRedis(err error) {
+	if errors.Is(err, breaker.ErrOpen) || errors.Is(err, context.Canceled) {
+		return
+	}
+	if unavailable(err) {
+		redisBreaker.Record(err)
+	} else {
+		redisBreaker.Record(nil)
+	}
+}
+
+// unavailable reports whether err means Redis didn't answer. Replies such as redis.Nil or WRONGTYPE
+// come from a working server.
+func unavailable(err error) bool {
+	var reply redis.Error
+	return err != nil && !errors.As(err, &reply) && !errors.Is(err, breaker.ErrOpen) &&
+		!errors.Is(err, context.Canceled)
+}
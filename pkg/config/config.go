@@ -3,81 +3,228 @@ package config
 import (
 	"context"
 	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-redis/redis/v8"
-	"github.com/labstack/gommon/log"
-	"github.com/nitishm/go-rejson/v4"
-	"github.com/spf13/viper"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 )
 
+// redisClient holds the Redis client, whose type depends on REDIS_MODE, so it can be swapped atomically
+type redisClient struct {
+	redis.UniversalClient
+}
+
 var (
-	DB            *gorm.DB
-	RedisClient   *redis.Client
-	ReJSONHandler *rejson.Handler
-	ctx           = context.Background()
-	RedisAddr     string
+	db  atomic.Pointer[gorm.DB]
+	rdb atomic.Pointer[redisClient]
+	ctx = context.Background()
+
+	// gormPlugins and redisHooks are installed on every connection, including those rebuilt by a reload
+	clientsMu   sync.Mutex
+	gormPlugins []gorm.Plugin
+	redisHooks  []redis.Hook
 )
 
 func Connect() {
 	// Initialize Postgres and Redis connection
-	initPostgres()
-	initRedis()
+	ConnectPostgres()
+	ConnectRedis()
 }
 
-func initRedis() {
-	RedisAddr := viper.GetString("REDIS_HOST") + ":" + viper.GetString("REDIS_PORT")
-	RedisPassword := viper.GetString("REDIS_PASSWORD")
-	RedisDB := viper.GetInt("REDIS_DB")
+// ConnectPostgres connects to Postgres only, for commands that don't need Redis
+func ConnectPostgres() {
+	d, err := connectPostgres(Get().Postgres)
+	if err != nil {
+		slog.Error("Failed to connect to PostgreSQL", "url", Get().Postgres.URL(true), "error", err)
+		os.Exit(1)
+	}
+	db.Store(d)
+}
 
-	log.Infof("Redis is running at %s", RedisAddr)
+// OpenRedis connects to Redis for the server, which keeps serving from Postgres while Redis is down.
+// When Redis doesn't answer, its circuit breaker opens and the client reconnects once it is back.
+func OpenRedis() {
+	client := newRedisClient(Get().Redis)
+	if err := client.Ping(ctx).Err(); err != nil {
+		slog.Warn("Redis is unavailable, serving from Postgres until it is back", "mode", Get().Redis.Mode,
+			"address", Get().Redis.Address(), "error", err)
+		redisBreaker.Trip(err)
+	} else {
+		slog.Info("Successfully connected to Redis")
+	}
+	rdb.Store(&redisClient{client})
+}
 
-	RedisClient = redis.NewClient(&redis.Options{
-		Addr:         RedisAddr,
-		Password:     RedisPassword,
-		DB:           RedisDB,
-		WriteTimeout: time.Duration(120) * time.Second,
-		DialTimeout:  time.Duration(10) * time.Second,
-		ReadTimeout:  time.Duration(60) * time.Second,
-	})
-	ReJSONHandler = rejson.NewReJSONHandler()
-	ReJSONHandler.SetGoRedisClient(RedisClient)
+// ConnectRedis connects to Redis only, for commands that don't need Postgres
+func ConnectRedis() {
+	client, err := connectRedis(Get().Redis)
+	if err != nil {
+		slog.Error("Failed to connect to Redis", "mode", Get().Redis.Mode, "address", Get().Redis.Address(), "error", err)
+		os.Exit(1)
+	}
+	rdb.Store(&redisClient{client})
+}
 
-	_, err := RedisClient.Ping(ctx).Result()
+func connectRedis(cfg RedisConfig) (redis.UniversalClient, error) {
+	client := newRedisClient(cfg)
+	if _, err := client.Ping(ctx).Result(); err != nil {
+		client.Close()
+		return nil, err
+	}
+	slog.Info("Successfully connected to Redis")
+	return client, nil
+}
+
+// newRedisClient creates the Redis client of REDIS_MODE with its hooks, it connects on the first command.
+// A sentinel client follows the master through failovers, and a cluster client routes each key to its shard.
+func newRedisClient(cfg RedisConfig) redis.UniversalClient {
+	slog.Info("Connecting to Redis", "mode", cfg.Mode, "address", cfg.Address())
+
+	opts := &redis.UniversalOptions{
+		Addrs:            cfg.Addresses(),
+		Password:         cfg.Password.Reveal(),
+		DB:               cfg.DB,
+		MasterName:       cfg.MasterName,
+		SentinelPassword: cfg.SentinelPassword.Reveal(),
+		WriteTimeout:     time.Duration(120) * time.Second,
+		DialTimeout:      time.Duration(10) * time.Second,
+		ReadTimeout:      time.Duration(60) * time.Second,
+	}
+	var client redis.UniversalClient
+	switch cfg.Mode {
+	case RedisModeSentinel:
+		client = redis.NewFailoverClient(opts.Failover())
+	case RedisModeCluster:
+		client = redis.NewClusterClient(opts.Cluster())
+	default:
+		client = redis.NewClient(opts.Simple())
+	}
+	client.AddHook(breakerHook{})
+
+	clientsMu.Lock()
+	for _, hook := range redisHooks {
+		client.AddHook(hook)
+	}
+	clientsMu.Unlock()
+
+	return client
+}
+
+func connectPostgres(cfg PostgresConfig) (*gorm.DB, error) {
+	slog.Info("Connecting to PostgreSQL", "url", cfg.URL(true))
+	d, err := gorm.Open(postgres.Open(cfg.URL(false)), &gorm.Config{TranslateError: true})
 	if err != nil {
-		log.Fatalf("Failed to connect to Redis: %v", err)
+		return nil, err
 	}
 
-	log.Info("Successfully connected to Redis with RedisJSON!")
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+	for _, plugin := range gormPlugins {
+		if err := d.Use(plugin); err != nil {
+			closePool(d)
+			return nil, fmt.Errorf("failed to install gorm plugin %s: %w", plugin.Name(), err)
+		}
+	}
+	slog.Info("Successfully connected to PostgreSQL")
+	return d, nil
 }
 
-func initPostgres() {
-	databaseName := viper.GetString("POSTGRES_DB")
-	databaseUser := viper.GetString("POSTGRES_USER")
-	databasePassword := viper.GetString("POSTGRES_PASSWORD")
-	databaseHost := viper.GetString("POSTGRES_HOST")
-	databasePort := viper.GetString("POSTGRES_PORT")
+// UseGormPlugin installs plugin on the Postgres connection, and on those rebuilt by later reloads
+func UseGormPlugin(plugin gorm.Plugin) error {
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+	gormPlugins = append(gormPlugins, plugin)
+	if d := db.Load(); d != nil {
+		return d.Use(plugin)
+	}
+	return nil
+}
 
-	//PostgreSQL connection string
-	connectionLink := fmt.Sprintf("postgresql://%s:%s@%s:%s/%s?sslmode=disable",
-		databaseUser, databasePassword, databaseHost, databasePort, databaseName)
+// AddRedisHook installs hook on the Redis client, and on those rebuilt by later reloads
+func AddRedisHook(hook redis.Hook) {
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+	redisHooks = append(redisHooks, hook)
+	if client := rdb.Load(); client != nil {
+		client.AddHook(hook)
+	}
+}
 
-	log.Info("PostgreSQL Connection URL: ", connectionLink)
-	d, err := gorm.Open(postgres.Open(connectionLink), &gorm.Config{})
+// ReconnectPostgres is the Subscriber rebuilding the Postgres connection when its settings change.
+// Queries already running finish on the previous pool, which is closed once they are done.
+func ReconnectPostgres(old, new *Config) error {
+	if old.Postgres == new.Postgres {
+		return nil
+	}
+	d, err := connectPostgres(new.Postgres)
 	if err != nil {
-		log.Fatal("Failed to connect to PostgreSQL:", err)
+		return fmt.Errorf("keeping the current PostgreSQL connection: %w", err)
 	}
-	DB = d
+	previous := db.Swap(d)
+	Retire("postgres", func() error { return closePool(previous) })
+	return nil
+}
 
-	log.Info("Successfully connected to PostgreSQL (Local)!")
+// ReconnectRedis is the Subscriber rebuilding the Redis client when its connection settings change.
+// Commands already running finish on the previous client, which is closed once they are done.
+func ReconnectRedis(old, new *Config) error {
+	previousCfg, newCfg := old.Redis, new.Redis
+	previousCfg.ExpiryBooks, newCfg.ExpiryBooks = 0, 0
+	previousCfg.KeyPrefix, newCfg.KeyPrefix = "", ""
+	if previousCfg == newCfg {
+		return nil
+	}
+	client, err := connectRedis(new.Redis)
+	if err != nil {
+		return fmt.Errorf("keeping the current Redis connection: %w", err)
+	}
+	previous := rdb.Swap(&redisClient{client})
+	Retire("redis", previous.Close)
+	return nil
 }
 
 func GetDB() *gorm.DB {
-	return DB
+	return db.Load()
+}
+
+// GetRedisClient returns the Redis client: a single node, sentinel or cluster client per REDIS_MODE
+func GetRedisClient() redis.UniversalClient {
+	if client := rdb.Load(); client != nil {
+		return client.UniversalClient
+	}
+	return nil
+}
+
+// RedisKey returns key, or a channel name, in the namespace of REDIS_KEY_PREFIX
+func RedisKey(key string) string {
+	return Get().Redis.KeyPrefix + key
+}
+
+// ClosePostgres closes the connection pool of Postgres
+func ClosePostgres() error {
+	if d := db.Load(); d != nil {
+		return closePool(d)
+	}
+	return nil
 }
 
-func GetRedisClient() *redis.Client {
-	return RedisClient
+func closePool(d *gorm.DB) error {
+	sqlDB, err := d.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.Close()
+}
+
+// CloseRedis closes the connection pool of Redis
+func CloseRedis() error {
+	if client := GetRedisClient(); client != nil {
+		return client.Close()
+	}
+	return nil
 }
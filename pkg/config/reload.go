@@ -0,0 +1,127 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Subscriber applies a reloaded configuration, it's given the configuration before and after the reload.
+// It returns an error when the change can't be applied, and must then keep what it had before.
+type Subscriber func(old, new *Config) error
+
+type subscriber struct {
+	name string
+	fn   Subscriber
+}
+
+var (
+	// reloadMu serialises reloads, and the changes to the subscribers and validators
+	reloadMu    sync.Mutex
+	subscribers []subscriber
+	validators  []func(*Config) error
+)
+
+// Subscribe registers fn to be called after every reload, in the order of subscription
+func Subscribe(name string, fn Subscriber) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+	subscribers = append(subscribers, subscriber{name: name, fn: fn})
+}
+
+// AddValidator registers a check run along with Validate whenever the configuration is loaded,
+// for settings parsed by other packages
+func AddValidator(fn func(*Config) error) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+	validators = append(validators, fn)
+}
+
+// Reload loads the configuration again from the file it was first loaded from and the environment.
+// An invalid configuration is rejected and the current one stays in use. Otherwise it becomes current
+// and every subscriber is called, in order. When a subscriber can't apply it, the reload is rolled back:
+// the previous configuration becomes current again, the subscribers that applied the new one are called
+// with the configurations swapped to restore it, and the errors are returned.
+func Reload() error {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	old := Get()
+	cfg, err := loadWith(old.file, validators)
+	if err != nil {
+		return fmt.Errorf("configuration reload rejected: %w", err)
+	}
+	current.Store(cfg)
+	slog.Info("Configuration reloaded", "path", cfg.file)
+	for _, setting := range restartRequired(old, cfg) {
+		slog.Warn("Changed setting needs a restart to apply", "setting", setting)
+	}
+
+	var errs []error
+	for i, sub := range subscribers {
+		if err := sub.fn(old, cfg); err != nil {
+			slog.Error("Failed to apply the reloaded configuration", "subscriber", sub.name, "error", err)
+			errs = append(errs, fmt.Errorf("%s: %w", sub.name, err))
+			errs = append(errs, rollback(old, cfg, subscribers[:i])...)
+			return errors.Join(errs...)
+		}
+	}
+	return nil
+}
+
+// rollback makes old current again after a subscriber failed to apply cfg, and restores old in the
+// subscribers that already applied cfg, most recent first
+func rollback(old, cfg *Config, applied []subscriber) []error {
+	current.Store(old)
+	slog.Warn("Configuration reload rolled back", "path", old.file)
+
+	var errs []error
+	for i := len(applied) - 1; i >= 0; i-- {
+		sub := applied[i]
+		if err := sub.fn(cfg, old); err != nil {
+			slog.Error("Failed to restore the configuration", "subscriber", sub.name, "error", err)
+			errs = append(errs, fmt.Errorf("%s rollback: %w", sub.name, err))
+		}
+	}
+	return errs
+}
+
+// restartRequired lists the changed settings that are only read at startup
+func restartRequired(old, new *Config) []string {
+	var settings []string
+	if old.Server != new.Server {
+		settings = append(settings, "SERVER_*")
+	}
+	if old.Circulation.FineAccrualInterval != new.Circulation.FineAccrualInterval {
+		settings = append(settings, "FINE_ACCRUAL_INTERVAL")
+	}
+	if old.Auth.JWTAlgorithm != new.Auth.JWTAlgorithm || old.Auth.JWTSecret != new.Auth.JWTSecret ||
+		old.Auth.JWTKeyID != new.Auth.JWTKeyID || old.Auth.JWTPrivateKeyFile != new.Auth.JWTPrivateKeyFile ||
+		old.Auth.JWTPublicKeyFile != new.Auth.JWTPublicKeyFile || old.Auth.JWTJWKSFile != new.Auth.JWTJWKSFile {
+		settings = append(settings, "JWT keys")
+	}
+	if old.Shutdown != new.Shutdown {
+		settings = append(settings, "SHUTDOWN_*")
+	}
+	if old.Tracing != new.Tracing {
+		settings = append(settings, "TRACING_*")
+	}
+	if old.Log.FilePath != new.Log.FilePath {
+		settings = append(settings, "LOG_FILE_PATH")
+	}
+	return settings
+}
+
+// Retire closes a client replaced by a reload once the requests that may still be using it are done,
+// giving them as long as a graceful shutdown does (SHUTDOWN_HTTP_TIMEOUT)
+func Retire(name string, close func() error) {
+	time.AfterFunc(Get().Shutdown.HTTPTimeout, func() {
+		if err := close(); err != nil {
+			slog.Warn("Error closing replaced client", "client", name, "error", err)
+			return
+		}
+		slog.Info("Closed replaced client", "client", name)
+	})
+}
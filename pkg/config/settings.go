@@ -0,0 +1,670 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/viper"
+)
+
+// Config holds every setting of the service. It is loaded from app.env, overridden by environment
+// variables of the same name, and validated before use. Each field is tagged with its setting name.
+type Config struct {
+	Server      ServerConfig      `mapstructure:",squash"`
+	Postgres    PostgresConfig    `mapstructure:",squash"`
+	Migrations  MigrationsConfig  `mapstructure:",squash"`
+	Redis       RedisConfig       `mapstructure:",squash"`
+	Cache       CacheConfig       `mapstructure:",squash"`
+	Kafka       KafkaConfig       `mapstructure:",squash"`
+	Circulation CirculationConfig `mapstructure:",squash"`
+	Auth        AuthConfig        `mapstructure:",squash"`
+	RateLimit   RateLimitConfig   `mapstructure:",squash"`
+	Idempotency IdempotencyConfig `mapstructure:",squash"`
+	Health      HealthConfig      `mapstructure:",squash"`
+	Breaker     BreakerConfig     `mapstructure:",squash"`
+	Shutdown    ShutdownConfig    `mapstructure:",squash"`
+	Tracing     TracingConfig     `mapstructure:",squash"`
+	Log         LogConfig         `mapstructure:",squash"`
+	Features    FeaturesConfig    `mapstructure:",squash"`
+
+	file string
+}
+
+type ServerConfig struct {
+	Host string `mapstructure:"SERVER_HOST"`
+	Port int    `mapstructure:"SERVER_PORT"`
+	// TrustedProxies lists the IPs and CIDRs of the proxies whose X-Forwarded-For gives the client IP
+	TrustedProxies string `mapstructure:"SERVER_TRUSTED_PROXIES"`
+}
+
+// Address is the host:port the HTTP server listens on
+func (s ServerConfig) Address() string {
+	return fmt.Sprintf("%s:%d", s.Host, s.Port)
+}
+
+// Proxies are the entries of SERVER_TRUSTED_PROXIES, nil when no proxy is trusted
+func (s ServerConfig) Proxies() []string {
+	var proxies []string
+	for _, proxy := range strings.Split(s.TrustedProxies, ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			proxies = append(proxies, proxy)
+		}
+	}
+	return proxies
+}
+
+type PostgresConfig struct {
+	Database string `mapstructure:"POSTGRES_DB"`
+	User     string `mapstructure:"POSTGRES_USER"`
+	Password Secret `mapstructure:"POSTGRES_PASSWORD"`
+	Host     string `mapstructure:"POSTGRES_HOST"`
+	Port     int    `mapstructure:"POSTGRES_PORT"`
+}
+
+// URL is the connection string of Postgres, with the password replaced by [REDACTED] when redact is set
+func (p PostgresConfig) URL(redact bool) string {
+	password := p.Password.Reveal()
+	if redact {
+		password = p.Password.String()
+	}
+	u := url.URL{
+		Scheme:   "postgresql",
+		User:     url.UserPassword(p.User, password),
+		Host:     net.JoinHostPort(p.Host, strconv.Itoa(p.Port)),
+		Path:     "/" + p.Database,
+		RawQuery: "sslmode=disable",
+	}
+	if redact {
+		// Keep the marker readable instead of percent-encoded
+		return strings.Replace(u.String(), url.QueryEscape(redacted), redacted, 1)
+	}
+	return u.String()
+}
+
+type MigrationsConfig struct {
+	// OnStart applies the pending migrations when the server starts
+	OnStart bool `mapstructure:"MIGRATE_ON_START"`
+}
+
+// Topologies of Redis, set with REDIS_MODE
+const (
+	RedisModeStandalone = "standalone"
+	RedisModeSentinel   = "sentinel"
+	RedisModeCluster    = "cluster"
+)
+
+type RedisConfig struct {
+	// Mode is the topology: standalone at REDIS_HOST:REDIS_PORT, or sentinel or cluster at REDIS_ADDRS
+	Mode     string `mapstructure:"REDIS_MODE"`
+	Host     string `mapstructure:"REDIS_HOST"`
+	Port     int    `mapstructure:"REDIS_PORT"`
+	Password Secret `mapstructure:"REDIS_PASSWORD"`
+	DB       int    `mapstructure:"REDIS_DB"`
+	// Addrs lists the sentinels, or the cluster nodes to discover the cluster from, as host:port,host:port
+	Addrs            string `mapstructure:"REDIS_ADDRS"`
+	MasterName       string `mapstructure:"REDIS_MASTER_NAME"`
+	SentinelPassword Secret `mapstructure:"REDIS_SENTINEL_PASSWORD"`
+	// ExpiryBooks is the time to live of cached books in seconds, 0 keeps them until evicted
+	ExpiryBooks int `mapstructure:"REDIS_EXPIRY_BOOKS"`
+	// KeyPrefix namespaces every key and channel of the service, such as "staging:", so environments
+	// or services can share a Redis
+	KeyPrefix string `mapstructure:"REDIS_KEY_PREFIX"`
+}
+
+// Address is the host:port of Redis, or the list of REDIS_ADDRS for a sentinel or cluster
+func (r RedisConfig) Address() string {
+	if r.Mode == RedisModeStandalone {
+		return fmt.Sprintf("%s:%d", r.Host, r.Port)
+	}
+	return strings.Join(r.Addresses(), ",")
+}
+
+// Addresses are the addresses the Redis client connects to first
+func (r RedisConfig) Addresses() []string {
+	if r.Mode == RedisModeStandalone {
+		return []string{fmt.Sprintf("%s:%d", r.Host, r.Port)}
+	}
+	var addrs []string
+	for _, addr := range strings.Split(r.Addrs, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs
+}
+
+// CacheConfig holds the settings of the book cache, its warm-up and consistency check
+type CacheConfig struct {
+	WarmOnStart   bool `mapstructure:"CACHE_WARM_ON_START"`
+	WarmBatchSize int  `mapstructure:"CACHE_WARM_BATCH_SIZE"`
+	// WarmRowsPerSecond caps the rate books are read from Postgres, 0 doesn't limit it
+	WarmRowsPerSecond int `mapstructure:"CACHE_WARM_ROWS_PER_SECOND"`
+	// NotFoundTTLSeconds is how long a lookup of a missing book is remembered, 0 doesn't remember it
+	NotFoundTTLSeconds int `mapstructure:"CACHE_NOT_FOUND_TTL"`
+	// TTLJitterPercent lengthens the expiry of each book by a random part of REDIS_EXPIRY_BOOKS
+	TTLJitterPercent int `mapstructure:"CACHE_TTL_JITTER_PERCENT"`
+	// LoadLockTimeout is how long an instance waits for another one loading the same book
+	LoadLockTimeout time.Duration `mapstructure:"CACHE_LOAD_LOCK_TIMEOUT"`
+	// CheckInterval is how often a sample of the cached books is compared with Postgres, 0 disables it
+	CheckInterval   time.Duration `mapstructure:"CACHE_CHECK_INTERVAL"`
+	CheckSampleSize int           `mapstructure:"CACHE_CHECK_SAMPLE_SIZE"`
+	// Encoding of the cached books: json (RedisJSON), string, msgpack, or auto to use json when Redis
+	// has the RedisJSON module and string otherwise
+	Encoding string `mapstructure:"CACHE_ENCODING"`
+	// L1Size is the number of books kept in memory in front of Redis for L1TTL, 0 disables it
+	L1Size int           `mapstructure:"CACHE_L1_SIZE"`
+	L1TTL  time.Duration `mapstructure:"CACHE_L1_TTL"`
+}
+
+type KafkaConfig struct {
+	Host  string `mapstructure:"KAFKA_HOST"`
+	Port  int    `mapstructure:"KAFKA_PORT"`
+	Topic string `mapstructure:"KAFKA_TOPIC"`
+}
+
+// Brokers lists the Kafka brokers to bootstrap from
+func (k KafkaConfig) Brokers() []string {
+	return []string{fmt.Sprintf("%s:%d", k.Host, k.Port)}
+}
+
+// CirculationConfig holds the loan and fine rules, amounts are in minor units
+type CirculationConfig struct {
+	LoanPeriodDays       int           `mapstructure:"LOAN_PERIOD_DAYS"`
+	FinePerDay           int64         `mapstructure:"FINE_PER_DAY"`
+	FineMaxPerLoan       int64         `mapstructure:"FINE_MAX_PER_LOAN"`
+	LostItemFee          int64         `mapstructure:"LOST_ITEM_FEE"`
+	CheckoutBalanceLimit int64         `mapstructure:"CHECKOUT_BALANCE_LIMIT"`
+	FineAccrualInterval  time.Duration `mapstructure:"FINE_ACCRUAL_INTERVAL"`
+}
+
+type AuthConfig struct {
+	JWTAlgorithm          string        `mapstructure:"JWT_ALGORITHM"`
+	JWTSecret             Secret        `mapstructure:"JWT_SECRET"`
+	JWTKeyID              string        `mapstructure:"JWT_KEY_ID"`
+	JWTPrivateKeyFile     string        `mapstructure:"JWT_PRIVATE_KEY_FILE"`
+	JWTPublicKeyFile      string        `mapstructure:"JWT_PUBLIC_KEY_FILE"`
+	JWTJWKSFile           string        `mapstructure:"JWT_JWKS_FILE"`
+	JWTIssuer             string        `mapstructure:"JWT_ISSUER"`
+	JWTAudience           string        `mapstructure:"JWT_AUDIENCE"`
+	AccessTokenTTL        time.Duration `mapstructure:"JWT_ACCESS_TOKEN_TTL"`
+	RefreshTokenTTL       time.Duration `mapstructure:"JWT_REFRESH_TOKEN_TTL"`
+	AdminUsername         string        `mapstructure:"AUTH_ADMIN_USERNAME"`
+	AdminPassword         Secret        `mapstructure:"AUTH_ADMIN_PASSWORD"`
+	APIKeyExpiryDays      int           `mapstructure:"API_KEY_DEFAULT_EXPIRY_DAYS"`
+	APIKeyCacheTTLSeconds int           `mapstructure:"API_KEY_CACHE_TTL"`
+}
+
+// RateLimitConfig holds the limits as "requests/window", parsed by the ratelimit package
+type RateLimitConfig struct {
+	Enabled bool   `mapstructure:"RATE_LIMIT_ENABLED"`
+	Default string `mapstructure:"RATE_LIMIT_DEFAULT"`
+	IP      string `mapstructure:"RATE_LIMIT_IP"`
+	User    string `mapstructure:"RATE_LIMIT_USER"`
+	APIKey  string `mapstructure:"RATE_LIMIT_API_KEY"`
+	Routes  string `mapstructure:"RATE_LIMIT_ROUTES"`
+	// AuthFailures is the number of failed authentications per window after which a client IP is blocked
+	AuthFailures string `mapstructure:"RATE_LIMIT_AUTH_FAILURES"`
+}
+
+type IdempotencyConfig struct {
+	TTLSeconds     int `mapstructure:"IDEMPOTENCY_TTL"`
+	LockTTLSeconds int `mapstructure:"IDEMPOTENCY_LOCK_TTL"`
+	// MaxBodyBytes caps the body of a request with an Idempotency-Key, which is read whole to fingerprint it
+	MaxBodyBytes int64 `mapstructure:"IDEMPOTENCY_MAX_BODY_BYTES"`
+}
+
+type HealthConfig struct {
+	PostgresTimeout time.Duration `mapstructure:"HEALTH_POSTGRES_TIMEOUT"`
+	RedisTimeout    time.Duration `mapstructure:"HEALTH_REDIS_TIMEOUT"`
+	KafkaTimeout    time.Duration `mapstructure:"HEALTH_KAFKA_TIMEOUT"`
+}
+
+// BreakerConfig tunes the circuit breakers of Redis and Kafka, which the service can run without
+type BreakerConfig struct {
+	FailureThreshold int           `mapstructure:"BREAKER_FAILURE_THRESHOLD"`
+	ProbeInterval    time.Duration `mapstructure:"BREAKER_PROBE_INTERVAL"`
+}
+
+type ShutdownConfig struct {
+	Timeout        time.Duration `mapstructure:"SHUTDOWN_TIMEOUT"`
+	ReadinessDelay time.Duration `mapstructure:"SHUTDOWN_READINESS_DELAY"`
+	HTTPTimeout    time.Duration `mapstructure:"SHUTDOWN_HTTP_TIMEOUT"`
+	WorkersTimeout time.Duration `mapstructure:"SHUTDOWN_WORKERS_TIMEOUT"`
+	KafkaTimeout   time.Duration `mapstructure:"SHUTDOWN_KAFKA_TIMEOUT"`
+}
+
+type TracingConfig struct {
+	Exporter     string  `mapstructure:"TRACING_EXPORTER"`
+	OTLPEndpoint string  `mapstructure:"TRACING_OTLP_ENDPOINT"`
+	ServiceName  string  `mapstructure:"TRACING_SERVICE_NAME"`
+	SampleRatio  float64 `mapstructure:"TRACING_SAMPLE_RATIO"`
+}
+
+type LogConfig struct {
+	FilePath string `mapstructure:"LOG_FILE_PATH"`
+	Level    string `mapstructure:"LOG_LEVEL"`
+}
+
+// FeaturesConfig holds the feature flags, read on every use so a reload toggles them live
+type FeaturesConfig struct {
+	// BookCache serves books from Redis, when off every read goes to Postgres
+	BookCache bool `mapstructure:"FEATURE_BOOK_CACHE"`
+}
+
+// Secret is a setting that must not show up in logs. It prints and marshals as [REDACTED];
+// Reveal returns the value itself.
+type Secret string
+
+const redacted = "[REDACTED]"
+
+// Reveal returns the secret value
+func (s Secret) Reveal() string {
+	return string(s)
+}
+
+func (s Secret) String() string {
+	if s == "" {
+		return ""
+	}
+	return redacted
+}
+
+func (s Secret) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
+
+func (s Secret) LogValue() slog.Value {
+	return slog.StringValue(s.String())
+}
+
+// defaults applies to settings missing from both app.env and the environment
+var defaults = map[string]any{
+	"SERVER_HOST":                 "0.0.0.0",
+	"SERVER_PORT":                 9010,
+	"POSTGRES_PORT":               5432,
+	"MIGRATE_ON_START":            true,
+	"REDIS_MODE":                  "standalone",
+	"REDIS_PORT":                  6379,
+	"REDIS_EXPIRY_BOOKS":          3600,
+	"CACHE_WARM_BATCH_SIZE":       500,
+	"CACHE_WARM_ROWS_PER_SECOND":  5000,
+	"CACHE_NOT_FOUND_TTL":         30,
+	"CACHE_TTL_JITTER_PERCENT":    10,
+	"CACHE_LOAD_LOCK_TIMEOUT":     "3s",
+	"CACHE_CHECK_INTERVAL":        "5m",
+	"CACHE_CHECK_SAMPLE_SIZE":     100,
+	"CACHE_L1_SIZE":               10000,
+	"CACHE_ENCODING":              "auto",
+	"CACHE_L1_TTL":                "5s",
+	"KAFKA_TOPIC":                 "book_events",
+	"LOAN_PERIOD_DAYS":            14,
+	"JWT_ALGORITHM":               "HS256",
+	"JWT_ACCESS_TOKEN_TTL":        "15m",
+	"JWT_REFRESH_TOKEN_TTL":       "168h",
+	"API_KEY_DEFAULT_EXPIRY_DAYS": 90,
+	"API_KEY_CACHE_TTL":           300,
+	"RATE_LIMIT_DEFAULT":          "300/1m",
+	"RATE_LIMIT_AUTH_FAILURES":    "10/5m",
+	"IDEMPOTENCY_TTL":             86400,
+	"IDEMPOTENCY_LOCK_TTL":        30,
+	"IDEMPOTENCY_MAX_BODY_BYTES":  1048576,
+	"HEALTH_POSTGRES_TIMEOUT":     "2s",
+	"HEALTH_REDIS_TIMEOUT":        "2s",
+	"HEALTH_KAFKA_TIMEOUT":        "3s",
+	"BREAKER_FAILURE_THRESHOLD":   5,
+	"BREAKER_PROBE_INTERVAL":      "5s",
+	"SHUTDOWN_TIMEOUT":            "30s",
+	"SHUTDOWN_HTTP_TIMEOUT":       "20s",
+	"SHUTDOWN_WORKERS_TIMEOUT":    "10s",
+	"SHUTDOWN_KAFKA_TIMEOUT":      "5s",
+	"TRACING_EXPORTER":            "none",
+	"TRACING_SERVICE_NAME":        "books-management-system",
+	"TRACING_SAMPLE_RATIO":        1.0,
+	"LOG_FILE_PATH":               "app.log",
+	"LOG_LEVEL":                   "info",
+	"FEATURE_BOOK_CACHE":          true,
+}
+
+var current atomic.Pointer[Config]
+
+// Get returns the configuration loaded by Init
+func Get() *Config {
+	cfg := current.Load()
+	if cfg == nil {
+		panic("config.Get called before config.Init")
+	}
+	return cfg
+}
+
+// Init loads and validates the configuration and makes it available through Get.
+// See Load for where the settings come from.
+func Init(path string) (*Config, error) {
+	cfg, err := Load(path)
+	if err != nil {
+		return nil, err
+	}
+	current.Store(cfg)
+	return cfg, nil
+}
+
+// Load reads the configuration from the env file at path, or app.env found in the working directory or
+// next to the executable when path is empty. Environment variables override the file, and a secret such as
+// POSTGRES_PASSWORD can be read from the file named by POSTGRES_PASSWORD_FILE instead.
+func Load(path string) (*Config, error) {
+	reloadMu.Lock()
+	checks := validators
+	reloadMu.Unlock()
+	return loadWith(path, checks)
+}
+
+func loadWith(path string, checks []func(*Config) error) (*Config, error) {
+	v := viper.New()
+	for key, value := range defaults {
+		v.SetDefault(key, value)
+	}
+
+	file, err := configFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if file != "" {
+		v.SetConfigFile(file)
+		v.SetConfigType("env")
+		if err := v.ReadInConfig(); err != nil {
+			return nil, fmt.Errorf("error reading config file %s: %w", file, err)
+		}
+	}
+
+	// Bind every setting explicitly, AutomaticEnv alone doesn't reach Unmarshal for keys missing from the file
+	keys := settingKeys(reflect.TypeOf(Config{}))
+	for _, key := range keys {
+		if err := v.BindEnv(key); err != nil {
+			return nil, err
+		}
+	}
+	if err := readSecretFiles(v, reflect.TypeOf(Config{})); err != nil {
+		return nil, err
+	}
+
+	var cfg Config
+	if err := v.Unmarshal(&cfg); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+	cfg.Auth.JWTAlgorithm = strings.ToUpper(strings.TrimSpace(cfg.Auth.JWTAlgorithm))
+	cfg.Tracing.Exporter = strings.ToLower(strings.TrimSpace(cfg.Tracing.Exporter))
+	cfg.Log.Level = strings.ToLower(strings.TrimSpace(cfg.Log.Level))
+	cfg.Redis.Mode = strings.ToLower(strings.TrimSpace(cfg.Redis.Mode))
+	cfg.Cache.Encoding = strings.ToLower(strings.TrimSpace(cfg.Cache.Encoding))
+	errs := []error{cfg.Validate()}
+	for _, check := range checks {
+		errs = append(errs, check(&cfg))
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, fmt.Errorf("invalid configuration in %s:\n%w", describe(file), err)
+	}
+	cfg.file = file
+	return &cfg, nil
+}
+
+// Watch calls onChange with the path of the env file whenever it changes.
+// It does nothing when the configuration came from the environment only.
+func Watch(onChange func(file string)) {
+	cfg := current.Load()
+	if cfg == nil || cfg.file == "" {
+		return
+	}
+	w := viper.New()
+	w.SetConfigFile(cfg.file)
+	w.SetConfigType("env")
+	w.OnConfigChange(func(e fsnotify.Event) {
+		onChange(e.Name)
+	})
+	w.WatchConfig()
+}
+
+// File is the env file the configuration was read from, empty when it came from the environment only
+func (c *Config) File() string {
+	return c.file
+}
+
+func describe(file string) string {
+	if file == "" {
+		return "the environment"
+	}
+	return file
+}
+
+// configFile resolves the env file to read. An explicit path must exist, the default app.env may be missing
+// when everything is set through the environment.
+func configFile(path string) (string, error) {
+	if path != "" {
+		if _, err := os.Stat(path); err != nil {
+			return "", fmt.Errorf("config file %s: %w", path, err)
+		}
+		return path, nil
+	}
+
+	candidates := []string{"app.env"}
+	if exe, err := os.Executable(); err == nil {
+		candidates = append(candidates, filepath.Join(filepath.Dir(exe), "app.env"))
+	}
+	for _, candidate := range candidates {
+		if _, err := os.Stat(candidate); err == nil {
+			return filepath.Abs(candidate)
+		}
+	}
+	return "", nil
+}
+
+// settingKeys lists the setting names tagged on the fields of a config struct
+func settingKeys(t reflect.Type) []string {
+	var keys []string
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("mapstructure")
+		if field.Type.Kind() == reflect.Struct && strings.Contains(tag, "squash") {
+			keys = append(keys, settingKeys(field.Type)...)
+		} else if tag != "" {
+			keys = append(keys, tag)
+		}
+	}
+	return keys
+}
+
+// readSecretFiles sets each Secret setting from the file named by <SETTING>_FILE, when given,
+// so secrets can be mounted as files instead of being passed in the environment
+func readSecretFiles(v *viper.Viper, t reflect.Type) error {
+	secretType := reflect.TypeOf(Secret(""))
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("mapstructure")
+		if field.Type.Kind() == reflect.Struct && strings.Contains(tag, "squash") {
+			if err := readSecretFiles(v, field.Type); err != nil {
+				return err
+			}
+			continue
+		}
+		if field.Type != secretType {
+			continue
+		}
+
+		fileKey := tag + "_FILE"
+		if err := v.BindEnv(fileKey); err != nil {
+			return err
+		}
+		path := v.GetString(fileKey)
+		if path == "" {
+			continue
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read %s from %s: %w", tag, fileKey, err)
+		}
+		v.Set(tag, strings.TrimRight(string(data), "\r\n"))
+	}
+	return nil
+}
+
+// Validate checks every setting and reports all the problems at once
+func (c *Config) Validate() error {
+	var errs []error
+	require := func(key string, value string) {
+		if strings.TrimSpace(value) == "" {
+			errs = append(errs, fmt.Errorf("%s is required", key))
+		}
+	}
+	port := func(key string, value int) {
+		if value < 1 || value > 65535 {
+			errs = append(errs, fmt.Errorf("%s must be a port between 1 and 65535, got %d", key, value))
+		}
+	}
+	notNegative := func(key string, value int64) {
+		if value < 0 {
+			errs = append(errs, fmt.Errorf("%s must not be negative, got %d", key, value))
+		}
+	}
+	positive := func(key string, value int64) {
+		if value <= 0 {
+			errs = append(errs, fmt.Errorf("%s must be greater than zero, got %d", key, value))
+		}
+	}
+	positiveDuration := func(key string, value time.Duration) {
+		if value <= 0 {
+			errs = append(errs, fmt.Errorf("%s must be a positive duration such as 30s, got %s", key, value))
+		}
+	}
+	oneOf := func(key string, value string, allowed ...string) {
+		for _, a := range allowed {
+			if strings.EqualFold(value, a) {
+				return
+			}
+		}
+		errs = append(errs, fmt.Errorf("%s must be one of %s, got %q", key, strings.Join(allowed, ", "), value))
+	}
+
+	port("SERVER_PORT", c.Server.Port)
+	for _, proxy := range c.Server.Proxies() {
+		if _, _, err := net.ParseCIDR(proxy); err != nil && net.ParseIP(proxy) == nil {
+			errs = append(errs, fmt.Errorf("SERVER_TRUSTED_PROXIES must list IPs or CIDRs, got %q", proxy))
+		}
+	}
+
+	require("POSTGRES_DB", c.Postgres.Database)
+	require("POSTGRES_USER", c.Postgres.User)
+	require("POSTGRES_HOST", c.Postgres.Host)
+	port("POSTGRES_PORT", c.Postgres.Port)
+
+	oneOf("REDIS_MODE", c.Redis.Mode, RedisModeStandalone, RedisModeSentinel, RedisModeCluster)
+	switch c.Redis.Mode {
+	case RedisModeStandalone:
+		require("REDIS_HOST", c.Redis.Host)
+		port("REDIS_PORT", c.Redis.Port)
+	case RedisModeSentinel:
+		require("REDIS_ADDRS", strings.Join(c.Redis.Addresses(), ","))
+		require("REDIS_MASTER_NAME", c.Redis.MasterName)
+	case RedisModeCluster:
+		require("REDIS_ADDRS", strings.Join(c.Redis.Addresses(), ","))
+		if c.Redis.DB != 0 {
+			errs = append(errs, fmt.Errorf("REDIS_DB must be 0 in cluster mode, got %d", c.Redis.DB))
+		}
+	}
+	if c.Redis.Mode != RedisModeStandalone {
+		for _, addr := range c.Redis.Addresses() {
+			if _, _, err := net.SplitHostPort(addr); err != nil {
+				errs = append(errs, fmt.Errorf("REDIS_ADDRS must list host:port addresses, got %q", addr))
+			}
+		}
+	}
+	notNegative("REDIS_DB", int64(c.Redis.DB))
+	notNegative("REDIS_EXPIRY_BOOKS", int64(c.Redis.ExpiryBooks))
+	if strings.ContainsAny(c.Redis.KeyPrefix, " \t\r\n") {
+		errs = append(errs, fmt.Errorf("REDIS_KEY_PREFIX must not contain whitespace, got %q", c.Redis.KeyPrefix))
+	}
+	if c.Redis.Mode == RedisModeCluster && strings.Contains(c.Redis.KeyPrefix, "{") {
+		// A hash tag would put every key in the same slot, and so on a single shard
+		errs = append(errs, fmt.Errorf("REDIS_KEY_PREFIX must not contain { in cluster mode, got %q", c.Redis.KeyPrefix))
+	}
+	positive("CACHE_WARM_BATCH_SIZE", int64(c.Cache.WarmBatchSize))
+	notNegative("CACHE_WARM_ROWS_PER_SECOND", int64(c.Cache.WarmRowsPerSecond))
+	notNegative("CACHE_NOT_FOUND_TTL", int64(c.Cache.NotFoundTTLSeconds))
+	if c.Cache.TTLJitterPercent < 0 || c.Cache.TTLJitterPercent > 100 {
+		errs = append(errs, fmt.Errorf("CACHE_TTL_JITTER_PERCENT must be between 0 and 100, got %d", c.Cache.TTLJitterPercent))
+	}
+	positiveDuration("CACHE_LOAD_LOCK_TIMEOUT", c.Cache.LoadLockTimeout)
+	notNegative("CACHE_CHECK_INTERVAL", int64(c.Cache.CheckInterval))
+	positive("CACHE_CHECK_SAMPLE_SIZE", int64(c.Cache.CheckSampleSize))
+	notNegative("CACHE_L1_SIZE", int64(c.Cache.L1Size))
+	oneOf("CACHE_ENCODING", c.Cache.Encoding, "auto", "json", "string", "msgpack")
+	positiveDuration("CACHE_L1_TTL", c.Cache.L1TTL)
+
+	require("KAFKA_HOST", c.Kafka.Host)
+	port("KAFKA_PORT", c.Kafka.Port)
+	require("KAFKA_TOPIC", c.Kafka.Topic)
+
+	positive("LOAN_PERIOD_DAYS", int64(c.Circulation.LoanPeriodDays))
+	notNegative("FINE_PER_DAY", c.Circulation.FinePerDay)
+	notNegative("FINE_MAX_PER_LOAN", c.Circulation.FineMaxPerLoan)
+	notNegative("LOST_ITEM_FEE", c.Circulation.LostItemFee)
+	notNegative("CHECKOUT_BALANCE_LIMIT", c.Circulation.CheckoutBalanceLimit)
+	notNegative("FINE_ACCRUAL_INTERVAL", int64(c.Circulation.FineAccrualInterval))
+
+	oneOf("JWT_ALGORITHM", c.Auth.JWTAlgorithm, "HS256", "RS256")
+	if strings.EqualFold(c.Auth.JWTAlgorithm, "HS256") && c.Auth.JWTSecret == "" {
+		errs = append(errs, errors.New("JWT_SECRET is required for HS256"))
+	} else if strings.EqualFold(c.Auth.JWTAlgorithm, "HS256") && len(c.Auth.JWTSecret) < 32 {
+		errs = append(errs, errors.New("JWT_SECRET must be at least 32 bytes for HS256"))
+	}
+	// The public key is derived from the private key when only JWT_PRIVATE_KEY_FILE is set
+	if strings.EqualFold(c.Auth.JWTAlgorithm, "RS256") && c.Auth.JWTPrivateKeyFile == "" &&
+		c.Auth.JWTPublicKeyFile == "" && c.Auth.JWTJWKSFile == "" {
+		errs = append(errs, errors.New("JWT_PRIVATE_KEY_FILE, JWT_PUBLIC_KEY_FILE or JWT_JWKS_FILE is required for RS256"))
+	}
+	positiveDuration("JWT_ACCESS_TOKEN_TTL", c.Auth.AccessTokenTTL)
+	positiveDuration("JWT_REFRESH_TOKEN_TTL", c.Auth.RefreshTokenTTL)
+	if c.Auth.AdminPassword != "" && c.Auth.AdminUsername == "" {
+		errs = append(errs, errors.New("AUTH_ADMIN_USERNAME is required when AUTH_ADMIN_PASSWORD is set"))
+	}
+	notNegative("API_KEY_DEFAULT_EXPIRY_DAYS", int64(c.Auth.APIKeyExpiryDays))
+	positive("API_KEY_CACHE_TTL", int64(c.Auth.APIKeyCacheTTLSeconds))
+
+	require("RATE_LIMIT_DEFAULT", c.RateLimit.Default)
+	require("RATE_LIMIT_AUTH_FAILURES", c.RateLimit.AuthFailures)
+
+	positive("IDEMPOTENCY_TTL", int64(c.Idempotency.TTLSeconds))
+	positive("IDEMPOTENCY_LOCK_TTL", int64(c.Idempotency.LockTTLSeconds))
+	positive("IDEMPOTENCY_MAX_BODY_BYTES", c.Idempotency.MaxBodyBytes)
+
+	positiveDuration("HEALTH_POSTGRES_TIMEOUT", c.Health.PostgresTimeout)
+	positiveDuration("HEALTH_REDIS_TIMEOUT", c.Health.RedisTimeout)
+	positiveDuration("HEALTH_KAFKA_TIMEOUT", c.Health.KafkaTimeout)
+	positive("BREAKER_FAILURE_THRESHOLD", int64(c.Breaker.FailureThreshold))
+	positiveDuration("BREAKER_PROBE_INTERVAL", c.Breaker.ProbeInterval)
+
+	positiveDuration("SHUTDOWN_TIMEOUT", c.Shutdown.Timeout)
+	notNegative("SHUTDOWN_READINESS_DELAY", int64(c.Shutdown.ReadinessDelay))
+	positiveDuration("SHUTDOWN_HTTP_TIMEOUT", c.Shutdown.HTTPTimeout)
+	positiveDuration("SHUTDOWN_WORKERS_TIMEOUT", c.Shutdown.WorkersTimeout)
+	positiveDuration("SHUTDOWN_KAFKA_TIMEOUT", c.Shutdown.KafkaTimeout)
+
+	oneOf("TRACING_EXPORTER", c.Tracing.Exporter, "none", "otlp", "stdout")
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		errs = append(errs, fmt.Errorf("TRACING_SAMPLE_RATIO must be between 0 and 1, got %g", c.Tracing.SampleRatio))
+	}
+
+	require("LOG_FILE_PATH", c.Log.FilePath)
+	oneOf("LOG_LEVEL", c.Log.Level, "debug", "info", "warn", "error")
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,122 @@
+package controllers
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/arepala-uml/books-management-system/pkg/auth"
+	"github.com/arepala-uml/books-management-system/pkg/config"
+	"github.com/arepala-uml/books-management-system/pkg/models"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type APIKeyRequest struct {
+	Name          string   `json:"name" binding:"required,max=100"`
+	Scopes        []string `json:"scopes" binding:"required,min=1,dive,oneof=read write"`
+	ExpiresInDays int      `json:"expires_in_days" binding:"omitempty,gte=1,lte=3650"`
+}
+
+type APIKeyCreatedResponse struct {
+	APIKey models.APIKey `json:"api_key"`
+	Key    string        `json:"key"`
+}
+
+// @Summary Issue an API key
+// @Description Creates an API key for a machine client with read and/or write scopes. The key is returned only once and must be sent in the X-API-Key header. Keys expire after expires_in_days, or API_KEY_DEFAULT_EXPIRY_DAYS when not given. Requires permission users:admin.
+// @Accept json
+// @Produce json
+// @Param request body APIKeyRequest true "API key details"
+// @Param Idempotency-Key header string false "Key to retry the request safely, the first response is replayed for repeats"
+// @Success 201 {object} APIKeyCreatedResponse "API key issued"
+// @Failure 400 {object} ErrorResponse "Invalid input"
+// @Failure 401 {object} ErrorResponse "Missing or invalid credentials"
+// @Failure 403 {object} ErrorResponse "Missing permission users:admin"
+// @Failure 422 {object} ErrorResponse "Idempotency-Key reused with a different request"
+// @Failure 500 {object} ErrorResponse "Error issuing API key"
+// @Security BearerAuth
+// @Router /api-keys [post]
+func CreateAPIKey(c *gin.Context) {
+	var request APIKeyRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respondBindError(c, err)
+		return
+	}
+	principal, _ := auth.GetPrincipal(c)
+	slog.InfoContext(c.Request.Context(), "Got the request to issue an API key", "username", principal.Username,
+		"name", request.Name, "scopes", request.Scopes)
+
+	days := request.ExpiresInDays
+	if days == 0 {
+		days = config.Get().Auth.APIKeyExpiryDays
+	}
+	var expiresAt *time.Time
+	if days > 0 {
+		expiry := time.Now().AddDate(0, 0, days)
+		expiresAt = &expiry
+	}
+
+	key, plaintext, err := auth.IssueAPIKey(request.Name, request.Scopes, expiresAt, principal.UserID)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Error issuing API key", "name", request.Name, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error issuing API key"})
+		return
+	}
+	slog.InfoContext(c.Request.Context(), "Issued API key", "api_key_id", key.ID, "prefix", key.Prefix)
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusCreated, gin.H{
+		"api_key": key,
+		"key":     plaintext,
+	})
+}
+
+// @Summary List API keys
+// @Description Lists every API key with its scopes, expiry and last use. Requires permission users:admin.
+// @Success 200 {array} models.APIKey "API keys"
+// @Failure 401 {object} ErrorResponse "Missing or invalid credentials"
+// @Failure 403 {object} ErrorResponse "Missing permission users:admin"
+// @Failure 500 {object} ErrorResponse "Error fetching API keys"
+// @Security BearerAuth
+// @Router /api-keys [get]
+func GetAPIKeys(c *gin.Context) {
+	keys, err := auth.ListAPIKeys()
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Error fetching API keys", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching API keys"})
+		return
+	}
+	c.JSON(http.StatusOK, keys)
+}
+
+// @Summary Revoke an API key
+// @Description Revokes an API key so it stops being accepted immediately. Requires permission users:admin.
+// @Param id path int true "API key ID"
+// @Param Idempotency-Key header string false "Key to retry the request safely, the first response is replayed for repeats"
+// @Success 200 {object} models.APIKey "API key revoked"
+// @Failure 401 {object} ErrorResponse "Missing or invalid credentials"
+// @Failure 403 {object} ErrorResponse "Missing permission users:admin"
+// @Failure 404 {object} ErrorResponse "API key not found"
+// @Failure 422 {object} ErrorResponse "Idempotency-Key reused with a different request"
+// @Failure 500 {object} ErrorResponse "Error revoking API key"
+// @Security BearerAuth
+// @Router /api-keys/{id} [delete]
+func RevokeAPIKey(c *gin.Context) {
+	keyID, ok := paramID(c, "id")
+	if !ok {
+		return
+	}
+	slog.InfoContext(c.Request.Context(), "Got the request to revoke an API key", "api_key_id", keyID)
+
+	key, err := auth.RevokeAPIKey(keyID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "API key not found"})
+		return
+	} else if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Error revoking API key", "api_key_id", keyID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error revoking API key"})
+		return
+	}
+	c.JSON(http.StatusOK, key)
+}
@@ -0,0 +1,58 @@
+package controllers
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/arepala-uml/books-management-system/pkg/auth"
+	"github.com/gin-gonic/gin"
+)
+
+// TokenRequest is either a password grant for a local user or a refresh token grant
+type TokenRequest struct {
+	GrantType    string `json:"grant_type" binding:"required,oneof=password refresh_token"`
+	Username     string `json:"username" binding:"required_if=GrantType password"`
+	Password     string `json:"password" binding:"required_if=GrantType password"`
+	RefreshToken string `json:"refresh_token" binding:"required_if=GrantType refresh_token"`
+}
+
+// @Summary Issue an access token
+// @Description Exchanges the credentials of a local user (grant_type password) or a refresh token (grant_type refresh_token) for a new access and refresh token. Refresh tokens can be used only once.
+// @Accept json
+// @Produce json
+// @Param request body TokenRequest true "Token grant"
+// @Success 200 {object} auth.TokenPair "Issued tokens"
+// @Failure 400 {object} ErrorResponse "Invalid input"
+// @Failure 401 {object} ErrorResponse "Invalid credentials or refresh token"
+// @Failure 500 {object} ErrorResponse "Error issuing token"
+// @Router /auth/token [post]
+func IssueToken(c *gin.Context) {
+	var request TokenRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respondBindError(c, err)
+		return
+	}
+
+	var pair auth.TokenPair
+	var err error
+	if request.GrantType == "password" {
+		slog.InfoContext(c.Request.Context(), "Got the request to issue a token", "username", request.Username)
+		pair, err = auth.Login(request.Username, request.Password)
+	} else {
+		slog.InfoContext(c.Request.Context(), "Got the request to refresh a token")
+		pair, err = auth.Refresh(request.RefreshToken)
+	}
+
+	switch {
+	case errors.Is(err, auth.ErrInvalidCredentials), errors.Is(err, auth.ErrInvalidToken):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		slog.ErrorContext(c.Request.Context(), "Error issuing token", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error issuing token"})
+		return
+	}
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, pair)
+}
@@ -0,0 +1,46 @@
+package controllers
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/arepala-uml/books-management-system/pkg/cache"
+	"github.com/arepala-uml/books-management-system/pkg/lifecycle"
+	"github.com/gin-gonic/gin"
+)
+
+// @Summary Warm the book cache
+// @Description Starts caching every book from Postgres in the background, paced by CACHE_WARM_ROWS_PER_SECOND. Once done, the book list is served from the cache. Requires permission cache:admin.
+// @Produce json
+// @Success 202 {object} cache.WarmStatus "Warm-up started"
+// @Failure 401 {object} ErrorResponse "Missing or invalid credentials"
+// @Failure 403 {object} ErrorResponse "Missing permission cache:admin"
+// @Failure 409 {object} ErrorResponse "A warm-up is already running"
+// @Security BearerAuth
+// @Router /admin/cache/warm [post]
+func StartCacheWarm(app *lifecycle.Lifecycle) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// The warm-up outlives the request, it runs as a worker of the application so a graceful
+		// shutdown cancels it and waits for it
+		status, err := cache.StartWarm(app.Go)
+		if errors.Is(err, cache.ErrWarmRunning) {
+			c.JSON(http.StatusConflict, gin.H{"error": "A cache warm-up is already running"})
+			return
+		}
+		slog.InfoContext(c.Request.Context(), "Started a cache warm-up")
+		c.JSON(http.StatusAccepted, status)
+	}
+}
+
+// @Summary Get the progress of the cache warm-up
+// @Description Returns the progress of the running or last cache warm-up of this instance. Requires permission cache:admin.
+// @Produce json
+// @Success 200 {object} cache.WarmStatus "Warm-up progress"
+// @Failure 401 {object} ErrorResponse "Missing or invalid credentials"
+// @Failure 403 {object} ErrorResponse "Missing permission cache:admin"
+// @Security BearerAuth
+// @Router /admin/cache/warm [get]
+func GetCacheWarm(c *gin.Context) {
+	c.JSON(http.StatusOK, cache.WarmProgress())
+}
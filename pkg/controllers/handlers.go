@@ -1,21 +1,23 @@
 package controllers
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
+	"log/slog"
 	"strconv"
 
 	"github.com/arepala-uml/books-management-system/pkg/cache"
 	"github.com/arepala-uml/books-management-system/pkg/config"
 	"github.com/arepala-uml/books-management-system/pkg/kafka"
+	"github.com/arepala-uml/books-management-system/pkg/logging"
 	"github.com/arepala-uml/books-management-system/pkg/models"
-	"github.com/arepala-uml/books-management-system/pkg/utils"
-	"github.com/go-playground/validator/v10"
-	"github.com/labstack/gommon/log"
 
 	"net/http"
 
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type BookListResponse struct {
@@ -36,18 +38,24 @@ type SuccessResponse struct {
 }
 
 // @Summary Get all books with optional pagination
-// @Description Fetches all books, with pagination support using limit and offset query parameters
+// @Description Fetches all books, with pagination support using limit and offset query parameters and ordered by the sort query parameter. Requires permission books:read.
 // @Param limit query int false "Limit the number of books per page" default(10)
 // @Param offset query int false "Offset for pagination" default(0)
+// @Param sort query string false "Field to sort by, prefixed with - for a descending order" Enums(id, -id, year, -year, rating, -rating) default(id)
 // @Success 200 {object} BookListResponse "List of books"
 // @Failure 400 {object} ErrorResponse "Invalid query parameters"
+// @Failure 401 {object} ErrorResponse "Missing or invalid credentials"
+// @Failure 403 {object} ErrorResponse "Missing permission books:read"
 // @Failure 500 {object} ErrorResponse "Error fetching books"
+// @Security BearerAuth
+// @Security ApiKeyAuth
 // @Router /books [get]
 func GetBooks(c *gin.Context) {
+	ctx := c.Request.Context()
 	var books []models.Book
 	limit := 10
 	offset := 0
-	log.Info("Got the request to fetch all the books")
+	slog.InfoContext(ctx, "Got the request to fetch all the books")
 
 	if queryLimit := c.DefaultQuery("limit", "10"); queryLimit != "" {
 		if parsedLimit, err := strconv.Atoi(queryLimit); err == nil {
@@ -61,26 +69,36 @@ func GetBooks(c *gin.Context) {
 		}
 	}
 
+	sort, err := cache.ParseSort(c.DefaultQuery("sort", cache.SortID))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid query parameters", "details": []string{err.Error()}})
+		return
+	}
+
 	// Get books from Redis cache
-	log.Info("Checking in cache for the books data")
-	booksFromCache, err := cache.GetBooksFromCache()
-	if err == nil && booksFromCache != nil && len(booksFromCache) > 0 {
-		log.Info("Successfully fetched books data from the cache ")
-		c.JSON(http.StatusOK, booksFromCache)
+	slog.DebugContext(ctx, "Checking in cache for the books data")
+	booksFromCache, err := cache.GetBooksFromCache(ctx, sort, limit, offset)
+	if err == nil {
+		slog.InfoContext(ctx, "Successfully fetched books data from the cache", "count", len(booksFromCache))
+		c.JSON(http.StatusOK, gin.H{
+			"limit":  limit,
+			"offset": offset,
+			"books":  booksFromCache,
+		})
 		return
 	}
 
-	log.Info("Books data is missing in the cache and fetching from postgres")
+	slog.InfoContext(ctx, "Books data is missing in the cache and fetching from postgres")
 	// Otherwise, fetch from Postgres
-	err = config.DB.Limit(limit).Offset(offset).Find(&books).Error
+	err = config.GetDB().WithContext(ctx).Order(sort.OrderBy()).Limit(limit).Offset(offset).Find(&books).Error
 	if err != nil {
-		log.Infof("Books not found in postgres")
+		slog.ErrorContext(ctx, "Error fetching books from postgres", "error", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching books"})
 		return
 	}
-	log.Info("Successfully fetched the books data from postgres")
+	slog.InfoContext(ctx, "Successfully fetched the books data from postgres", "count", len(books))
 	// Store the books in cache
-	cache.StoreBooksInCache(books)
+	cache.StoreBooksInCache(ctx, books)
 	c.JSON(http.StatusOK, gin.H{
 		"limit":  limit,
 		"offset": offset,
@@ -89,104 +107,95 @@ func GetBooks(c *gin.Context) {
 }
 
 // @Summary Get details of a single book by ID
-// @Description Fetches the book data for a specific ID, first checking the cache, then the database
+// @Description Fetches the book data for a specific ID, first checking the cache, then the database. Requires permission books:read.
 // @Param id path int true "Book ID"
 // @Success 200 {object} models.Book "Book details"
+// @Failure 400 {object} ErrorResponse "Invalid id"
+// @Failure 401 {object} ErrorResponse "Missing or invalid credentials"
+// @Failure 403 {object} ErrorResponse "Missing permission books:read"
 // @Failure 404 {object} ErrorResponse "Book not found"
 // @Failure 500 {object} ErrorResponse "Error fetching book"
+// @Security BearerAuth
+// @Security ApiKeyAuth
 // @Router /books/{id} [get]
-
 func GetBook(c *gin.Context) {
-	id := c.Param("id")
-	log.Infof("Got the request to fectch details of book with id: %d", id)
-	var book models.Book
-
-	// Get book from Redis cache
-	log.Infof("Checking in cache for the book data with id:%d", id)
-	cachedBook, err := cache.GetBookFromCache(id)
-	if err == nil && cachedBook != nil {
-		log.Infof("Successfully fetched book data with id: %d from the cache ", id)
-		c.JSON(http.StatusOK, cachedBook)
+	ctx := c.Request.Context()
+	id, ok := paramID(c, "id")
+	if !ok {
 		return
 	}
-
-	// Otherwise, fetch from Postgres
-	log.Infof("Book data with id: %d is missing in the cache and fetching from postgres", id)
-	err = config.DB.First(&book, id).Error
-	if err != nil {
-		log.Infof("Book not found in postgres with id: %d", id)
+	slog.InfoContext(ctx, "Got the request to fetch details of a book")
+
+	// Get the book from the Redis cache, concurrent misses share a single read from Postgres
+	book, err := cache.LoadBook(ctx, strconv.Itoa(id), func(ctx context.Context) (*models.Book, error) {
+		slog.InfoContext(ctx, "Book data is missing in the cache and fetching from postgres")
+		var book models.Book
+		if err := config.GetDB().WithContext(ctx).First(&book, id).Error; err != nil {
+			return nil, err
+		}
+		return &book, nil
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		slog.InfoContext(ctx, "Book not found in postgres")
 		c.JSON(http.StatusNotFound, gin.H{"error": "Book not found"})
 		return
+	} else if err != nil {
+		slog.ErrorContext(ctx, "Error fetching book", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching book"})
+		return
 	}
-
-	log.Infof("Successfully fetched the book data with id: %d from postgres", id)
-	// Cache the book
-	cache.StoreBookInCache(book)
 	c.JSON(http.StatusOK, book)
 }
 
 // CreateBook handles the POST /books request
 // @Summary Create a new book
-// @Description Adds a new book to the system
+// @Description Adds a new book to the system. Requires permission books:write.
 // @Accept json
 // @Produce json
 // @Param book body models.Book true "Book details"
+// @Param Idempotency-Key header string false "Key to retry the request safely, the first response is replayed for repeats"
 // @Success 201 {object} SuccessResponse "Book created successfully"
 // @Failure 400 {object} ErrorResponse "Invalid input"
+// @Failure 401 {object} ErrorResponse "Missing or invalid credentials"
+// @Failure 403 {object} ErrorResponse "Missing permission books:write"
+// @Failure 422 {object} ErrorResponse "Idempotency-Key reused with a different request"
 // @Failure 500 {object} ErrorResponse "Error creating book"
+// @Security BearerAuth
+// @Security ApiKeyAuth
 // @Router /books [post]
 func CreateBook(c *gin.Context) {
+	ctx := c.Request.Context()
 	var book models.Book
-	log.Info("Got the request to create a new book")
+	slog.InfoContext(ctx, "Got the request to create a new book")
 	if err := c.ShouldBindJSON(&book); err != nil {
-		if jsonErr, ok := err.(*json.UnmarshalTypeError); ok {
-			// Handle type mismatch
-			errors := make(map[string]interface{})
-			errorMessage := fmt.Sprintf("Invalid type for field '%s', expected %s", jsonErr.Field, jsonErr.Type)
-			detailsMessage := fmt.Sprintf("Field '%s' should be of type '%s', but received '%s'", jsonErr.Field, jsonErr.Type, jsonErr.Value)
-			errors["error"] = errorMessage
-			errors["details"] = detailsMessage
-			log.Errorf("Error in the request body: %v", errors)
-			c.JSON(http.StatusBadRequest, gin.H{
-				"error":   errorMessage,
-				"details": detailsMessage,
-			})
-			return
-		}
-
-		// Handle validation errors
-		var validationErrors []string
-		if validationErrs, ok := err.(validator.ValidationErrors); ok {
-			for _, validationErr := range validationErrs {
-				validationErrors = append(validationErrors, utils.FormatErrorMessage(validationErr))
-			}
-		}
-		log.Errorf("Errors in validating the request body for creating book: %v and invalid input: %v", validationErrors, book)
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error":   "Invalid input",
-			"details": validationErrors,
-		})
+		respondBindError(c, err)
 		return
 	}
 
+	// Ratings are maintained from reviews and can't be set by clients
+	book.AverageRating = 0
+	book.RatingCount = 0
+
 	// Save to Postgres
-	err := config.DB.Create(&book).Error
+	err := config.GetDB().WithContext(ctx).Create(&book).Error
 	if err != nil {
-		log.Errorf("Error in creating the book: %v", err)
+		slog.ErrorContext(ctx, "Error in creating the book", "error", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error creating book"})
 		return
 	}
-	log.Infof("Successfully added the book with id: %d details to postgres", book.ID)
+	ctx = logging.With(ctx, "book_id", book.ID)
+	slog.InfoContext(ctx, "Successfully added the book to postgres")
 
 	// Publish the event to Kafka (book created)
 	event := fmt.Sprintf("Book created: %s by %s", book.Title, book.Author)
-	if err := kafka.PublishEvent("book_events", []byte(event)); err != nil {
-		log.Errorf("Failed to publish event to Kafka: %v", err)
+	if err := kafka.PublishEvent(ctx, "book_events", []byte(event)); err != nil {
+		slog.ErrorContext(ctx, "Failed to publish event to Kafka", "topic", "book_events", "error", err)
+	} else {
+		slog.InfoContext(ctx, "Successfully published an event about creating the book", "topic", "book_events")
 	}
-	log.Infof("Successfully published an event to the kafka topic book_events about creating book with id:%d", book.ID)
 
 	//Save to cache
-	cache.StoreBookInCache(book)
+	cache.StoreBookInCache(ctx, book)
 	c.JSON(http.StatusCreated, gin.H{
 		"message": "Book created successfully",
 		"book":    book,
@@ -194,75 +203,68 @@ func CreateBook(c *gin.Context) {
 }
 
 // @Summary Update an existing book
-// @Description Updates the details of an existing book by ID
+// @Description Updates the details of an existing book by ID. Requires permission books:write.
 // @Param id path int true "Book ID"
 // @Param book body models.Book true "Updated book details"
+// @Param Idempotency-Key header string false "Key to retry the request safely, the first response is replayed for repeats"
 // @Success 200 {object} SuccessResponse "Book updated successfully"
 // @Failure 400 {object} ErrorResponse "Invalid input"
+// @Failure 401 {object} ErrorResponse "Missing or invalid credentials"
+// @Failure 403 {object} ErrorResponse "Missing permission books:write"
 // @Failure 404 {object} ErrorResponse "Book not found"
+// @Failure 422 {object} ErrorResponse "Idempotency-Key reused with a different request"
 // @Failure 500 {object} ErrorResponse "Error updating book"
+// @Security BearerAuth
+// @Security ApiKeyAuth
 // @Router /books/{id} [put]
-
 func UpdateBook(c *gin.Context) {
-	id := c.Param("id")
-	log.Infof("Got the request to update book with id: %d", id)
+	ctx := c.Request.Context()
+	id, ok := paramID(c, "id")
+	if !ok {
+		return
+	}
+	slog.InfoContext(ctx, "Got the request to update a book")
 	var book models.Book
 	if err := c.ShouldBindJSON(&book); err != nil {
-		if jsonErr, ok := err.(*json.UnmarshalTypeError); ok {
-			errors := make(map[string]interface{})
-			errorMessage := fmt.Sprintf("Invalid type for field '%s', expected %s", jsonErr.Field, jsonErr.Type)
-			detailsMessage := fmt.Sprintf("Field '%s' should be of type '%s', but received '%s'", jsonErr.Field, jsonErr.Type, jsonErr.Value)
-			errors["error"] = errorMessage
-			errors["details"] = detailsMessage
-			log.Errorf("Error in updating the book due to request body: %v", id, errors)
-			c.JSON(http.StatusBadRequest, gin.H{
-				"error":   errorMessage,
-				"details": detailsMessage,
-			})
-			return
-		}
-
-		// Handle validation errors
-		var validationErrors []string
-		if validationErrs, ok := err.(validator.ValidationErrors); ok {
-			for _, validationErr := range validationErrs {
-				validationErrors = append(validationErrors, utils.FormatErrorMessage(validationErr))
-			}
-		}
-		log.Errorf("Errors in validating the request body for updating book: %v and invalid input: %v", validationErrors, book)
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error":   "Invalid input",
-			"details": validationErrors,
-		})
+		respondBindError(c, err)
 		return
 	}
 
-	var existingBook models.Book
-	if err := config.DB.First(&existingBook, id).Error; err != nil {
-		log.Errorf("Failed to find book with id: %d", id)
+	// Update in Postgres and read the row back as stored, since Updates skips zero values and the
+	// ratings are kept from the existing row
+	var stored models.Book
+	err := config.GetDB().WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&stored, id).Error; err != nil {
+			return err
+		}
+		book.ID = stored.ID
+		if err := tx.Model(&stored).Omit("average_rating", "rating_count").Updates(book).Error; err != nil {
+			return err
+		}
+		return tx.First(&stored, stored.ID).Error
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		slog.ErrorContext(ctx, "Failed to find book", "error", err)
 		c.JSON(http.StatusNotFound, gin.H{"error": "Book not found"})
 		return
-	}
-
-	// Update in Postgres
-	book.ID = existingBook.ID
-	err := config.DB.Model(&book).Where("id = ?", id).Updates(book).Error
-	if err != nil {
-		log.Errorf("Failed to updated the book with id: %d", id)
+	} else if err != nil {
+		slog.ErrorContext(ctx, "Failed to update the book", "error", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error updating book"})
 		return
 	}
-	log.Infof("Successfully updated the book with id: %d in postgres", id)
+	book = stored
+	slog.InfoContext(ctx, "Successfully updated the book in postgres")
 
 	// Publish the event to Kafka (book updated)
 	event := fmt.Sprintf("Book updated: %s by %s", book.Title, book.Author)
-	if err := kafka.PublishEvent("book_events", []byte(event)); err != nil {
-		log.Errorf("Failed to publish event to Kafka: %v", err)
+	if err := kafka.PublishEvent(ctx, "book_events", []byte(event)); err != nil {
+		slog.ErrorContext(ctx, "Failed to publish event to Kafka", "topic", "book_events", "error", err)
+	} else {
+		slog.InfoContext(ctx, "Successfully published an event about updating the book", "topic", "book_events")
 	}
-	log.Infof("Successfully published an event to the kafka topic book_events about updaing book with id :%d", id)
 
-	// Cache the updated book
-	cache.StoreBookInCache(book)
+	// Cache the book as stored, not as requested
+	cache.StoreBookInCache(ctx, book)
 
 	c.JSON(http.StatusOK, gin.H{
 		"message": "Book updated successfully",
@@ -271,43 +273,55 @@ func UpdateBook(c *gin.Context) {
 }
 
 // @Summary Delete a book by ID
-// @Description Deletes a specific book from the system by its ID
+// @Description Deletes a specific book from the system by its ID. Requires permission books:delete.
 // @Param id path int true "Book ID"
+// @Param Idempotency-Key header string false "Key to retry the request safely, the first response is replayed for repeats"
 // @Success 200 {object} SuccessResponse "Book deleted successfully"
+// @Failure 400 {object} ErrorResponse "Invalid id"
+// @Failure 401 {object} ErrorResponse "Missing or invalid credentials"
+// @Failure 403 {object} ErrorResponse "Missing permission books:delete"
 // @Failure 404 {object} ErrorResponse "Book not found"
+// @Failure 422 {object} ErrorResponse "Idempotency-Key reused with a different request"
 // @Failure 500 {object} ErrorResponse "Error deleting book"
+// @Security BearerAuth
+// @Security ApiKeyAuth
 // @Router /books/{id} [delete]
 func DeleteBook(c *gin.Context) {
-	id := c.Param("id")
-	log.Infof("Got the request to delete book with id: %d", id)
+	ctx := c.Request.Context()
+	id, ok := paramID(c, "id")
+	if !ok {
+		return
+	}
+	slog.InfoContext(ctx, "Got the request to delete a book")
 
 	// Delete from Postgres
 	var existingBook models.Book
-	if err := config.DB.First(&existingBook, id).Error; err != nil {
+	if err := config.GetDB().WithContext(ctx).First(&existingBook, id).Error; err != nil {
 		// If the book is not found, return a 404 error
-		log.Errorf("Book with id %d not found", id)
+		slog.ErrorContext(ctx, "Book not found", "error", err)
 		c.JSON(http.StatusNotFound, gin.H{"error": "Book not found"})
 		return
 	}
 
 	// Delete the book from the database
-	err := config.DB.Delete(&models.Book{}, id).Error
+	err := config.GetDB().WithContext(ctx).Delete(&models.Book{}, id).Error
 	if err != nil {
-		log.Errorf("Error deleting the book with id: %d", id)
+		slog.ErrorContext(ctx, "Error deleting the book", "error", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error deleting book"})
 		return
 	}
-	log.Infof("Successfully deleted the book with id: %d from postgres", id)
+	slog.InfoContext(ctx, "Successfully deleted the book from postgres")
 
 	// Publish the event to Kafka (book deleted)
-	event := fmt.Sprintf("Book deleted with id: %s", id)
-	if err := kafka.PublishEvent("book_events", []byte(event)); err != nil {
-		log.Errorf("Failed to publish event to Kafka: %v", err)
+	event := fmt.Sprintf("Book deleted with id: %d", id)
+	if err := kafka.PublishEvent(ctx, "book_events", []byte(event)); err != nil {
+		slog.ErrorContext(ctx, "Failed to publish event to Kafka", "topic", "book_events", "error", err)
+	} else {
+		slog.InfoContext(ctx, "Successfully published an event about deleting the book", "topic", "book_events")
 	}
-	log.Infof("Successfully published an event to the kafka topic book_events about deleting book with id :%d", id)
 
 	// Remove from cache
-	cache.DeleteBookFromCache(id)
+	cache.DeleteBookFromCache(ctx, strconv.Itoa(id))
 
 	c.JSON(http.StatusOK, gin.H{"message": "Book deleted"})
 }
@@ -0,0 +1,78 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// serve sends a request to handler mounted on the route and returns the response
+func serve(t *testing.T, method, route, target, body string, handler gin.HandlerFunc) *httptest.ResponseRecorder {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Handle(method, route, handler)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(method, target, strings.NewReader(body)))
+	return w
+}
+
+func TestBookBindErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		method  string
+		route   string
+		target  string
+		body    string
+		handler gin.HandlerFunc
+	}{
+		{"create with a wrong type", http.MethodPost, "/books", "/books", `{"title": "Dune", "year": "1965"}`, CreateBook},
+		{"create without a title", http.MethodPost, "/books", "/books", `{"author": "Frank Herbert"}`, CreateBook},
+		{"update with a wrong type", http.MethodPut, "/books/:id", "/books/1", `{"year": "1965"}`, UpdateBook},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(t, tt.method, tt.route, tt.target, tt.body, tt.handler)
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("status = %d, want %d", w.Code, http.StatusBadRequest)
+			}
+			var response struct {
+				Error   string   `json:"error"`
+				Details []string `json:"details"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+				t.Fatalf("response %s isn't an error with a list of details: %v", w.Body, err)
+			}
+			if response.Error == "" || len(response.Details) == 0 {
+				t.Errorf("response = %s, want an error with details", w.Body)
+			}
+		})
+	}
+}
+
+// TestBookInvalidID checks a book id that isn't a positive integer is refused before the cache or the database
+// are used, neither of which is set up here
+func TestBookInvalidID(t *testing.T) {
+	tests := []struct {
+		method  string
+		target  string
+		body    string
+		handler gin.HandlerFunc
+	}{
+		{http.MethodGet, "/books/abc", "", GetBook},
+		{http.MethodGet, "/books/0", "", GetBook},
+		{http.MethodGet, "/books/1%3B1", "", GetBook},
+		{http.MethodPut, "/books/abc", `{"title": "Dune"}`, UpdateBook},
+		{http.MethodDelete, "/books/-1", "", DeleteBook},
+	}
+	for _, tt := range tests {
+		w := serve(t, tt.method, "/books/:id", tt.target, tt.body, tt.handler)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s %s status = %d, want %d", tt.method, tt.target, w.Code, http.StatusBadRequest)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/arepala-uml/books-management-system/pkg/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+)
+
+// respondBindError writes a 400 response for a request body that failed to bind or validate
+func respondBindError(c *gin.Context, err error) {
+	if jsonErr, ok := err.(*json.UnmarshalTypeError); ok {
+		errorMessage := fmt.Sprintf("Invalid type for field '%s', expected %s", jsonErr.Field, jsonErr.Type)
+		detailsMessage := fmt.Sprintf("Field '%s' should be of type '%s', but received '%s'", jsonErr.Field, jsonErr.Type, jsonErr.Value)
+		slog.ErrorContext(c.Request.Context(), "Error in the request body", "error", errorMessage, "details", detailsMessage)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   errorMessage,
+			"details": []string{detailsMessage},
+		})
+		return
+	}
+
+	var validationErrors []string
+	if validationErrs, ok := err.(validator.ValidationErrors); ok {
+		for _, validationErr := range validationErrs {
+			validationErrors = append(validationErrors, utils.FormatErrorMessage(validationErr))
+		}
+	}
+	slog.ErrorContext(c.Request.Context(), "Errors in validating the request body", "details", validationErrors)
+	c.JSON(http.StatusBadRequest, gin.H{
+		"error":   "Invalid input",
+		"details": validationErrors,
+	})
+}
+
+// paramID parses a numeric path parameter, writing a 400 response when it is not a valid id
+func paramID(c *gin.Context, name string) (int, bool) {
+	id, err := strconv.Atoi(c.Param(name))
+	if err != nil || id <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid %s", name)})
+		return 0, false
+	}
+	return id, true
+}
+
+// pagination reads the limit and offset query parameters
+func pagination(c *gin.Context) (int, int) {
+	limit := 10
+	offset := 0
+	if parsedLimit, err := strconv.Atoi(c.DefaultQuery("limit", "10")); err == nil && parsedLimit > 0 {
+		limit = parsedLimit
+	}
+	if parsedOffset, err := strconv.Atoi(c.DefaultQuery("offset", "0")); err == nil && parsedOffset >= 0 {
+		offset = parsedOffset
+	}
+	return limit, offset
+}
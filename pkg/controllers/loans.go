@@ -0,0 +1,131 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/arepala-uml/books-management-system/pkg/config"
+	"github.com/arepala-uml/books-management-system/pkg/ledger"
+	"github.com/arepala-uml/books-management-system/pkg/logging"
+	"github.com/arepala-uml/books-management-system/pkg/models"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type CheckoutRequest struct {
+	BookID   int `json:"book_id" binding:"required"`
+	MemberID int `json:"member_id" binding:"required"`
+}
+
+// @Summary Check out a book to a member
+// @Description Creates a loan due after LOAN_PERIOD_DAYS. Checkouts are refused while the member owes more than CHECKOUT_BALANCE_LIMIT. Requires permission loans:write.
+// @Accept json
+// @Produce json
+// @Param loan body CheckoutRequest true "Book and member"
+// @Param Idempotency-Key header string false "Key to retry the request safely, the first response is replayed for repeats"
+// @Success 201 {object} models.Loan "Book checked out"
+// @Failure 400 {object} ErrorResponse "Invalid input"
+// @Failure 401 {object} ErrorResponse "Missing or invalid credentials"
+// @Failure 403 {object} ErrorResponse "Missing permission loans:write or outstanding balance exceeds the checkout limit"
+// @Failure 404 {object} ErrorResponse "Book or member not found"
+// @Failure 409 {object} ErrorResponse "Book is already checked out"
+// @Failure 422 {object} ErrorResponse "Idempotency-Key reused with a different request"
+// @Failure 500 {object} ErrorResponse "Error checking out book"
+// @Security BearerAuth
+// @Security ApiKeyAuth
+// @Router /loans [post]
+func CheckoutBook(c *gin.Context) {
+	var request CheckoutRequest
+	slog.InfoContext(c.Request.Context(), "Got the request to check out a book")
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respondBindError(c, err)
+		return
+	}
+
+	ctx := logging.With(c.Request.Context(), "book_id", request.BookID)
+	loan, err := ledger.Checkout(ctx, config.GetDB(), request.BookID, request.MemberID)
+	switch {
+	case errors.Is(err, ledger.ErrBookNotFound):
+		slog.InfoContext(ctx, "Book not found")
+		c.JSON(http.StatusNotFound, gin.H{"error": "Book not found"})
+		return
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Member not found"})
+		return
+	case errors.Is(err, ledger.ErrBalanceTooHigh):
+		slog.InfoContext(ctx, "Checkout refused", "member_id", request.MemberID, "reason", err)
+		c.JSON(http.StatusForbidden, gin.H{"error": "Checkout blocked", "details": []string{err.Error()}})
+		return
+	case errors.Is(err, ledger.ErrBookUnavailable):
+		c.JSON(http.StatusConflict, gin.H{"error": "Book is already checked out"})
+		return
+	case err != nil:
+		slog.ErrorContext(ctx, "Error checking out book", "member_id", request.MemberID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking out book"})
+		return
+	}
+	slog.InfoContext(ctx, "Successfully checked out book", "member_id", loan.MemberID, "loan_id", loan.ID)
+	c.JSON(http.StatusCreated, loan)
+}
+
+// @Summary Return a borrowed book
+// @Description Closes a loan and charges the overdue fine still owed for it. Requires permission loans:write.
+// @Param id path int true "Loan ID"
+// @Param Idempotency-Key header string false "Key to retry the request safely, the first response is replayed for repeats"
+// @Success 200 {object} models.Loan "Book returned"
+// @Failure 401 {object} ErrorResponse "Missing or invalid credentials"
+// @Failure 403 {object} ErrorResponse "Missing permission loans:write"
+// @Failure 404 {object} ErrorResponse "Loan not found"
+// @Failure 409 {object} ErrorResponse "Loan is already closed"
+// @Failure 422 {object} ErrorResponse "Idempotency-Key reused with a different request"
+// @Failure 500 {object} ErrorResponse "Error returning book"
+// @Security BearerAuth
+// @Security ApiKeyAuth
+// @Router /loans/{id}/return [post]
+func ReturnLoan(c *gin.Context) {
+	closeLoan(c, "return", ledger.Return)
+}
+
+// @Summary Report a borrowed book as lost
+// @Description Closes a loan as lost and charges LOST_ITEM_FEE plus the overdue fine accrued so far. Requires permission loans:write.
+// @Param id path int true "Loan ID"
+// @Param Idempotency-Key header string false "Key to retry the request safely, the first response is replayed for repeats"
+// @Success 200 {object} models.Loan "Book reported lost"
+// @Failure 401 {object} ErrorResponse "Missing or invalid credentials"
+// @Failure 403 {object} ErrorResponse "Missing permission loans:write"
+// @Failure 404 {object} ErrorResponse "Loan not found"
+// @Failure 409 {object} ErrorResponse "Loan is already closed"
+// @Failure 422 {object} ErrorResponse "Idempotency-Key reused with a different request"
+// @Failure 500 {object} ErrorResponse "Error reporting book lost"
+// @Security BearerAuth
+// @Security ApiKeyAuth
+// @Router /loans/{id}/lost [post]
+func MarkLoanLost(c *gin.Context) {
+	closeLoan(c, "report lost", ledger.MarkLost)
+}
+
+func closeLoan(c *gin.Context, action string, close func(context.Context, *gorm.DB, int) (models.Loan, error)) {
+	loanID, ok := paramID(c, "id")
+	if !ok {
+		return
+	}
+	slog.InfoContext(c.Request.Context(), "Got the request to close a loan", "action", action, "loan_id", loanID)
+
+	loan, err := close(c.Request.Context(), config.GetDB(), loanID)
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Loan not found"})
+		return
+	case errors.Is(err, ledger.ErrLoanClosed):
+		c.JSON(http.StatusConflict, gin.H{"error": "Loan is already closed"})
+		return
+	case err != nil:
+		slog.ErrorContext(c.Request.Context(), "Error closing loan", "action", action, "loan_id", loanID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error trying to " + action + " book"})
+		return
+	}
+	slog.InfoContext(c.Request.Context(), "Successfully closed loan", "action", action, "loan_id", loanID, "book_id", loan.BookID)
+	c.JSON(http.StatusOK, loan)
+}
@@ -0,0 +1,229 @@
+package controllers
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/arepala-uml/books-management-system/pkg/config"
+	"github.com/arepala-uml/books-management-system/pkg/ledger"
+	"github.com/arepala-uml/books-management-system/pkg/models"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// CreditRequest is the body of a payment or waiver, with the amount in minor units
+type CreditRequest struct {
+	Amount      int64  `json:"amount" binding:"required,gte=1"`
+	Description string `json:"description"`
+}
+
+type BalanceResponse struct {
+	MemberID int   `json:"member_id"`
+	Balance  int64 `json:"balance"`
+}
+
+type StatementResponse struct {
+	MemberID int                  `json:"member_id"`
+	Balance  int64                `json:"balance"`
+	Limit    int                  `json:"limit"`
+	Offset   int                  `json:"offset"`
+	Entries  []models.LedgerEntry `json:"entries"`
+}
+
+// @Summary Register a new member
+// @Description Adds a new library member who can borrow books, optionally linked to the user account posting reviews as the member. Requires permission members:write.
+// @Accept json
+// @Produce json
+// @Param member body models.Member true "Member details"
+// @Param Idempotency-Key header string false "Key to retry the request safely, the first response is replayed for repeats"
+// @Success 201 {object} models.Member "Member created successfully"
+// @Failure 400 {object} ErrorResponse "Invalid input"
+// @Failure 401 {object} ErrorResponse "Missing or invalid credentials"
+// @Failure 403 {object} ErrorResponse "Missing permission members:write"
+// @Failure 409 {object} ErrorResponse "Email or user already belongs to a member"
+// @Failure 422 {object} ErrorResponse "Idempotency-Key reused with a different request"
+// @Failure 500 {object} ErrorResponse "Error creating member"
+// @Security BearerAuth
+// @Security ApiKeyAuth
+// @Router /members [post]
+func CreateMember(c *gin.Context) {
+	var member models.Member
+	slog.InfoContext(c.Request.Context(), "Got the request to create a new member")
+	if err := c.ShouldBindJSON(&member); err != nil {
+		respondBindError(c, err)
+		return
+	}
+
+	member.ID = 0
+	if member.UserID != nil {
+		if err := config.GetDB().WithContext(c.Request.Context()).First(&models.User{}, *member.UserID).Error; err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input", "details": []string{"user_id is not an existing user"}})
+			return
+		}
+	}
+	err := config.GetDB().WithContext(c.Request.Context()).Create(&member).Error
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Email or user already belongs to a member"})
+		return
+	} else if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Error in creating the member", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error creating member"})
+		return
+	}
+	slog.InfoContext(c.Request.Context(), "Successfully added the member to postgres", "member_id", member.ID)
+	c.JSON(http.StatusCreated, member)
+}
+
+// findMember writes a 404 response and returns false when the member does not exist
+func findMember(c *gin.Context, memberID int) bool {
+	var member models.Member
+	if err := config.GetDB().WithContext(c.Request.Context()).First(&member, memberID).Error; err != nil {
+		slog.ErrorContext(c.Request.Context(), "Member not found", "member_id", memberID, "error", err)
+		c.JSON(http.StatusNotFound, gin.H{"error": "Member not found"})
+		return false
+	}
+	return true
+}
+
+// @Summary Get the account balance of a member
+// @Description Returns the outstanding balance of a member in minor units (e.g. cents). Requires permission members:read.
+// @Param id path int true "Member ID"
+// @Success 200 {object} BalanceResponse "Member balance"
+// @Failure 401 {object} ErrorResponse "Missing or invalid credentials"
+// @Failure 403 {object} ErrorResponse "Missing permission members:read"
+// @Failure 404 {object} ErrorResponse "Member not found"
+// @Failure 500 {object} ErrorResponse "Error fetching balance"
+// @Security BearerAuth
+// @Security ApiKeyAuth
+// @Router /members/{id}/balance [get]
+func GetMemberBalance(c *gin.Context) {
+	memberID, ok := paramID(c, "id")
+	if !ok || !findMember(c, memberID) {
+		return
+	}
+
+	balance, err := ledger.Balance(config.GetDB().WithContext(c.Request.Context()), memberID)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Error fetching balance for member", "member_id", memberID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching balance"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"member_id": memberID,
+		"balance":   balance,
+	})
+}
+
+// @Summary Get the account statement of a member
+// @Description Lists the charges and credits on a member account in chronological order, with pagination support. Requires permission members:read.
+// @Param id path int true "Member ID"
+// @Param limit query int false "Limit the number of entries per page" default(10)
+// @Param offset query int false "Offset for pagination" default(0)
+// @Success 200 {object} StatementResponse "Member statement"
+// @Failure 401 {object} ErrorResponse "Missing or invalid credentials"
+// @Failure 403 {object} ErrorResponse "Missing permission members:read"
+// @Failure 404 {object} ErrorResponse "Member not found"
+// @Failure 500 {object} ErrorResponse "Error fetching statement"
+// @Security BearerAuth
+// @Security ApiKeyAuth
+// @Router /members/{id}/statement [get]
+func GetMemberStatement(c *gin.Context) {
+	memberID, ok := paramID(c, "id")
+	if !ok || !findMember(c, memberID) {
+		return
+	}
+	limit, offset := pagination(c)
+
+	balance, err := ledger.Balance(config.GetDB().WithContext(c.Request.Context()), memberID)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Error fetching balance for member", "member_id", memberID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching statement"})
+		return
+	}
+	entries, err := ledger.Statement(c.Request.Context(), config.GetDB(), memberID, limit, offset)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Error fetching statement for member", "member_id", memberID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching statement"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"member_id": memberID,
+		"balance":   balance,
+		"limit":     limit,
+		"offset":    offset,
+		"entries":   entries,
+	})
+}
+
+// @Summary Record a payment from a member
+// @Description Credits a payment in minor units against the outstanding balance of a member. Requires permission ledger:write.
+// @Accept json
+// @Produce json
+// @Param id path int true "Member ID"
+// @Param payment body CreditRequest true "Payment details"
+// @Param Idempotency-Key header string false "Key to retry the request safely, the first response is replayed for repeats"
+// @Success 201 {object} models.LedgerEntry "Payment recorded"
+// @Failure 400 {object} ErrorResponse "Invalid input"
+// @Failure 401 {object} ErrorResponse "Missing or invalid credentials"
+// @Failure 403 {object} ErrorResponse "Missing permission ledger:write"
+// @Failure 404 {object} ErrorResponse "Member not found"
+// @Failure 422 {object} ErrorResponse "Idempotency-Key reused with a different request"
+// @Failure 500 {object} ErrorResponse "Error recording payment"
+// @Security BearerAuth
+// @Security ApiKeyAuth
+// @Router /members/{id}/payments [post]
+func CreatePayment(c *gin.Context) {
+	createCredit(c, models.LedgerEntryPayment)
+}
+
+// @Summary Waive charges of a member
+// @Description Credits a waiver in minor units against the outstanding balance of a member. Requires permission ledger:write.
+// @Accept json
+// @Produce json
+// @Param id path int true "Member ID"
+// @Param waiver body CreditRequest true "Waiver details"
+// @Param Idempotency-Key header string false "Key to retry the request safely, the first response is replayed for repeats"
+// @Success 201 {object} models.LedgerEntry "Waiver recorded"
+// @Failure 400 {object} ErrorResponse "Invalid input"
+// @Failure 401 {object} ErrorResponse "Missing or invalid credentials"
+// @Failure 403 {object} ErrorResponse "Missing permission ledger:write"
+// @Failure 404 {object} ErrorResponse "Member not found"
+// @Failure 422 {object} ErrorResponse "Idempotency-Key reused with a different request"
+// @Failure 500 {object} ErrorResponse "Error recording waiver"
+// @Security BearerAuth
+// @Security ApiKeyAuth
+// @Router /members/{id}/waivers [post]
+func CreateWaiver(c *gin.Context) {
+	createCredit(c, models.LedgerEntryWaiver)
+}
+
+func createCredit(c *gin.Context, entryType string) {
+	memberID, ok := paramID(c, "id")
+	if !ok {
+		return
+	}
+	slog.InfoContext(c.Request.Context(), "Got the request to record a credit", "type", entryType, "member_id", memberID)
+
+	var request CreditRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respondBindError(c, err)
+		return
+	}
+
+	entry, err := ledger.Credit(c.Request.Context(), config.GetDB(), memberID, entryType, request.Amount, request.Description)
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Member not found"})
+		return
+	case errors.Is(err, ledger.ErrInvalidAmount), errors.Is(err, ledger.ErrExceedsBalance):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input", "details": []string{err.Error()}})
+		return
+	case err != nil:
+		slog.ErrorContext(c.Request.Context(), "Error recording credit", "type", entryType, "member_id", memberID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error recording " + entryType})
+		return
+	}
+	slog.InfoContext(c.Request.Context(), "Successfully recorded a credit", "type", entryType, "amount", request.Amount, "member_id", memberID)
+	c.JSON(http.StatusCreated, entry)
+}
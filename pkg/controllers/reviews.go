@@ -0,0 +1,214 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/arepala-uml/books-management-system/pkg/auth"
+	"github.com/arepala-uml/books-management-system/pkg/cache"
+	"github.com/arepala-uml/books-management-system/pkg/config"
+	"github.com/arepala-uml/books-management-system/pkg/logging"
+	"github.com/arepala-uml/books-management-system/pkg/models"
+	"github.com/arepala-uml/books-management-system/pkg/reviews"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type ReviewListResponse struct {
+	Limit   int             `json:"limit"`
+	Offset  int             `json:"offset"`
+	Total   int64           `json:"total"`
+	Reviews []models.Review `json:"reviews"`
+}
+
+type ModerationRequest struct {
+	Status string `json:"status" binding:"required,oneof=pending approved rejected"`
+}
+
+// @Summary Review a book
+// @Description Posts a 1-5 star rating with an optional text on a book, as the member linked to the signed in user. Reviewing as another member, with member_id, also requires permission members:write. Reviews start pending moderation and a member can review a book only once. Requires permission reviews:write.
+// @Accept json
+// @Produce json
+// @Param id path int true "Book ID"
+// @Param review body models.Review true "Review details"
+// @Param Idempotency-Key header string false "Key to retry the request safely, the first response is replayed for repeats"
+// @Success 201 {object} models.Review "Review submitted for moderation"
+// @Failure 400 {object} ErrorResponse "Invalid input"
+// @Failure 401 {object} ErrorResponse "Missing or invalid credentials"
+// @Failure 403 {object} ErrorResponse "Missing permission reviews:write, no member linked to the user or missing permission members:write to review as another member"
+// @Failure 404 {object} ErrorResponse "Book or member not found"
+// @Failure 409 {object} ErrorResponse "Member has already reviewed this book"
+// @Failure 422 {object} ErrorResponse "Idempotency-Key reused with a different request"
+// @Failure 500 {object} ErrorResponse "Error creating review"
+// @Security BearerAuth
+// @Security ApiKeyAuth
+// @Router /books/{id}/reviews [post]
+func CreateReview(c *gin.Context) {
+	bookID, ok := paramID(c, "id")
+	if !ok {
+		return
+	}
+	slog.InfoContext(c.Request.Context(), "Got the request to review a book")
+
+	var review models.Review
+	if err := c.ShouldBindJSON(&review); err != nil {
+		respondBindError(c, err)
+		return
+	}
+	review.BookID = bookID
+
+	if err := config.GetDB().WithContext(c.Request.Context()).First(&models.Book{}, bookID).Error; err != nil {
+		slog.ErrorContext(c.Request.Context(), "Book not found", "error", err)
+		c.JSON(http.StatusNotFound, gin.H{"error": "Book not found"})
+		return
+	}
+	memberID, ok := reviewingMember(c, review.MemberID)
+	if !ok {
+		return
+	}
+	review.MemberID = memberID
+	if !findMember(c, review.MemberID) {
+		return
+	}
+
+	err := reviews.Create(c.Request.Context(), &review)
+	if errors.Is(err, reviews.ErrAlreadyReviewed) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Member has already reviewed this book"})
+		return
+	} else if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Error creating review", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error creating review"})
+		return
+	}
+	slog.InfoContext(c.Request.Context(), "Successfully added review", "review_id", review.ID, "member_id", review.MemberID)
+	c.JSON(http.StatusCreated, review)
+}
+
+// reviewingMember returns the member a review is posted as: the member linked to the signed in user, or
+// the requested one when the principal may act for other members. It responds and returns false otherwise.
+func reviewingMember(c *gin.Context, requested int) (int, bool) {
+	principal, _ := auth.GetPrincipal(c)
+	if requested != 0 && principal.Can(auth.PermMembersWrite) {
+		return requested, true
+	}
+
+	var member models.Member
+	err := gorm.ErrRecordNotFound
+	if principal.APIKeyID == 0 {
+		err = config.GetDB().WithContext(c.Request.Context()).Where("user_id = ?", principal.UserID).First(&member).Error
+	}
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Forbidden", "details": []string{"no member is linked to this account"}})
+		return 0, false
+	} else if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Error finding the member of the user", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error creating review"})
+		return 0, false
+	}
+	if requested != 0 && requested != member.ID {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error":   "Forbidden",
+			"details": []string{fmt.Sprintf("missing permission %s to review as another member", auth.PermMembersWrite)},
+		})
+		return 0, false
+	}
+	return member.ID, true
+}
+
+// @Summary List the reviews of a book
+// @Description Fetches the reviews of a book newest first, with pagination support. Only approved reviews are listed unless another status is requested. Requires permission books:read, and reviews:moderate for pending or rejected reviews.
+// @Param id path int true "Book ID"
+// @Param status query string false "Moderation status" Enums(pending, approved, rejected) default(approved)
+// @Param limit query int false "Limit the number of reviews per page" default(10)
+// @Param offset query int false "Offset for pagination" default(0)
+// @Success 200 {object} ReviewListResponse "List of reviews"
+// @Failure 400 {object} ErrorResponse "Invalid query parameters"
+// @Failure 401 {object} ErrorResponse "Missing or invalid credentials"
+// @Failure 403 {object} ErrorResponse "Missing permission books:read, or reviews:moderate for pending or rejected reviews"
+// @Failure 500 {object} ErrorResponse "Error fetching reviews"
+// @Security BearerAuth
+// @Security ApiKeyAuth
+// @Router /books/{id}/reviews [get]
+func GetReviews(c *gin.Context) {
+	bookID, ok := paramID(c, "id")
+	if !ok {
+		return
+	}
+	limit, offset := pagination(c)
+	status := c.DefaultQuery("status", models.ReviewApproved)
+	if !models.ValidReviewStatus(status) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid query parameters", "details": []string{reviews.ErrInvalidStatus.Error()}})
+		return
+	}
+	// Reviews awaiting or refused moderation are only shown to moderators
+	if principal, ok := auth.GetPrincipal(c); status != models.ReviewApproved && (!ok || !principal.Can(auth.PermReviewsModerate)) {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error":   "Forbidden",
+			"details": []string{fmt.Sprintf("missing permission %s to list %s reviews", auth.PermReviewsModerate, status)},
+		})
+		return
+	}
+
+	bookReviews, total, err := reviews.List(c.Request.Context(), bookID, status, limit, offset)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Error fetching reviews", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching reviews"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"limit":   limit,
+		"offset":  offset,
+		"total":   total,
+		"reviews": bookReviews,
+	})
+}
+
+// @Summary Moderate a review
+// @Description Approves, rejects or resets a review to pending and updates the rating of its book. Requires permission reviews:moderate.
+// @Accept json
+// @Produce json
+// @Param id path int true "Review ID"
+// @Param moderation body ModerationRequest true "New moderation status"
+// @Param Idempotency-Key header string false "Key to retry the request safely, the first response is replayed for repeats"
+// @Success 200 {object} models.Review "Review moderated"
+// @Failure 400 {object} ErrorResponse "Invalid input"
+// @Failure 401 {object} ErrorResponse "Missing or invalid credentials"
+// @Failure 403 {object} ErrorResponse "Missing permission reviews:moderate"
+// @Failure 404 {object} ErrorResponse "Review not found"
+// @Failure 422 {object} ErrorResponse "Idempotency-Key reused with a different request"
+// @Failure 500 {object} ErrorResponse "Error moderating review"
+// @Security BearerAuth
+// @Security ApiKeyAuth
+// @Router /reviews/{id}/status [put]
+func ModerateReview(c *gin.Context) {
+	reviewID, ok := paramID(c, "id")
+	if !ok {
+		return
+	}
+	slog.InfoContext(c.Request.Context(), "Got the request to moderate a review", "review_id", reviewID)
+
+	var request ModerationRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respondBindError(c, err)
+		return
+	}
+
+	review, book, err := reviews.Moderate(c.Request.Context(), reviewID, request.Status)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Review not found"})
+		return
+	} else if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Error moderating review", "review_id", reviewID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error moderating review"})
+		return
+	}
+	ctx := logging.With(c.Request.Context(), "book_id", book.ID)
+	slog.InfoContext(ctx, "Review moderated", "review_id", reviewID, "status", review.Status,
+		"average_rating", book.AverageRating, "rating_count", book.RatingCount)
+
+	// Refresh the cached book with its new rating
+	cache.StoreBookInCache(ctx, book)
+	c.JSON(http.StatusOK, review)
+}
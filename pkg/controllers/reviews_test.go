@@ -0,0 +1,62 @@
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/arepala-uml/books-management-system/pkg/auth"
+	"github.com/arepala-uml/books-management-system/pkg/config"
+	"github.com/arepala-uml/books-management-system/pkg/models"
+	"github.com/arepala-uml/books-management-system/pkg/testutil"
+	"github.com/gin-gonic/gin"
+)
+
+// apiKey returns an API key with the scopes, valid from the cache of API keys so no database is needed.
+// Its last use counts as recorded, which would be written to Postgres otherwise.
+func apiKey(t *testing.T, id int, scopes ...string) string {
+	t.Helper()
+	plaintext := fmt.Sprintf("bms_test_%d", id)
+	sum := sha256.Sum256([]byte(plaintext))
+	data, err := json.Marshal(map[string]interface{}{"valid": true, "id": id, "name": "test", "scopes": scopes})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	client := config.GetRedisClient()
+	if err := client.Set(ctx, config.RedisKey("API_KEY:"+hex.EncodeToString(sum[:])), data, time.Minute).Err(); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Set(ctx, config.RedisKey(fmt.Sprintf("API_KEY_USED:%d", id)), 1, time.Minute).Err(); err != nil {
+		t.Fatal(err)
+	}
+	return plaintext
+}
+
+func TestGetReviewsHidesUnmoderatedReviews(t *testing.T) {
+	testutil.Redis(t, nil)
+	if err := auth.Init(); err != nil {
+		t.Fatal(err)
+	}
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/books/:id/reviews", auth.Authenticate(), auth.RequirePermission(auth.PermBooksRead), GetReviews)
+	// Even a key allowed to write can't moderate
+	key := apiKey(t, 1, models.APIKeyScopeRead, models.APIKeyScopeWrite)
+
+	for _, status := range []string{models.ReviewPending, models.ReviewRejected} {
+		req := httptest.NewRequest(http.MethodGet, "/books/1/reviews?status="+status, nil)
+		req.Header.Set("X-API-Key", key)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusForbidden {
+			t.Errorf("listing %s reviews with books:read status = %d, want %d", status, w.Code, http.StatusForbidden)
+		}
+	}
+}
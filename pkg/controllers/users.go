@@ -0,0 +1,106 @@
+package controllers
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/arepala-uml/books-management-system/pkg/auth"
+	"github.com/arepala-uml/books-management-system/pkg/config"
+	"github.com/arepala-uml/books-management-system/pkg/models"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type UserRolesRequest struct {
+	Roles []string `json:"roles" binding:"required,dive,oneof=viewer librarian admin"`
+}
+
+type UserRolesResponse struct {
+	UserID int      `json:"user_id"`
+	Roles  []string `json:"roles"`
+}
+
+// findUser writes a 404 response and returns false when the user does not exist
+func findUser(c *gin.Context, userID int) bool {
+	if err := config.GetDB().WithContext(c.Request.Context()).First(&models.User{}, userID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		} else {
+			slog.ErrorContext(c.Request.Context(), "Error fetching user", "user_id", userID, "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching user"})
+		}
+		return false
+	}
+	return true
+}
+
+// @Summary Get the roles of a user
+// @Description Lists the roles assigned to a user. Requires permission users:admin.
+// @Param id path int true "User ID"
+// @Success 200 {object} UserRolesResponse "Roles of the user"
+// @Failure 401 {object} ErrorResponse "Missing or invalid credentials"
+// @Failure 403 {object} ErrorResponse "Missing permission users:admin"
+// @Failure 404 {object} ErrorResponse "User not found"
+// @Failure 500 {object} ErrorResponse "Error fetching roles"
+// @Security BearerAuth
+// @Router /users/{id}/roles [get]
+func GetUserRoles(c *gin.Context) {
+	userID, ok := paramID(c, "id")
+	if !ok || !findUser(c, userID) {
+		return
+	}
+
+	roles, err := auth.UserRoles(userID)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Error fetching roles of user", "user_id", userID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching roles"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"user_id": userID,
+		"roles":   roles,
+	})
+}
+
+// @Summary Assign roles to a user
+// @Description Replaces the roles assigned to a user with viewer, librarian and/or admin. Requires permission users:admin.
+// @Accept json
+// @Produce json
+// @Param id path int true "User ID"
+// @Param roles body UserRolesRequest true "Roles to assign"
+// @Param Idempotency-Key header string false "Key to retry the request safely, the first response is replayed for repeats"
+// @Success 200 {object} UserRolesResponse "Roles assigned"
+// @Failure 400 {object} ErrorResponse "Invalid input"
+// @Failure 401 {object} ErrorResponse "Missing or invalid credentials"
+// @Failure 403 {object} ErrorResponse "Missing permission users:admin"
+// @Failure 404 {object} ErrorResponse "User not found"
+// @Failure 422 {object} ErrorResponse "Idempotency-Key reused with a different request"
+// @Failure 500 {object} ErrorResponse "Error assigning roles"
+// @Security BearerAuth
+// @Router /users/{id}/roles [put]
+func SetUserRoles(c *gin.Context) {
+	userID, ok := paramID(c, "id")
+	if !ok {
+		return
+	}
+	var request UserRolesRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respondBindError(c, err)
+		return
+	}
+	if !findUser(c, userID) {
+		return
+	}
+
+	if err := auth.SetUserRoles(userID, request.Roles); err != nil {
+		slog.ErrorContext(c.Request.Context(), "Error assigning roles to user", "roles", request.Roles, "user_id", userID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error assigning roles"})
+		return
+	}
+	slog.InfoContext(c.Request.Context(), "Assigned roles to user", "roles", request.Roles, "user_id", userID)
+	c.JSON(http.StatusOK, gin.H{
+		"user_id": userID,
+		"roles":   request.Roles,
+	})
+}
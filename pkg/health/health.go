@@ -0,0 +1,192 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/arepala-uml/books-management-system/pkg/breaker"
+	"github.com/arepala-uml/books-management-system/pkg/cache"
+	"github.com/arepala-uml/books-management-system/pkg/config"
+	"github.com/arepala-uml/books-management-system/pkg/kafka"
+	"github.com/gin-gonic/gin"
+)
+
+// Statuses reported by the health endpoints
+const (
+	StatusUp           = "up"
+	StatusDown         = "down"
+	StatusReady        = "ready"
+	StatusNotReady     = "not_ready"
+	StatusDegraded     = "degraded"
+	StatusShuttingDown = "shutting_down"
+)
+
+// CheckResult is the outcome of checking a single dependency
+type CheckResult struct {
+	Status    string `json:"status"`
+	LatencyMs int64  `json:"latency_ms"`
+	Error     string `json:"error,omitempty"`
+	// Circuit is the state of the circuit breaker of the dependency, if it has one
+	Circuit string `json:"circuit,omitempty"`
+}
+
+// ReadinessResponse is the breakdown returned by /readyz
+type ReadinessResponse struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks,omitempty"`
+}
+
+// check verifies a dependency within the timeout of its context. The service can't serve without
+// a critical dependency, and serves in degraded mode without the others.
+type check struct {
+	name     string
+	critical bool
+	timeout  func(config.HealthConfig) time.Duration
+	run      func(ctx context.Context) error
+}
+
+var checks = []check{
+	{name: "postgres", critical: true, timeout: func(c config.HealthConfig) time.Duration { return c.PostgresTimeout }, run: checkPostgres},
+	{name: "redis", timeout: func(c config.HealthConfig) time.Duration { return c.RedisTimeout }, run: checkRedis},
+	{name: "kafka", timeout: func(c config.HealthConfig) time.Duration { return c.KafkaTimeout }, run: checkKafka},
+}
+
+var shuttingDown atomic.Bool
+
+// SetShuttingDown makes /readyz fail so load balancers stop routing new requests here
+func SetShuttingDown() {
+	shuttingDown.Store(true)
+}
+
+// @Summary Liveness probe
+// @Description Reports that the process is running. It does not check dependencies.
+// @Produce json
+// @Success 200 {object} map[string]string "Process is alive"
+// @Router /healthz [get]
+func Liveness(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": StatusUp})
+}
+
+// @Summary Readiness probe
+// @Description Checks Postgres, Redis (including the RedisJSON module when books are cached as RedisJSON) and Kafka, each within its own timeout, and reports the status of each. The server is degraded but ready while only Redis or Kafka is down, as it serves from Postgres. Fails while Postgres is down or the server is shutting down.
+// @Produce json
+// @Success 200 {object} ReadinessResponse "Ready to serve requests, possibly degraded"
+// @Failure 503 {object} ReadinessResponse "Postgres is down or the server is shutting down"
+// @Router /readyz [get]
+func Readiness(c *gin.Context) {
+	if shuttingDown.Load() {
+		c.JSON(http.StatusServiceUnavailable, ReadinessResponse{Status: StatusShuttingDown})
+		return
+	}
+
+	results := runChecks(c.Request.Context())
+	response := ReadinessResponse{Status: StatusReady, Checks: results}
+	status := http.StatusOK
+	for _, chk := range checks {
+		result := results[chk.name]
+		if result.Status == StatusUp {
+			continue
+		}
+		slog.Warn("Readiness check failed", "dependency", chk.name, "critical", chk.critical, "error", result.Error)
+		if chk.critical {
+			response.Status = StatusNotReady
+			status = http.StatusServiceUnavailable
+		} else if response.Status == StatusReady {
+			response.Status = StatusDegraded
+		}
+	}
+	c.JSON(status, response)
+}
+
+// runChecks checks every dependency concurrently
+func runChecks(ctx context.Context) map[string]CheckResult {
+	cfg := config.Get().Health
+	results := make(map[string]CheckResult, len(checks))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, chk := range checks {
+		wg.Add(1)
+		go func(chk check) {
+			defer wg.Done()
+			checkCtx, cancel := context.WithTimeout(ctx, chk.timeout(cfg))
+			defer cancel()
+
+			start := time.Now()
+			err := runWithContext(checkCtx, chk.run)
+			result := CheckResult{Status: StatusUp, LatencyMs: time.Since(start).Milliseconds()}
+			if err != nil {
+				result.Status = StatusDown
+				result.Error = err.Error()
+			}
+			if b := breaker.Get(chk.name); b != nil {
+				result.Circuit = b.State()
+			}
+
+			mu.Lock()
+			results[chk.name] = result
+			mu.Unlock()
+		}(chk)
+	}
+	wg.Wait()
+	return results
+}
+
+// runWithContext returns when the check completes or its context expires, whichever is first,
+// so a client library ignoring the context can't hold up the probe
+func runWithContext(ctx context.Context, run func(ctx context.Context) error) error {
+	done := make(chan error, 1)
+	go func() { done <- run(ctx) }()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("timed out: %w", ctx.Err())
+	}
+}
+
+func checkPostgres(ctx context.Context) error {
+	if config.GetDB() == nil {
+		return errors.New("not connected")
+	}
+	sqlDB, err := config.GetDB().DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.PingContext(ctx)
+}
+
+func checkRedis(ctx context.Context) error {
+	if config.GetRedisClient() == nil {
+		return errors.New("not connected")
+	}
+	if err := config.GetRedisClient().Ping(ctx).Err(); err != nil {
+		return err
+	}
+
+	// The books can only be cached as RedisJSON documents when Redis has the module
+	encoding, err := cache.Encoding(ctx)
+	if err != nil {
+		return err
+	}
+	if encoding != cache.EncodingJSON {
+		return nil
+	}
+	supported, err := cache.HasRedisJSON(ctx, config.GetRedisClient())
+	if err != nil {
+		return fmt.Errorf("checking RedisJSON module: %w", err)
+	}
+	if !supported {
+		return errors.New("RedisJSON module is not loaded, set CACHE_ENCODING to string or msgpack")
+	}
+	return nil
+}
+
+func checkKafka(ctx context.Context) error {
+	return kafka.Ping(ctx, kafka.BrokerList())
+}
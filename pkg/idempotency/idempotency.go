@@ -0,0 +1,254 @@
+package idempotency
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/arepala-uml/books-management-system/pkg/auth"
+	"github.com/arepala-uml/books-management-system/pkg/config"
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	HeaderKey      = "Idempotency-Key"
+	HeaderReplayed = "Idempotent-Replayed"
+	maxKeyLength   = 255
+
+	omitResponseKey = "idempotency_omit_response"
+)
+
+// record is the first response to a request with an idempotency key, replayed for repeats
+type record struct {
+	Fingerprint string `json:"fingerprint"`
+	Status      int    `json:"status"`
+	ContentType string `json:"content_type"`
+	Body        []byte `json:"body"`
+	// Omitted is set instead of the body for the routes using OmitResponse
+	Omitted bool `json:"omitted,omitempty"`
+}
+
+// releaseLock deletes the lock only if it is still held by the given token
+var releaseLock = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+// responseRecorder keeps a copy of the response body written by the handlers
+type responseRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (r *responseRecorder) Write(data []byte) (int, error) {
+	r.body.Write(data)
+	return r.ResponseWriter.Write(data)
+}
+
+func (r *responseRecorder) WriteString(data string) (int, error) {
+	r.body.WriteString(data)
+	return r.ResponseWriter.WriteString(data)
+}
+
+func ttl() time.Duration {
+	return time.Duration(config.Get().Idempotency.TTLSeconds) * time.Second
+}
+
+func lockTTL() time.Duration {
+	return time.Duration(config.Get().Idempotency.LockTTLSeconds) * time.Second
+}
+
+// storable reports whether a response should be replayed for repeats of its request.
+// Server errors and responses to transient conditions are not stored so the client can retry.
+func storable(status int) bool {
+	switch status {
+	case http.StatusUnauthorized, http.StatusForbidden, http.StatusRequestTimeout,
+		http.StatusConflict, http.StatusTooManyRequests:
+		return false
+	}
+	return status < http.StatusInternalServerError
+}
+
+// scope namespaces idempotency keys by the caller so clients can't replay each other's responses
+func scope(c *gin.Context) string {
+	if principal, ok := auth.GetPrincipal(c); ok {
+		if principal.APIKeyID != 0 {
+			return "api_key:" + strconv.Itoa(principal.APIKeyID)
+		}
+		return "user:" + strconv.Itoa(principal.UserID)
+	}
+	return "ip:" + c.ClientIP()
+}
+
+// OmitResponse is a gin middleware for the routes whose responses carry credentials, such as an issued
+// API key. Their responses are not stored: a repeat of the key only gets the status of the first response
+// and a message, so the credentials can't be read back with the Idempotency-Key.
+func OmitResponse() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(omitResponseKey, true)
+		c.Next()
+	}
+}
+
+// Middleware is a gin middleware making writes with an Idempotency-Key header safe to retry.
+// The first response is stored in Redis for IDEMPOTENCY_TTL seconds and replayed for repeats of the key.
+// Reusing a key with a different request returns 422, a repeat while the first request is still running
+// returns 409, and a body larger than IDEMPOTENCY_MAX_BODY_BYTES returns 413. When Redis is unavailable
+// requests are processed without deduplication.
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(HeaderKey)
+		method := c.Request.Method
+		if key == "" || method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions {
+			c.Next()
+			return
+		}
+		if len(key) > maxKeyLength {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"error":   "Invalid input",
+				"details": []string{fmt.Sprintf("%s cannot exceed %d characters", HeaderKey, maxKeyLength)},
+			})
+			return
+		}
+
+		maxBody := config.Get().Idempotency.MaxBodyBytes
+		body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxBody))
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{
+				"error":   "Request body too large",
+				"details": []string{fmt.Sprintf("the body of a request with an %s cannot exceed %d bytes", HeaderKey, maxBody)},
+			})
+			return
+		} else if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Error reading request body"})
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		fingerprint := requestFingerprint(scope(c), method, c.Request.URL.Path, body)
+		keySum := sha256.Sum256([]byte(scope(c) + ":" + key))
+		redisKey := config.RedisKey("IDEMPOTENCY:" + hex.EncodeToString(keySum[:]))
+		lockKey := config.RedisKey("IDEMPOTENCY_LOCK:" + hex.EncodeToString(keySum[:]))
+		ctx := context.Background()
+
+		if replayed, err := replay(ctx, c, redisKey, fingerprint); err != nil {
+			slog.ErrorContext(c.Request.Context(), "Idempotency store unavailable, processing request without it", "error", err)
+			c.Next()
+			return
+		} else if replayed {
+			return
+		}
+
+		token := randomToken()
+		locked, err := config.GetRedisClient().SetNX(ctx, lockKey, token, lockTTL()).Result()
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Idempotency store unavailable, processing request without it", "error", err)
+			c.Next()
+			return
+		}
+		if !locked {
+			c.AbortWithStatusJSON(http.StatusConflict, gin.H{
+				"error":   "Request in progress",
+				"details": []string{fmt.Sprintf("a request with this %s is still being processed", HeaderKey)},
+			})
+			return
+		}
+		defer func() {
+			if err := releaseLock.Run(ctx, config.GetRedisClient(), []string{lockKey}, token).Err(); err != nil {
+				slog.ErrorContext(c.Request.Context(), "Failed to release idempotency lock", "error", err)
+			}
+		}()
+
+		// The first request may have completed between the lookup and taking the lock
+		if replayed, err := replay(ctx, c, redisKey, fingerprint); err == nil && replayed {
+			return
+		}
+
+		recorder := &responseRecorder{ResponseWriter: c.Writer}
+		c.Writer = recorder
+		c.Next()
+
+		status := recorder.Status()
+		if !storable(status) {
+			return
+		}
+		stored := record{
+			Fingerprint: fingerprint,
+			Status:      status,
+			ContentType: recorder.Header().Get("Content-Type"),
+			Body:        recorder.body.Bytes(),
+		}
+		if c.GetBool(omitResponseKey) {
+			stored.ContentType, stored.Body, stored.Omitted = "", nil, true
+		}
+		data, err := json.Marshal(stored)
+		if err == nil {
+			err = config.GetRedisClient().Set(ctx, redisKey, data, ttl()).Err()
+		}
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to store idempotent response", "error", err)
+		}
+	}
+}
+
+// replay writes the stored response for the key, or a 422 when the key was used for another request.
+// It returns false when no response is stored yet.
+func replay(ctx context.Context, c *gin.Context, redisKey string, fingerprint string) (bool, error) {
+	data, err := config.GetRedisClient().Get(ctx, redisKey).Bytes()
+	if err == redis.Nil {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	var stored record
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return false, err
+	}
+	if stored.Fingerprint != fingerprint {
+		c.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{
+			"error":   "Idempotency key reused",
+			"details": []string{fmt.Sprintf("%s was already used for a different request", HeaderKey)},
+		})
+		return true, nil
+	}
+
+	slog.InfoContext(c.Request.Context(), "Replaying stored response", "method", c.Request.Method, "path", c.Request.URL.Path)
+	c.Header(HeaderReplayed, "true")
+	if stored.Omitted {
+		c.AbortWithStatusJSON(stored.Status, gin.H{
+			"message": fmt.Sprintf("The request with this %s was already processed, its response is not stored", HeaderKey),
+		})
+		return true, nil
+	}
+	c.Data(stored.Status, stored.ContentType, stored.Body)
+	c.Abort()
+	return true, nil
+}
+
+// requestFingerprint identifies a request by its caller, method, path and body, so a key is only replayed
+// for the same request of the same principal
+func requestFingerprint(scope, method, path string, body []byte) string {
+	sum := sha256.Sum256(append([]byte(scope+"\n"+method+" "+path+"\n"), body...))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomToken() string {
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
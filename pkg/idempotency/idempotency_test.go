@@ -0,0 +1,225 @@
+package idempotency
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/arepala-uml/books-management-system/pkg/auth"
+	"github.com/arepala-uml/books-management-system/pkg/config"
+	"github.com/arepala-uml/books-management-system/pkg/testutil"
+	"github.com/gin-gonic/gin"
+)
+
+// setup returns a router with the idempotency middleware behind authentication, over an in-memory
+// Redis with the given settings, whose handlers count how often they ran
+func setup(t *testing.T, settings map[string]string) (*gin.Engine, *atomic.Int64) {
+	t.Helper()
+	testutil.Redis(t, settings)
+	if err := auth.Init(); err != nil {
+		t.Fatalf("auth.Init() error = %v", err)
+	}
+
+	calls := new(atomic.Int64)
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	api := router.Group("/", auth.Authenticate(), Middleware())
+	api.POST("/items", func(c *gin.Context) {
+		c.JSON(http.StatusCreated, gin.H{"id": calls.Add(1)})
+	})
+	api.PUT("/items/:id", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"id": c.Param("id"), "call": calls.Add(1)})
+	})
+	api.POST("/flaky", func(c *gin.Context) {
+		if calls.Add(1) == 1 {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "try again"})
+			return
+		}
+		c.JSON(http.StatusCreated, gin.H{"ok": true})
+	})
+	api.POST("/secrets", OmitResponse(), func(c *gin.Context) {
+		c.JSON(http.StatusCreated, gin.H{"secret": "s3cr3t-" + strconv.FormatInt(calls.Add(1), 10)})
+	})
+	return router, calls
+}
+
+func token(t *testing.T, userID int) string {
+	return testutil.Token(t, userID, time.Minute)
+}
+
+func send(router *gin.Engine, bearer, method, path, key, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+bearer)
+	req.Header.Set("Content-Type", "application/json")
+	if key != "" {
+		req.Header.Set(HeaderKey, key)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestReplay(t *testing.T) {
+	router, calls := setup(t, nil)
+	user := token(t, 1)
+
+	first := send(router, user, http.MethodPost, "/items", "key-1", `{"title":"Dune"}`)
+	if first.Code != http.StatusCreated {
+		t.Fatalf("first request status = %d, want %d", first.Code, http.StatusCreated)
+	}
+	repeat := send(router, user, http.MethodPost, "/items", "key-1", `{"title":"Dune"}`)
+	if repeat.Code != first.Code || repeat.Body.String() != first.Body.String() {
+		t.Errorf("repeat = %d %s, want the first response %d %s", repeat.Code, repeat.Body, first.Code, first.Body)
+	}
+	if got := repeat.Header().Get(HeaderReplayed); got != "true" {
+		t.Errorf("%s = %q, want true", HeaderReplayed, got)
+	}
+	if got := repeat.Header().Get("Content-Type"); got != first.Header().Get("Content-Type") {
+		t.Errorf("replayed Content-Type = %q, want %q", got, first.Header().Get("Content-Type"))
+	}
+	if calls.Load() != 1 {
+		t.Errorf("handler ran %d times, want 1", calls.Load())
+	}
+
+	// Without a key, or with another one, requests run again
+	send(router, user, http.MethodPost, "/items", "", `{"title":"Dune"}`)
+	send(router, user, http.MethodPost, "/items", "key-2", `{"title":"Dune"}`)
+	if calls.Load() != 3 {
+		t.Errorf("handler ran %d times, want 3", calls.Load())
+	}
+}
+
+func TestFingerprintMismatch(t *testing.T) {
+	router, calls := setup(t, nil)
+	user := token(t, 1)
+
+	send(router, user, http.MethodPut, "/items/1", "key-1", `{"title":"Dune"}`)
+	tests := []struct {
+		name, path, body string
+	}{
+		{"another body", "/items/1", `{"title":"Emma"}`},
+		{"another path", "/items/2", `{"title":"Dune"}`},
+	}
+	for _, tt := range tests {
+		w := send(router, user, http.MethodPut, tt.path, "key-1", tt.body)
+		if w.Code != http.StatusUnprocessableEntity {
+			t.Errorf("%s: status = %d, want %d", tt.name, w.Code, http.StatusUnprocessableEntity)
+		}
+	}
+	if calls.Load() != 1 {
+		t.Errorf("handler ran %d times, want 1", calls.Load())
+	}
+}
+
+func TestKeysAreScopedByPrincipal(t *testing.T) {
+	router, calls := setup(t, nil)
+
+	first := send(router, token(t, 1), http.MethodPost, "/items", "shared", `{"title":"Dune"}`)
+	other := send(router, token(t, 2), http.MethodPost, "/items", "shared", `{"title":"Dune"}`)
+	if other.Header().Get(HeaderReplayed) != "" || other.Body.String() == first.Body.String() {
+		t.Errorf("another user got the response of the first: %s", other.Body)
+	}
+	if calls.Load() != 2 {
+		t.Errorf("handler ran %d times, want 2", calls.Load())
+	}
+}
+
+func TestOmitResponse(t *testing.T) {
+	router, calls := setup(t, nil)
+	user := token(t, 1)
+
+	first := send(router, user, http.MethodPost, "/secrets", "key-1", `{}`)
+	if !strings.Contains(first.Body.String(), "s3cr3t-1") {
+		t.Fatalf("first response = %s, want the secret", first.Body)
+	}
+	repeat := send(router, user, http.MethodPost, "/secrets", "key-1", `{}`)
+	if repeat.Code != http.StatusCreated || repeat.Header().Get(HeaderReplayed) != "true" {
+		t.Errorf("repeat = %d replayed %q, want the status of the first response replayed", repeat.Code,
+			repeat.Header().Get(HeaderReplayed))
+	}
+	if strings.Contains(repeat.Body.String(), "s3cr3t") {
+		t.Errorf("repeat returned the secret: %s", repeat.Body)
+	}
+	if calls.Load() != 1 {
+		t.Errorf("handler ran %d times, want 1", calls.Load())
+	}
+
+	// Nothing of the response is kept in Redis
+	keys, err := config.GetRedisClient().Keys(context.Background(), config.RedisKey("IDEMPOTENCY:*")).Result()
+	if err != nil || len(keys) != 1 {
+		t.Fatalf("stored keys = %v, %v, want one", keys, err)
+	}
+	stored, _ := config.GetRedisClient().Get(context.Background(), keys[0]).Result()
+	if strings.Contains(stored, "s3cr3t") {
+		t.Errorf("stored record contains the secret: %s", stored)
+	}
+}
+
+func TestServerErrorsAreRetried(t *testing.T) {
+	router, calls := setup(t, nil)
+	user := token(t, 1)
+
+	if w := send(router, user, http.MethodPost, "/flaky", "key-1", `{}`); w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("first request status = %d, want %d", w.Code, http.StatusServiceUnavailable)
+	}
+	if w := send(router, user, http.MethodPost, "/flaky", "key-1", `{}`); w.Code != http.StatusCreated {
+		t.Errorf("retry status = %d, want %d", w.Code, http.StatusCreated)
+	}
+	if calls.Load() != 2 {
+		t.Errorf("handler ran %d times, want 2", calls.Load())
+	}
+}
+
+func TestInvalidKey(t *testing.T) {
+	router, calls := setup(t, nil)
+
+	w := send(router, token(t, 1), http.MethodPost, "/items", strings.Repeat("k", maxKeyLength+1), `{}`)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	if calls.Load() != 0 {
+		t.Errorf("handler ran %d times, want 0", calls.Load())
+	}
+}
+
+func TestBodyLimit(t *testing.T) {
+	router, calls := setup(t, map[string]string{"IDEMPOTENCY_MAX_BODY_BYTES": "16"})
+
+	w := send(router, token(t, 1), http.MethodPost, "/items", "key-1", `{"title": "too long for the limit"}`)
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusRequestEntityTooLarge)
+	}
+	if calls.Load() != 0 {
+		t.Errorf("handler ran %d times, want 0", calls.Load())
+	}
+
+	// Bodies up to the limit, and those of requests without a key, are handled
+	if w := send(router, token(t, 1), http.MethodPost, "/items", "key-2", `{"title":"Dune"}`); w.Code != http.StatusCreated {
+		t.Errorf("status of a body at the limit = %d, want %d", w.Code, http.StatusCreated)
+	}
+	if w := send(router, token(t, 1), http.MethodPost, "/items", "", `{"title": "too long for the limit"}`); w.Code != http.StatusCreated {
+		t.Errorf("status without a key = %d, want %d", w.Code, http.StatusCreated)
+	}
+}
+
+func TestRequestFingerprint(t *testing.T) {
+	base := requestFingerprint("user:1", http.MethodPost, "/items", []byte(`{}`))
+	if base != requestFingerprint("user:1", http.MethodPost, "/items", []byte(`{}`)) {
+		t.Error("the same request has different fingerprints")
+	}
+	for name, other := range map[string]string{
+		"principal": requestFingerprint("user:2", http.MethodPost, "/items", []byte(`{}`)),
+		"method":    requestFingerprint("user:1", http.MethodPut, "/items", []byte(`{}`)),
+		"path":      requestFingerprint("user:1", http.MethodPost, "/items/1", []byte(`{}`)),
+		"body":      requestFingerprint("user:1", http.MethodPost, "/items", []byte(`{"a":1}`)),
+	} {
+		if other == base {
+			t.Errorf("requests differing by %s have the same fingerprint", name)
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package kafka
+
+import (
+	"errors"
+	"log/slog"
+
+	"github.com/IBM/sarama"
+)
+
+// CreateTopic creates topic with the given partitions and replication factor. It returns false without
+// an error when the topic already exists.
+func CreateTopic(brokerList []string, topic string, partitions int32, replicationFactor int16) (bool, error) {
+	admin, err := sarama.NewClusterAdmin(brokerList, sarama.NewConfig())
+	if err != nil {
+		return false, err
+	}
+	defer admin.Close()
+
+	err = admin.CreateTopic(topic, &sarama.TopicDetail{
+		NumPartitions:     partitions,
+		ReplicationFactor: replicationFactor,
+	}, false)
+	if errors.Is(err, sarama.ErrTopicAlreadyExists) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	slog.Info("Created Kafka topic", "topic", topic, "partitions", partitions, "replication_factor", replicationFactor)
+	return true, nil
+}
@@ -2,47 +2,133 @@ package kafka
 
 import (
 	"context"
+	"fmt"
+	"log/slog"
+	"time"
 
 	"github.com/IBM/sarama"
-	"github.com/labstack/gommon/log"
+	"github.com/arepala-uml/books-management-system/pkg/config"
+	"github.com/arepala-uml/books-management-system/pkg/logging"
+	"github.com/arepala-uml/books-management-system/pkg/metrics"
+	"github.com/arepala-uml/books-management-system/pkg/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type EventHandler struct{}
 
-// Initializes a consumer and starts listening for events
-func StartConsumer(brokerList []string, topic string) error {
+// RunConsumer consumes KAFKA_TOPIC until the context is cancelled. When a reload changes the brokers or
+// the topic, the current consumer finishes the messages it is handling and a new one takes over. While
+// Kafka is unavailable it tries again every BREAKER_PROBE_INTERVAL.
+func RunConsumer(ctx context.Context) {
+	restart := make(chan struct{}, 1)
+	config.Subscribe("kafka-consumer", func(old, new *config.Config) error {
+		if old.Kafka != new.Kafka {
+			select {
+			case restart <- struct{}{}:
+			default:
+			}
+		}
+		return nil
+	})
+
+	for {
+		cfg := config.Get().Kafka
+		slog.Info("Starting Kafka consumer", "brokers", cfg.Brokers(), "topic", cfg.Topic)
+		consumerCtx, cancel := context.WithCancel(ctx)
+		done := make(chan error, 1)
+		go func() {
+			done <- StartConsumer(consumerCtx, cfg.Brokers(), cfg.Topic)
+		}()
+
+		select {
+		case <-restart:
+			cancel()
+			<-done
+			slog.Info("Restarting Kafka consumer with the reloaded configuration")
+			continue
+		case err := <-done:
+			cancel()
+			if err == nil || ctx.Err() != nil {
+				return
+			}
+			slog.Error("Error in consumer, starting it again", "retry_in", config.Get().Breaker.ProbeInterval.String(),
+				"error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-restart:
+		case <-time.After(config.Get().Breaker.ProbeInterval):
+		}
+	}
+}
+
+// Initializes a consumer and listens for events until the context is cancelled
+func StartConsumer(ctx context.Context, brokerList []string, topic string) error {
 	// Create a new consumer group
-	log.Info(brokerList)
 	consumer, err := sarama.NewConsumerGroup(brokerList, "book-events-group", nil)
 	if err != nil {
-		log.Errorf("Failed to start Kafka consumer: %v", err)
+		slog.Error("Failed to start Kafka consumer", "brokers", brokerList, "error", err)
 		return err
 	}
 
-	ctx := context.Background()
+	defer consumer.Close()
+
 	for {
 		err := consumer.Consume(ctx, []string{topic}, &EventHandler{})
+		if ctx.Err() != nil {
+			slog.Info("Kafka consumer is shutting down")
+			return nil
+		}
 		if err != nil {
-			log.Infof("Error consuming message: %v", err)
+			slog.Error("Error consuming message", "topic", topic, "error", err)
+			// Back off before rejoining the group so a broker outage doesn't spin the loop
+			select {
+			case <-ctx.Done():
+			case <-time.After(time.Second):
+			}
 		}
 	}
 }
 
 func (h *EventHandler) Setup(sarama.ConsumerGroupSession) error {
-	log.Info("Consumer group setup called")
+	slog.Info("Consumer group setup called")
 	return nil
 }
 
 func (h *EventHandler) Cleanup(sarama.ConsumerGroupSession) error {
-	log.Info("Consumer group cleanup called")
+	slog.Info("Consumer group cleanup called")
 	return nil
 }
 
 func (h *EventHandler) ConsumeClaim(sess sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
 	for message := range claim.Messages() {
+		// Continue the trace and the request id of the request that published the message
+		carrier := tracing.ConsumerCarrier{Message: message}
+		ctx := otel.GetTextMapPropagator().Extract(sess.Context(), carrier)
+		if requestID := carrier.Get(logging.RequestIDHeader); requestID != "" {
+			ctx = logging.WithRequestID(ctx, requestID)
+		}
+		ctx, span := tracing.Tracer().Start(ctx, message.Topic+" process",
+			trace.WithSpanKind(trace.SpanKindConsumer),
+			trace.WithAttributes(
+				semconv.MessagingSystemKafka,
+				semconv.MessagingDestinationName(message.Topic),
+				semconv.MessagingDestinationPartitionID(fmt.Sprint(message.Partition)),
+				attribute.Int64("messaging.kafka.offset", message.Offset),
+			))
+
 		// Log the consumed message
-		log.Infof("Consumed message: %s", string(message.Value))
+		slog.InfoContext(ctx, "Consumed message", "topic", message.Topic, "partition", message.Partition,
+			"offset", message.Offset, "message", string(message.Value))
 		sess.MarkMessage(message, "")
+		span.End()
+		// The high water mark is the offset of the next message to be produced
+		metrics.SetConsumerLag(message.Topic, message.Partition, claim.HighWaterMarkOffset()-message.Offset-1)
 	}
 	return nil
 }
@@ -1,36 +1,161 @@
 package kafka
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
 
 	"github.com/IBM/sarama"
-	"github.com/labstack/gommon/log"
-	"github.com/spf13/viper"
+	"github.com/arepala-uml/books-management-system/pkg/breaker"
+	"github.com/arepala-uml/books-management-system/pkg/config"
+	"github.com/arepala-uml/books-management-system/pkg/logging"
+	"github.com/arepala-uml/books-management-system/pkg/metrics"
+	"github.com/arepala-uml/books-management-system/pkg/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
-// Every POST, PUT, DELETE request should publish an event to a Kafka topic
-func PublishEvent(topic string, message []byte) error {
-	brokersUrl := []string{fmt.Sprintf("%s:%s", viper.GetString("KAFKA_HOST"), viper.GetString("KAFKA_PORT"))}
-	log.Infof("Brokers URL: %s", brokersUrl)
+var (
+	producer   sarama.SyncProducer
+	producerMu sync.Mutex
 
-	// Create the Kafka producer
-	producer, err := ConnectProducer(brokersUrl)
+	// publishBreaker fails publishes fast while Kafka is down, so writes don't wait on it
+	publishBreaker = breaker.New("kafka", config.BreakerSettings, func(ctx context.Context) error {
+		return Ping(ctx, BrokerList())
+	})
+)
+
+// BrokerList returns the Kafka brokers configured in app.env
+func BrokerList() []string {
+	return config.Get().Kafka.Brokers()
+}
+
+// getProducer returns the shared producer, connecting it on first use
+func getProducer() (sarama.SyncProducer, error) {
+	producerMu.Lock()
+	defer producerMu.Unlock()
+	if producer != nil {
+		return producer, nil
+	}
+
+	brokersUrl := BrokerList()
+	slog.Info("Connecting Kafka producer", "brokers", brokersUrl)
+	p, err := ConnectProducer(brokersUrl)
+	if err != nil {
+		return nil, err
+	}
+	producer = p
+	return producer, nil
+}
+
+// Every POST, PUT, DELETE request should publish an event to a Kafka topic.
+// The trace context of ctx travels in the message headers so the consumer continues the trace.
+func PublishEvent(ctx context.Context, topic string, message []byte) (err error) {
+	ctx, span := tracing.Tracer().Start(ctx, topic+" publish",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(semconv.MessagingSystemKafka, semconv.MessagingDestinationName(topic)))
+	defer func() {
+		metrics.ObservePublish(topic, err)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}()
+
+	if err := publishBreaker.Allow(); err != nil {
+		return err
+	}
+	producer, err := getProducer()
 	if err != nil {
+		publishBreaker.Record(err)
 		return err
 	}
-	defer producer.Close()
 	msg := &sarama.ProducerMessage{
 		Topic: topic,
 		Value: sarama.StringEncoder(message),
 	}
+	otel.GetTextMapPropagator().Inject(ctx, tracing.ProducerCarrier{Message: msg})
+	if requestID := logging.RequestID(ctx); requestID != "" {
+		tracing.ProducerCarrier{Message: msg}.Set(logging.RequestIDHeader, requestID)
+	}
 
 	// Send the message to Kafka
 	partition, offset, err := producer.SendMessage(msg)
+	publishBreaker.Record(err)
 	if err != nil {
-		log.Errorf("Error sending message: %v", err)
+		slog.ErrorContext(ctx, "Error sending message", "topic", topic, "error", err)
 		return err
 	}
-	fmt.Printf("Message sent to topic %s, partition %d, offset %d\n", topic, partition, offset)
+	span.SetAttributes(
+		semconv.MessagingDestinationPartitionID(fmt.Sprint(partition)),
+		attribute.Int64("messaging.kafka.offset", offset),
+	)
+	slog.InfoContext(ctx, "Message sent", "topic", topic, "partition", partition, "offset", offset)
+	return nil
+}
+
+// ReconnectProducer is the config.Subscriber connecting a new producer when a reload changes the brokers.
+// Messages being sent finish on the previous producer, which is closed once they are done.
+func ReconnectProducer(old, new *config.Config) error {
+	if slices.Equal(old.Kafka.Brokers(), new.Kafka.Brokers()) {
+		return nil
+	}
+	producerMu.Lock()
+	defer producerMu.Unlock()
+	if producer == nil {
+		// Not connected yet, the first publish connects to the new brokers
+		return nil
+	}
+
+	slog.Info("Connecting Kafka producer", "brokers", new.Kafka.Brokers())
+	p, err := ConnectProducer(new.Kafka.Brokers())
+	if err != nil {
+		return fmt.Errorf("keeping the current Kafka producer: %w", err)
+	}
+	config.Retire("kafka-producer", producer.Close)
+	producer = p
+	return nil
+}
+
+// CloseProducer flushes the messages still buffered by the shared producer and closes it
+func CloseProducer() error {
+	producerMu.Lock()
+	defer producerMu.Unlock()
+	if producer == nil {
+		return nil
+	}
+	err := producer.Close()
+	producer = nil
+	return err
+}
+
+// Ping fetches the metadata of the brokers within the deadline of ctx
+func Ping(ctx context.Context, brokers []string) error {
+	cfg := sarama.NewConfig()
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout := time.Until(deadline)
+		cfg.Net.DialTimeout = timeout
+		cfg.Net.ReadTimeout = timeout
+		cfg.Net.WriteTimeout = timeout
+	}
+	cfg.Metadata.Retry.Max = 0
+
+	client, err := sarama.NewClient(brokers, cfg)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	if len(client.Brokers()) == 0 {
+		return errors.New("no brokers available")
+	}
 	return nil
 }
 
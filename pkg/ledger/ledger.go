@@ -0,0 +1,322 @@
+package ledger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/arepala-uml/books-management-system/pkg/config"
+	"github.com/arepala-uml/books-management-system/pkg/models"
+	"github.com/arepala-uml/books-management-system/pkg/tracing"
+	"go.opentelemetry.io/otel/codes"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrLoanClosed      = errors.New("loan is already closed")
+	ErrInvalidAmount   = errors.New("amount must be greater than zero")
+	ErrExceedsBalance  = errors.New("amount exceeds the outstanding balance")
+	ErrBalanceTooHigh  = errors.New("outstanding balance exceeds the checkout limit")
+	ErrBookUnavailable = errors.New("book is already checked out")
+	ErrBookNotFound    = errors.New("book not found")
+)
+
+const day = 24 * time.Hour
+
+// Balance returns the outstanding balance of a member in minor units
+func Balance(db *gorm.DB, memberID int) (int64, error) {
+	var balance int64
+	err := db.Model(&models.LedgerEntry{}).
+		Where("member_id = ?", memberID).
+		Select("COALESCE(SUM(amount), 0)").
+		Scan(&balance).Error
+	return balance, err
+}
+
+// Statement returns the ledger entries of a member in chronological order
+func Statement(ctx context.Context, db *gorm.DB, memberID int, limit int, offset int) ([]models.LedgerEntry, error) {
+	entries := make([]models.LedgerEntry, 0)
+	err := db.WithContext(ctx).Where("member_id = ?", memberID).
+		Order("created_at, id").
+		Limit(limit).Offset(offset).
+		Find(&entries).Error
+	return entries, err
+}
+
+// FineFor computes the overdue fine owed for a loan at the given time.
+// Every started day past the due date is charged FINE_PER_DAY, capped at FINE_MAX_PER_LOAN.
+// Fines stop accruing once the book is returned or reported lost.
+func FineFor(loan models.Loan, now time.Time) int64 {
+	end := now
+	if loan.ReturnedAt != nil && loan.ReturnedAt.Before(end) {
+		end = *loan.ReturnedAt
+	}
+	if loan.LostAt != nil && loan.LostAt.Before(end) {
+		end = *loan.LostAt
+	}
+	if !end.After(loan.DueAt) {
+		return 0
+	}
+
+	overdue := end.Sub(loan.DueAt)
+	days := int64(overdue / day)
+	if overdue%day != 0 {
+		days++
+	}
+
+	cfg := config.Get().Circulation
+	fine := days * cfg.FinePerDay
+	if maxFine := cfg.FineMaxPerLoan; maxFine > 0 && fine > maxFine {
+		fine = maxFine
+	}
+	return fine
+}
+
+// accrueFine charges the difference between the fine owed for a loan and the fines already charged.
+// It must be called within a transaction holding a lock on the loan row.
+func accrueFine(tx *gorm.DB, loan models.Loan, now time.Time) error {
+	var charged int64
+	err := tx.Model(&models.LedgerEntry{}).
+		Where("loan_id = ? AND type = ?", loan.ID, models.LedgerEntryFine).
+		Select("COALESCE(SUM(amount), 0)").
+		Scan(&charged).Error
+	if err != nil {
+		return err
+	}
+
+	owed := FineFor(loan, now)
+	if owed <= charged {
+		return nil
+	}
+
+	loanID := loan.ID
+	entry := models.LedgerEntry{
+		MemberID:    loan.MemberID,
+		LoanID:      &loanID,
+		Type:        models.LedgerEntryFine,
+		Amount:      owed - charged,
+		Description: fmt.Sprintf("Overdue fine for book %d due %s", loan.BookID, loan.DueAt.Format("2006-01-02")),
+	}
+	return tx.Create(&entry).Error
+}
+
+// lockLoan loads a loan row with FOR UPDATE so concurrent accruals don't double charge
+func lockLoan(tx *gorm.DB, loanID int) (models.Loan, error) {
+	var loan models.Loan
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&loan, loanID).Error
+	return loan, err
+}
+
+// Checkout creates a loan for a member unless the member owes more than CHECKOUT_BALANCE_LIMIT
+// or the book is already out. It returns ErrBookNotFound when the book doesn't exist, and
+// gorm.ErrRecordNotFound when the member doesn't.
+func Checkout(ctx context.Context, db *gorm.DB, bookID int, memberID int) (models.Loan, error) {
+	var loan models.Loan
+	err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// Lock the member row so payments and checkouts for the member are serialized
+		var member models.Member
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&member, memberID).Error; err != nil {
+			return err
+		}
+
+		balance, err := Balance(tx, memberID)
+		if err != nil {
+			return err
+		}
+		if balance > config.Get().Circulation.CheckoutBalanceLimit {
+			return ErrBalanceTooHigh
+		}
+
+		// Lock the book row so concurrent checkouts of the book are serialized, the unique index on
+		// the open loans of a book catches any other writer
+		var book models.Book
+		err = tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&book, bookID).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrBookNotFound
+		} else if err != nil {
+			return err
+		}
+
+		var active int64
+		err = tx.Model(&models.Loan{}).
+			Where("book_id = ? AND returned_at IS NULL AND lost_at IS NULL", bookID).
+			Count(&active).Error
+		if err != nil {
+			return err
+		}
+		if active > 0 {
+			return ErrBookUnavailable
+		}
+
+		now := time.Now()
+		loan = models.Loan{
+			BookID:       bookID,
+			MemberID:     memberID,
+			CheckedOutAt: now,
+			DueAt:        now.AddDate(0, 0, config.Get().Circulation.LoanPeriodDays),
+		}
+		return tx.Create(&loan).Error
+	})
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		return loan, ErrBookUnavailable
+	}
+	return loan, err
+}
+
+// Return closes a loan and charges any overdue fine still owed for it
+func Return(ctx context.Context, db *gorm.DB, loanID int) (models.Loan, error) {
+	var loan models.Loan
+	err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var err error
+		loan, err = lockLoan(tx, loanID)
+		if err != nil {
+			return err
+		}
+		if !loan.Open() {
+			return ErrLoanClosed
+		}
+
+		now := time.Now()
+		loan.ReturnedAt = &now
+		if err := tx.Model(&loan).Update("returned_at", now).Error; err != nil {
+			return err
+		}
+		return accrueFine(tx, loan, now)
+	})
+	return loan, err
+}
+
+// MarkLost closes a loan as lost, charging LOST_ITEM_FEE plus the fine accrued until now
+func MarkLost(ctx context.Context, db *gorm.DB, loanID int) (models.Loan, error) {
+	var loan models.Loan
+	err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var err error
+		loan, err = lockLoan(tx, loanID)
+		if err != nil {
+			return err
+		}
+		if !loan.Open() {
+			return ErrLoanClosed
+		}
+
+		now := time.Now()
+		loan.LostAt = &now
+		if err := tx.Model(&loan).Update("lost_at", now).Error; err != nil {
+			return err
+		}
+		if err := accrueFine(tx, loan, now); err != nil {
+			return err
+		}
+
+		id := loan.ID
+		entry := models.LedgerEntry{
+			MemberID:    loan.MemberID,
+			LoanID:      &id,
+			Type:        models.LedgerEntryLostFee,
+			Amount:      config.Get().Circulation.LostItemFee,
+			Description: fmt.Sprintf("Lost item fee for book %d", loan.BookID),
+		}
+		return tx.Create(&entry).Error
+	})
+	return loan, err
+}
+
+// Credit records a payment or waiver against the balance of a member.
+// The amount is given as a positive number of minor units and stored negated.
+func Credit(ctx context.Context, db *gorm.DB, memberID int, entryType string, amount int64, description string) (models.LedgerEntry, error) {
+	var entry models.LedgerEntry
+	if amount <= 0 {
+		return entry, ErrInvalidAmount
+	}
+	err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var member models.Member
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&member, memberID).Error; err != nil {
+			return err
+		}
+
+		balance, err := Balance(tx, memberID)
+		if err != nil {
+			return err
+		}
+		if amount > balance {
+			return ErrExceedsBalance
+		}
+
+		entry = models.LedgerEntry{
+			MemberID:    memberID,
+			Type:        entryType,
+			Amount:      -amount,
+			Description: description,
+		}
+		return tx.Create(&entry).Error
+	})
+	return entry, err
+}
+
+// AccrueFines charges overdue fines for every open loan past its due date
+func AccrueFines(ctx context.Context, db *gorm.DB, now time.Time) (err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "ledger.AccrueFines")
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}()
+
+	var loanIDs []int
+	err = db.WithContext(ctx).Model(&models.Loan{}).
+		Where("due_at < ? AND returned_at IS NULL AND lost_at IS NULL", now).
+		Pluck("id", &loanIDs).Error
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, loanID := range loanIDs {
+		err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			loan, err := lockLoan(tx, loanID)
+			if err != nil {
+				return err
+			}
+			if !loan.Open() {
+				return nil
+			}
+			return accrueFine(tx, loan, now)
+		})
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to accrue fine for loan", "loan_id", loanID, "error", err)
+			failed++
+		}
+	}
+	slog.InfoContext(ctx, "Fine accrual processed overdue loans", "loans", len(loanIDs), "failures", failed)
+	if failed > 0 {
+		return fmt.Errorf("fine accrual failed for %d of %d loans", failed, len(loanIDs))
+	}
+	return nil
+}
+
+// StartFineAccrual runs AccrueFines immediately and then on every interval until the context is cancelled
+func StartFineAccrual(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		slog.Warn("Fine accrual is disabled as FINE_ACCRUAL_INTERVAL is not set")
+		return
+	}
+	slog.Info("Starting fine accrual job", "interval", interval.String())
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := AccrueFines(ctx, config.GetDB(), time.Now()); err != nil {
+			slog.Error("Error in fine accrual job", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
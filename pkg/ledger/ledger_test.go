@@ -0,0 +1,216 @@
+package ledger
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/arepala-uml/books-management-system/pkg/config"
+	"github.com/arepala-uml/books-management-system/pkg/migrations"
+	"github.com/arepala-uml/books-management-system/pkg/models"
+	"github.com/arepala-uml/books-management-system/pkg/testutil"
+	"gorm.io/gorm"
+)
+
+// setup loads the configuration with the fines of the tests and returns a SQLite database with the
+// tables of the circulation
+func setup(t *testing.T) *gorm.DB {
+	t.Helper()
+	testutil.Config(t, map[string]string{
+		"LOAN_PERIOD_DAYS":       "14",
+		"FINE_PER_DAY":           "25",
+		"FINE_MAX_PER_LOAN":      "1000",
+		"LOST_ITEM_FEE":          "2000",
+		"CHECKOUT_BALANCE_LIMIT": "500",
+	})
+	db := testutil.DB(t, &models.Book{}, &models.Member{}, &models.Loan{}, &models.LedgerEntry{})
+	// The partial unique index on open loans is the same SQL in SQLite
+	all, err := migrations.All()
+	if err != nil {
+		t.Fatalf("reading the migrations: %v", err)
+	}
+	for _, m := range all {
+		if m.Name == "loans_open_book_unique" {
+			if err := db.Exec(m.Up).Error; err != nil {
+				t.Fatalf("creating the open loan index: %v", err)
+			}
+		}
+	}
+	return db
+}
+
+func create(t *testing.T, db *gorm.DB, value interface{}) {
+	t.Helper()
+	if err := db.Create(value).Error; err != nil {
+		t.Fatalf("creating %T: %v", value, err)
+	}
+}
+
+func TestFineFor(t *testing.T) {
+	setup(t)
+	cfg := config.Get().Circulation
+	due := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) *time.Time {
+		ts := due.Add(d)
+		return &ts
+	}
+
+	tests := []struct {
+		name string
+		loan models.Loan
+		now  time.Time
+		want int64
+	}{
+		{"not yet due", models.Loan{DueAt: due}, due.Add(-time.Hour), 0},
+		{"due now", models.Loan{DueAt: due}, due, 0},
+		{"a started day is charged", models.Loan{DueAt: due}, due.Add(time.Minute), cfg.FinePerDay},
+		{"whole days", models.Loan{DueAt: due}, due.Add(3 * day), 3 * cfg.FinePerDay},
+		{"capped", models.Loan{DueAt: due}, due.Add(1000 * day), cfg.FineMaxPerLoan},
+		{"stops when returned", models.Loan{DueAt: due, ReturnedAt: at(2 * day)}, due.Add(10 * day), 2 * cfg.FinePerDay},
+		{"stops when lost", models.Loan{DueAt: due, LostAt: at(day + time.Hour)}, due.Add(10 * day), 2 * cfg.FinePerDay},
+		{"returned on time", models.Loan{DueAt: due, ReturnedAt: at(-day)}, due.Add(10 * day), 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := FineFor(tt.loan, tt.now); got != tt.want {
+				t.Errorf("FineFor() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckout(t *testing.T) {
+	db := setup(t)
+	ctx := context.Background()
+	book := models.Book{Title: "Dune", Author: "Frank Herbert", Year: 1965}
+	create(t, db, &book)
+	member := models.Member{Name: "Ada", Email: "ada@example.com"}
+	create(t, db, &member)
+	other := models.Member{Name: "Kofi", Email: "kofi@example.com"}
+	create(t, db, &other)
+
+	loan, err := Checkout(ctx, db, book.ID, member.ID)
+	if err != nil {
+		t.Fatalf("Checkout() error = %v", err)
+	}
+	if !loan.Open() || loan.BookID != book.ID || loan.MemberID != member.ID {
+		t.Errorf("Checkout() = %+v, want an open loan of book %d to member %d", loan, book.ID, member.ID)
+	}
+	period := loan.DueAt.Sub(loan.CheckedOutAt)
+	if want := time.Duration(config.Get().Circulation.LoanPeriodDays) * day; period < want-time.Hour || period > want+time.Hour {
+		t.Errorf("loan period = %s, want %s", period, want)
+	}
+
+	if _, err := Checkout(ctx, db, book.ID, other.ID); !errors.Is(err, ErrBookUnavailable) {
+		t.Errorf("Checkout() of a book out on loan error = %v, want %v", err, ErrBookUnavailable)
+	}
+	if _, err := Checkout(ctx, db, book.ID+100, member.ID); !errors.Is(err, ErrBookNotFound) {
+		t.Errorf("Checkout() of a missing book error = %v, want %v", err, ErrBookNotFound)
+	}
+	if _, err := Checkout(ctx, db, book.ID, other.ID+100); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("Checkout() for a missing member error = %v, want %v", err, gorm.ErrRecordNotFound)
+	}
+
+	// Once returned, the book can be checked out again
+	if _, err := Return(ctx, db, loan.ID); err != nil {
+		t.Fatalf("Return() error = %v", err)
+	}
+	if _, err := Return(ctx, db, loan.ID); !errors.Is(err, ErrLoanClosed) {
+		t.Errorf("Return() of a returned loan error = %v, want %v", err, ErrLoanClosed)
+	}
+	if _, err := Checkout(ctx, db, book.ID, other.ID); err != nil {
+		t.Errorf("Checkout() of a returned book error = %v", err)
+	}
+}
+
+func TestCheckoutBalanceLimit(t *testing.T) {
+	db := setup(t)
+	book := models.Book{Title: "Emma", Author: "Jane Austen", Year: 1815}
+	create(t, db, &book)
+	member := models.Member{Name: "Mei", Email: "mei@example.com"}
+	create(t, db, &member)
+	create(t, db, &models.LedgerEntry{MemberID: member.ID, Type: models.LedgerEntryFine,
+		Amount: config.Get().Circulation.CheckoutBalanceLimit + 1})
+
+	if _, err := Checkout(context.Background(), db, book.ID, member.ID); !errors.Is(err, ErrBalanceTooHigh) {
+		t.Errorf("Checkout() error = %v, want %v", err, ErrBalanceTooHigh)
+	}
+}
+
+func TestOpenLoanIndex(t *testing.T) {
+	db := setup(t)
+	now := time.Now()
+	create(t, db, &models.Loan{BookID: 1, MemberID: 1, CheckedOutAt: now, DueAt: now})
+	create(t, db, &models.Loan{BookID: 1, MemberID: 2, CheckedOutAt: now, DueAt: now, ReturnedAt: &now})
+
+	// A writer missing the check of Checkout still can't put a book on two open loans
+	err := db.Create(&models.Loan{BookID: 1, MemberID: 3, CheckedOutAt: now, DueAt: now}).Error
+	if !errors.Is(err, gorm.ErrDuplicatedKey) {
+		t.Errorf("creating a second open loan error = %v, want %v", err, gorm.ErrDuplicatedKey)
+	}
+}
+
+func TestFines(t *testing.T) {
+	db := setup(t)
+	ctx := context.Background()
+	cfg := config.Get().Circulation
+	member := models.Member{Name: "Lucas", Email: "lucas@example.com"}
+	create(t, db, &member)
+	now := time.Now()
+	late := models.Loan{BookID: 1, MemberID: member.ID, CheckedOutAt: now.Add(-20 * day), DueAt: now.Add(-3*day + time.Hour)}
+	create(t, db, &late)
+	lost := models.Loan{BookID: 2, MemberID: member.ID, CheckedOutAt: now.Add(-20 * day), DueAt: now.Add(-day + time.Hour)}
+	create(t, db, &lost)
+
+	balance := func() int64 {
+		t.Helper()
+		b, err := Balance(db, member.ID)
+		if err != nil {
+			t.Fatalf("Balance() error = %v", err)
+		}
+		return b
+	}
+
+	// Accruing again charges nothing more for the same day
+	for range 2 {
+		if err := AccrueFines(ctx, db, now); err != nil {
+			t.Fatalf("AccrueFines() error = %v", err)
+		}
+	}
+	if got, want := balance(), 3*cfg.FinePerDay+cfg.FinePerDay; got != want {
+		t.Errorf("balance after accrual = %d, want %d", got, want)
+	}
+
+	// Returning a day later charges the day accrued since
+	if err := db.Model(&late).Update("due_at", late.DueAt.Add(-day)).Error; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Return(ctx, db, late.ID); err != nil {
+		t.Fatalf("Return() error = %v", err)
+	}
+	if got, want := balance(), 4*cfg.FinePerDay+cfg.FinePerDay; got != want {
+		t.Errorf("balance after return = %d, want %d", got, want)
+	}
+
+	if _, err := MarkLost(ctx, db, lost.ID); err != nil {
+		t.Fatalf("MarkLost() error = %v", err)
+	}
+	if got, want := balance(), 5*cfg.FinePerDay+cfg.LostItemFee; got != want {
+		t.Errorf("balance after loss = %d, want %d", got, want)
+	}
+
+	// Credits can't exceed the balance
+	if _, err := Credit(ctx, db, member.ID, models.LedgerEntryPayment, balance()+1, "Too much"); !errors.Is(err, ErrExceedsBalance) {
+		t.Errorf("Credit() above the balance error = %v, want %v", err, ErrExceedsBalance)
+	}
+	if _, err := Credit(ctx, db, member.ID, models.LedgerEntryPayment, 0, "Nothing"); !errors.Is(err, ErrInvalidAmount) {
+		t.Errorf("Credit() of 0 error = %v, want %v", err, ErrInvalidAmount)
+	}
+	if _, err := Credit(ctx, db, member.ID, models.LedgerEntryWaiver, balance(), "Waived"); err != nil {
+		t.Fatalf("Credit() error = %v", err)
+	}
+	if got := balance(); got != 0 {
+		t.Errorf("balance after waiver = %d, want 0", got)
+	}
+}
@@ -0,0 +1,95 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// hook is a shutdown step, run with its own timeout
+type hook struct {
+	name    string
+	timeout time.Duration
+	stop    func(ctx context.Context) error
+}
+
+// Lifecycle runs the background workers of the application and stops them, together with
+// the servers and connections registered with OnStop, when the application shuts down
+type Lifecycle struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	workers sync.WaitGroup
+	hooks   []hook
+}
+
+// New returns a Lifecycle whose workers run until Shutdown
+func New() *Lifecycle {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Lifecycle{ctx: ctx, cancel: cancel}
+}
+
+// Go runs a background worker. The context passed to the worker is cancelled by StopWorkers,
+// and the worker is expected to return soon after.
+func (l *Lifecycle) Go(name string, worker func(ctx context.Context)) {
+	l.workers.Add(1)
+	go func() {
+		defer l.workers.Done()
+		worker(l.ctx)
+		slog.Info("Background worker stopped", "worker", name)
+	}()
+}
+
+// OnStop registers a shutdown step. Steps run in registration order during Shutdown.
+func (l *Lifecycle) OnStop(name string, timeout time.Duration, stop func(ctx context.Context) error) {
+	l.hooks = append(l.hooks, hook{name: name, timeout: timeout, stop: stop})
+}
+
+// StopWorkers cancels the context of the background workers and waits for them to return
+func (l *Lifecycle) StopWorkers(ctx context.Context) error {
+	l.cancel()
+	done := make(chan struct{})
+	go func() {
+		l.workers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return errors.New("background workers did not stop in time")
+	}
+}
+
+// Shutdown runs every shutdown step in order, each within its own timeout and all within
+// the overall timeout. A step that fails or times out doesn't prevent the following ones.
+func (l *Lifecycle) Shutdown(timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var errs []error
+	for _, h := range l.hooks {
+		stepCtx, stepCancel := context.WithTimeout(ctx, h.timeout)
+		start := time.Now()
+		done := make(chan error, 1)
+		go func() { done <- h.stop(stepCtx) }()
+
+		var err error
+		select {
+		case err = <-done:
+		case <-stepCtx.Done():
+			err = stepCtx.Err()
+		}
+		stepCancel()
+
+		if err != nil {
+			slog.Error("Shutdown step failed", "step", h.name, "duration_ms", time.Since(start).Milliseconds(), "error", err)
+			errs = append(errs, fmt.Errorf("%s: %w", h.name, err))
+			continue
+		}
+		slog.Info("Shutdown step completed", "step", h.name, "duration_ms", time.Since(start).Milliseconds())
+	}
+	return errors.Join(errs...)
+}
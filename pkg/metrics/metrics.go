@@ -0,0 +1,142 @@
+package metrics
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "books"
+
+// Results recorded by the cache and Kafka counters
+const (
+	CacheHit   = "hit"
+	CacheMiss  = "miss"
+	CacheError = "error"
+	// CacheNegativeHit is a lookup answered by the cached knowledge that the book doesn't exist
+	CacheNegativeHit = "negative_hit"
+	PublishSuccess   = "success"
+	PublishFailure   = "failure"
+)
+
+// Tiers of the book cache, the in-process L1 cache in front of Redis
+const (
+	CacheTierLocal = "l1"
+	CacheTierRedis = "l2"
+)
+
+var (
+	httpRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Latency of HTTP requests by method, route and status.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	cacheRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cache_requests_total",
+		Help:      "Cache lookups by operation and result (hit, miss, error, negative_hit).",
+	}, []string{"operation", "result"})
+
+	cacheTierRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cache_tier_requests_total",
+		Help:      "Book lookups by cache tier (l1, l2) and result (hit, miss, error).",
+	}, []string{"tier", "result"})
+
+	cacheInconsistencies = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cache_inconsistencies_total",
+		Help:      "Cached books found by the consistency check to differ from Postgres, by kind (stale, orphaned).",
+	}, []string{"kind"})
+
+	dbQueryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "db_query_duration_seconds",
+		Help:      "Latency of gorm queries by operation and table.",
+		Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+	}, []string{"operation", "table"})
+
+	kafkaPublished = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "kafka_messages_published_total",
+		Help:      "Messages published to Kafka by topic and result (success, failure).",
+	}, []string{"topic", "result"})
+
+	circuitOpen = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "circuit_breaker_open",
+		Help:      "Whether the circuit breaker of a dependency is open (1) or closed (0).",
+	}, []string{"dependency"})
+
+	kafkaConsumerLag = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "kafka_consumer_lag",
+		Help:      "Messages behind the high water mark by topic and partition.",
+	}, []string{"topic", "partition"})
+)
+
+// Handler serves the metrics in the Prometheus exposition format
+func Handler() gin.HandlerFunc {
+	return gin.WrapH(promhttp.Handler())
+}
+
+// Middleware is a gin middleware recording the latency of every request by its route template,
+// so /books/1 and /books/2 share the /books/:id series
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		httpRequestDuration.
+			WithLabelValues(c.Request.Method, route, strconv.Itoa(c.Writer.Status())).
+			Observe(time.Since(start).Seconds())
+	}
+}
+
+// ObserveCache counts a cache lookup of the operation with its result
+func ObserveCache(operation string, result string) {
+	cacheRequests.WithLabelValues(operation, result).Inc()
+}
+
+// ObserveCacheTier counts a book lookup in a tier of the cache with its result
+func ObserveCacheTier(tier string, result string) {
+	cacheTierRequests.WithLabelValues(tier, result).Inc()
+}
+
+// ObserveCacheInconsistencies counts the cached books of a kind found to differ from Postgres
+func ObserveCacheInconsistencies(kind string, count int) {
+	cacheInconsistencies.WithLabelValues(kind).Add(float64(count))
+}
+
+// ObservePublish counts a message published to a Kafka topic
+func ObservePublish(topic string, err error) {
+	result := PublishSuccess
+	if err != nil {
+		result = PublishFailure
+	}
+	kafkaPublished.WithLabelValues(topic, result).Inc()
+}
+
+// SetCircuitOpen records whether the circuit breaker of a dependency is open
+func SetCircuitOpen(dependency string, open bool) {
+	value := 0.0
+	if open {
+		value = 1
+	}
+	circuitOpen.WithLabelValues(dependency).Set(value)
+}
+
+// SetConsumerLag records how far the consumer is behind on a partition
+func SetConsumerLag(topic string, partition int32, lag int64) {
+	kafkaConsumerLag.WithLabelValues(topic, strconv.Itoa(int(partition))).Set(float64(lag))
+}
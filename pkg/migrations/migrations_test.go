@@ -0,0 +1,75 @@
+package migrations
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestAll(t *testing.T) {
+	all, err := All()
+	if err != nil {
+		t.Fatalf("All() error = %v", err)
+	}
+	want := []struct {
+		version int64
+		name    string
+		down    bool
+	}{
+		{1, "initial_schema", false},
+		{2, "loans_open_book_unique", true},
+		{3, "members_user_id", true},
+	}
+	if len(all) != len(want) {
+		t.Fatalf("All() returned %d migrations, want %d", len(all), len(want))
+	}
+	for i, w := range want {
+		m := all[i]
+		if m.Version != w.version || m.Name != w.name {
+			t.Errorf("migration %d = %d_%s, want %d_%s", i, m.Version, m.Name, w.version, w.name)
+		}
+		if m.Up == "" {
+			t.Errorf("migration %d_%s has no up SQL", m.Version, m.Name)
+		}
+		if (m.Down != "") != w.down {
+			t.Errorf("migration %d_%s has down SQL %t, want %t", m.Version, m.Name, m.Down != "", w.down)
+		}
+	}
+
+	// Tables created by AutoMigrate before reviews existed gain the rating columns
+	for _, column := range []string{"average_rating", "rating_count"} {
+		if !strings.Contains(all[0].Up, "ADD COLUMN IF NOT EXISTS "+column) {
+			t.Errorf("the initial schema doesn't add %s to existing books tables", column)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	file := func(sql string) *fstest.MapFile { return &fstest.MapFile{Data: []byte(sql)} }
+
+	all, err := load(fstest.MapFS{
+		"sql/0010_later.up.sql":    file("SELECT 10"),
+		"sql/0002_second.up.sql":   file("SELECT 2"),
+		"sql/0002_second.down.sql": file("SELECT -2"),
+	})
+	if err != nil {
+		t.Fatalf("load() error = %v", err)
+	}
+	if len(all) != 2 || all[0].Version != 2 || all[1].Version != 10 {
+		t.Fatalf("load() = %+v, want versions 2 and 10 in order", all)
+	}
+	if all[0].Up != "SELECT 2" || all[0].Down != "SELECT -2" || all[1].Down != "" {
+		t.Errorf("load() = %+v, want the SQL of each file", all)
+	}
+
+	tests := map[string]fstest.MapFS{
+		"misnamed file":   {"sql/0001_first.sql": file("SELECT 1")},
+		"name conflict":   {"sql/0001_first.up.sql": file("SELECT 1"), "sql/0001_other.down.sql": file("SELECT -1")},
+		"down without up": {"sql/0001_first.down.sql": file("SELECT -1")},
+	}
+	for name, fsys := range tests {
+		if _, err := load(fsys); err == nil {
+			t.Errorf("load() of a %s returned no error", name)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+-- Baseline schema, as created by gorm AutoMigrate before migrations existed.
+-- IF NOT EXISTS lets databases created by AutoMigrate adopt it, and the columns added to an
+-- existing table since its creation are added when missing. There is no down migration: rolling
+-- back the baseline would drop every table and its data.
+
+CREATE TABLE IF NOT EXISTS books (
+    id             bigserial PRIMARY KEY,
+    title          text,
+    author         text,
+    year           bigint,
+    average_rating decimal NOT NULL DEFAULT 0,
+    rating_count   bigint NOT NULL DEFAULT 0
+);
+-- Books created by AutoMigrate before reviews existed have no rating aggregates
+ALTER TABLE books ADD COLUMN IF NOT EXISTS average_rating decimal NOT NULL DEFAULT 0;
+ALTER TABLE books ADD COLUMN IF NOT EXISTS rating_count bigint NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS members (
+    id         bigserial PRIMARY KEY,
+    name       text,
+    email      text,
+    created_at timestamptz
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_members_email ON members (email);
+
+CREATE TABLE IF NOT EXISTS loans (
+    id             bigserial PRIMARY KEY,
+    book_id        bigint NOT NULL,
+    member_id      bigint NOT NULL,
+    checked_out_at timestamptz,
+    due_at         timestamptz,
+    returned_at    timestamptz,
+    lost_at        timestamptz
+);
+CREATE INDEX IF NOT EXISTS idx_loans_book_id ON loans (book_id);
+CREATE INDEX IF NOT EXISTS idx_loans_member_id ON loans (member_id);
+CREATE INDEX IF NOT EXISTS idx_loans_due_at ON loans (due_at);
+
+CREATE TABLE IF NOT EXISTS ledger_entries (
+    id          bigserial PRIMARY KEY,
+    member_id   bigint NOT NULL,
+    loan_id     bigint,
+    type        text NOT NULL,
+    amount      bigint NOT NULL,
+    description text,
+    created_at  timestamptz
+);
+CREATE INDEX IF NOT EXISTS idx_ledger_entries_member_id ON ledger_entries (member_id);
+CREATE INDEX IF NOT EXISTS idx_ledger_entries_loan_id ON ledger_entries (loan_id);
+
+CREATE TABLE IF NOT EXISTS reviews (
+    id         bigserial PRIMARY KEY,
+    book_id    bigint NOT NULL,
+    member_id  bigint NOT NULL,
+    rating     bigint NOT NULL,
+    text       text,
+    status     text NOT NULL DEFAULT 'pending',
+    created_at timestamptz,
+    updated_at timestamptz
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_reviews_book_member ON reviews (book_id, member_id);
+CREATE INDEX IF NOT EXISTS idx_reviews_status ON reviews (status);
+
+CREATE TABLE IF NOT EXISTS users (
+    id            bigserial PRIMARY KEY,
+    username      text NOT NULL,
+    password_hash text NOT NULL,
+    created_at    timestamptz,
+    updated_at    timestamptz
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_username ON users (username);
+
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+    id         bigserial PRIMARY KEY,
+    user_id    bigint NOT NULL,
+    token_hash text NOT NULL,
+    expires_at timestamptz,
+    revoked_at timestamptz,
+    created_at timestamptz
+);
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_user_id ON refresh_tokens (user_id);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_refresh_tokens_token_hash ON refresh_tokens (token_hash);
+
+CREATE TABLE IF NOT EXISTS user_roles (
+    user_id    bigint,
+    role       text,
+    created_at timestamptz,
+    PRIMARY KEY (user_id, role)
+);
+
+CREATE TABLE IF NOT EXISTS api_keys (
+    id           bigserial PRIMARY KEY,
+    name         text NOT NULL,
+    prefix       text NOT NULL,
+    key_hash     text NOT NULL,
+    scopes       text NOT NULL,
+    created_by   bigint,
+    expires_at   timestamptz,
+    last_used_at timestamptz,
+    revoked_at   timestamptz,
+    created_at   timestamptz
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_api_keys_key_hash ON api_keys (key_hash);
+CREATE INDEX IF NOT EXISTS idx_api_keys_created_by ON api_keys (created_by);
@@ -0,0 +1,4 @@
+-- A book can be out on a single open loan. Creating the index fails while a book has several open
+-- loans, close the extra ones first.
+CREATE UNIQUE INDEX IF NOT EXISTS idx_loans_open_book ON loans (book_id)
+    WHERE returned_at IS NULL AND lost_at IS NULL;
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_members_user_id;
+ALTER TABLE members DROP COLUMN IF EXISTS user_id;
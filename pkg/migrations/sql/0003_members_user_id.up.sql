@@ -0,0 +1,3 @@
+-- Links a member to the user account it signs in with, reviews are posted as the member of the user
+ALTER TABLE members ADD COLUMN IF NOT EXISTS user_id bigint;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_members_user_id ON members (user_id);
@@ -0,0 +1,24 @@
+package models
+
+import "time"
+
+// Scopes that can be granted to an API key
+const (
+	APIKeyScopeRead  = "read"
+	APIKeyScopeWrite = "write"
+)
+
+// APIKey is a credential for non-interactive clients, sent in the X-API-Key header.
+// Only the SHA-256 hash of the key is stored; Prefix identifies the key in listings.
+type APIKey struct {
+	ID         int        `json:"id" gorm:"primaryKey"`
+	Name       string     `json:"name" gorm:"not null"`
+	Prefix     string     `json:"prefix" gorm:"not null"`
+	KeyHash    string     `json:"-" gorm:"uniqueIndex;not null"`
+	Scopes     []string   `json:"scopes" gorm:"serializer:json;not null"`
+	CreatedBy  int        `json:"created_by" gorm:"index"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
@@ -1,12 +1,12 @@
 package models
 
-import "gorm.io/gorm"
-
-var DB *gorm.DB
-
 type Book struct {
 	ID     int    `json:"id" gorm:"primaryKey"`
 	Title  string `json:"title" binding:"required"`
 	Author string `json:"author" binding:"required"`
 	Year   int    `json:"year" binding:"required"`
+
+	// Aggregates over approved reviews, maintained by the reviews package
+	AverageRating float64 `json:"average_rating" gorm:"not null;default:0"`
+	RatingCount   int     `json:"rating_count" gorm:"not null;default:0"`
 }
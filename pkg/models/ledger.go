@@ -0,0 +1,24 @@
+package models
+
+import "time"
+
+// Ledger entry types. Fines and lost-item fees are charges, payments and waivers are credits.
+const (
+	LedgerEntryFine    = "fine"
+	LedgerEntryLostFee = "lost_fee"
+	LedgerEntryPayment = "payment"
+	LedgerEntryWaiver  = "waiver"
+)
+
+// LedgerEntry is a single charge or credit on a member account.
+// Amount is in minor units (e.g. cents): charges are positive and credits negative,
+// so the balance of a member is the sum of all of its entries.
+type LedgerEntry struct {
+	ID          int       `json:"id" gorm:"primaryKey"`
+	MemberID    int       `json:"member_id" gorm:"index;not null"`
+	LoanID      *int      `json:"loan_id,omitempty" gorm:"index"`
+	Type        string    `json:"type" gorm:"not null"`
+	Amount      int64     `json:"amount" gorm:"not null"`
+	Description string    `json:"description"`
+	CreatedAt   time.Time `json:"created_at"`
+}
@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+// Loan is the borrowing record of a book by a member
+type Loan struct {
+	ID           int        `json:"id" gorm:"primaryKey"`
+	BookID       int        `json:"book_id" gorm:"index;not null"`
+	MemberID     int        `json:"member_id" gorm:"index;not null"`
+	CheckedOutAt time.Time  `json:"checked_out_at"`
+	DueAt        time.Time  `json:"due_at" gorm:"index"`
+	ReturnedAt   *time.Time `json:"returned_at,omitempty"`
+	LostAt       *time.Time `json:"lost_at,omitempty"`
+}
+
+// Open reports whether the book is still out with the member
+func (l Loan) Open() bool {
+	return l.ReturnedAt == nil && l.LostAt == nil
+}
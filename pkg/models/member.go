@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// Member is a library patron who can borrow books and owes fines
+type Member struct {
+	ID    int    `json:"id" gorm:"primaryKey"`
+	Name  string `json:"name" binding:"required"`
+	Email string `json:"email" binding:"required,email" gorm:"uniqueIndex"`
+	// UserID is the account the member signs in with, reviews posted by that account are the member's
+	UserID    *int      `json:"user_id,omitempty" gorm:"uniqueIndex"`
+	CreatedAt time.Time `json:"created_at"`
+}
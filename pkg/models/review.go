@@ -0,0 +1,28 @@
+package models
+
+import "time"
+
+// Moderation states of a review. Only approved reviews are listed publicly and counted in book ratings.
+const (
+	ReviewPending  = "pending"
+	ReviewApproved = "approved"
+	ReviewRejected = "rejected"
+)
+
+// Review is a star rating with an optional text left by a member on a book.
+// A member can review a book only once.
+type Review struct {
+	ID        int       `json:"id" gorm:"primaryKey"`
+	BookID    int       `json:"book_id" gorm:"not null;uniqueIndex:idx_reviews_book_member"`
+	MemberID  int       `json:"member_id" gorm:"not null;uniqueIndex:idx_reviews_book_member"`
+	Rating    int       `json:"rating" binding:"required,gte=1,lte=5" gorm:"not null"`
+	Text      string    `json:"text" binding:"max=5000"`
+	Status    string    `json:"status" gorm:"not null;default:pending;index"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// ValidReviewStatus reports whether status is one of the moderation states
+func ValidReviewStatus(status string) bool {
+	return status == ReviewPending || status == ReviewApproved || status == ReviewRejected
+}
@@ -0,0 +1,30 @@
+package models
+
+import "time"
+
+// User is a local account that can sign in to the API
+type User struct {
+	ID           int       `json:"id" gorm:"primaryKey"`
+	Username     string    `json:"username" gorm:"uniqueIndex;not null"`
+	PasswordHash string    `json:"-" gorm:"not null"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// RefreshToken is an opaque, single-use token exchanged for a new access token.
+// Only the SHA-256 hash of the token is stored.
+type RefreshToken struct {
+	ID        int        `json:"id" gorm:"primaryKey"`
+	UserID    int        `json:"user_id" gorm:"index;not null"`
+	TokenHash string     `json:"-" gorm:"uniqueIndex;not null"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// UserRole assigns a role to a user. The permissions of each role are defined in the auth package.
+type UserRole struct {
+	UserID    int       `json:"user_id" gorm:"primaryKey"`
+	Role      string    `json:"role" gorm:"primaryKey"`
+	CreatedAt time.Time `json:"created_at"`
+}
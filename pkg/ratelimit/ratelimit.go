@@ -0,0 +1,328 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/arepala-uml/books-management-system/pkg/auth"
+	"github.com/arepala-uml/books-management-system/pkg/config"
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+)
+
+// Limit allows Requests per Window for a single principal
+type Limit struct {
+	Requests int
+	Window   time.Duration
+}
+
+// policy is the set of limits built from app.env
+type policy struct {
+	enabled bool
+	// routes maps "METHOD /path" to the limit of that route
+	routes map[string]Limit
+	// principals maps a principal kind (ip, user, api_key) to its default limit
+	principals map[string]Limit
+	fallback   Limit
+	// authFailures is the limit of failed authentications per client IP
+	authFailures Limit
+}
+
+var current atomic.Pointer[policy]
+
+// slidingWindow records the request in a sorted set of request timestamps, after dropping those older
+// than the window, unless the window is already full. It returns whether the request is allowed,
+// the requests remaining and the milliseconds until the oldest request leaves the window.
+var slidingWindow = redis.NewScript(`
+local key = KEYS[1]
+local now = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local limit = tonumber(ARGV[3])
+
+redis.call('ZREMRANGEBYSCORE', key, '-inf', now - window)
+local count = redis.call('ZCARD', key)
+local allowed = 0
+if count < limit then
+	redis.call('ZADD', key, now, ARGV[4])
+	redis.call('PEXPIRE', key, window)
+	count = count + 1
+	allowed = 1
+end
+
+local reset = window
+local oldest = redis.call('ZRANGE', key, 0, 0, 'WITHSCORES')
+if oldest[2] then
+	reset = tonumber(oldest[2]) + window - now
+end
+return {allowed, limit - count, reset}
+`)
+
+// windowCount drops the entries older than the window from a sliding window without recording a request.
+// It returns the entries left and the milliseconds until the oldest leaves the window.
+var windowCount = redis.NewScript(`
+local key = KEYS[1]
+local now = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+
+redis.call('ZREMRANGEBYSCORE', key, '-inf', now - window)
+local count = redis.call('ZCARD', key)
+local reset = window
+local oldest = redis.call('ZRANGE', key, 0, 0, 'WITHSCORES')
+if oldest[2] then
+	reset = tonumber(oldest[2]) + window - now
+end
+return {count, reset}
+`)
+
+// Reject a configuration whose limits don't parse, at startup and on reload
+func init() {
+	config.AddValidator(func(cfg *config.Config) error {
+		_, err := newPolicy(cfg.RateLimit)
+		return err
+	})
+}
+
+// Init builds the rate limits from RATE_LIMIT_ENABLED, RATE_LIMIT_DEFAULT, RATE_LIMIT_IP,
+// RATE_LIMIT_USER, RATE_LIMIT_API_KEY, RATE_LIMIT_ROUTES and RATE_LIMIT_AUTH_FAILURES
+func Init() error {
+	p, err := newPolicy(config.Get().RateLimit)
+	if err != nil {
+		return err
+	}
+	current.Store(p)
+	slog.Info("Rate limiting configured", "enabled", p.enabled, "default_requests", p.fallback.Requests,
+		"default_window", p.fallback.Window.String(), "route_limits", len(p.routes),
+		"auth_failures", p.authFailures.Requests, "auth_failures_window", p.authFailures.Window.String())
+	return nil
+}
+
+// Reload is the config.Subscriber applying changed rate limits to the requests that follow
+func Reload(old, new *config.Config) error {
+	if old.RateLimit == new.RateLimit {
+		return nil
+	}
+	return Init()
+}
+
+func newPolicy(cfg config.RateLimitConfig) (*policy, error) {
+	p := &policy{
+		enabled:    cfg.Enabled,
+		routes:     make(map[string]Limit),
+		principals: make(map[string]Limit),
+	}
+
+	var err error
+	if p.fallback, err = ParseLimit(cfg.Default); err != nil {
+		return nil, fmt.Errorf("invalid RATE_LIMIT_DEFAULT: %w", err)
+	}
+	if p.authFailures, err = ParseLimit(cfg.AuthFailures); err != nil {
+		return nil, fmt.Errorf("invalid RATE_LIMIT_AUTH_FAILURES: %w", err)
+	}
+	principals := []struct{ kind, setting, value string }{
+		{"ip", "RATE_LIMIT_IP", cfg.IP},
+		{"user", "RATE_LIMIT_USER", cfg.User},
+		{"api_key", "RATE_LIMIT_API_KEY", cfg.APIKey},
+	}
+	for _, principal := range principals {
+		if principal.value == "" {
+			continue
+		}
+		if p.principals[principal.kind], err = ParseLimit(principal.value); err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", principal.setting, err)
+		}
+	}
+
+	// RATE_LIMIT_ROUTES is a comma separated list of "METHOD /path=requests/window"
+	for _, rule := range strings.Split(cfg.Routes, ",") {
+		rule = strings.TrimSpace(rule)
+		if rule == "" {
+			continue
+		}
+		route, value, found := strings.Cut(rule, "=")
+		if !found {
+			return nil, fmt.Errorf("invalid RATE_LIMIT_ROUTES rule %q, expected METHOD /path=requests/window", rule)
+		}
+		limit, err := ParseLimit(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RATE_LIMIT_ROUTES rule %q: %w", rule, err)
+		}
+		p.routes[strings.Join(strings.Fields(route), " ")] = limit
+	}
+	return p, nil
+}
+
+// ParseLimit parses a limit written as "requests/window", e.g. "100/1m"
+func ParseLimit(value string) (Limit, error) {
+	requests, window, found := strings.Cut(strings.TrimSpace(value), "/")
+	if !found {
+		return Limit{}, fmt.Errorf("%q is not of the form requests/window", value)
+	}
+	n, err := strconv.Atoi(requests)
+	if err != nil || n <= 0 {
+		return Limit{}, fmt.Errorf("%q has an invalid number of requests", value)
+	}
+	d, err := time.ParseDuration(window)
+	if err != nil || d < time.Millisecond {
+		return Limit{}, fmt.Errorf("%q has an invalid window", value)
+	}
+	return Limit{Requests: n, Window: d}, nil
+}
+
+// principalKey identifies who the limit applies to: the API key, the user or the client IP
+func principalKey(c *gin.Context) (string, string) {
+	if principal, ok := auth.GetPrincipal(c); ok {
+		if principal.APIKeyID != 0 {
+			return "api_key", strconv.Itoa(principal.APIKeyID)
+		}
+		return "user", strconv.Itoa(principal.UserID)
+	}
+	return "ip", c.ClientIP()
+}
+
+func (p *policy) limitFor(route string, kind string) Limit {
+	if limit, ok := p.routes[route]; ok {
+		return limit
+	}
+	if limit, ok := p.principals[kind]; ok {
+		return limit
+	}
+	return p.fallback
+}
+
+// Middleware is a gin middleware enforcing a sliding window rate limit per route and principal,
+// shared by every instance through Redis. When Redis is unavailable requests are let through.
+// It must run after auth.Authenticate to limit by user or API key instead of IP.
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		p := current.Load()
+		if p == nil || !p.enabled {
+			c.Next()
+			return
+		}
+
+		route := c.Request.Method + " " + c.FullPath()
+		kind, id := principalKey(c)
+		limit := p.limitFor(route, kind)
+		key := config.RedisKey(fmt.Sprintf("RATE_LIMIT:%s:%s:%s", route, kind, id))
+		if !enforce(c, key, limit, "limit_route", route, "principal_kind", kind, "principal", id) {
+			return
+		}
+		c.Next()
+	}
+}
+
+// IPMiddleware is a gin middleware running before auth.Authenticate. It rejects the client IPs that
+// failed to authenticate RATE_LIMIT_AUTH_FAILURES times in the window, limits every request of a client
+// IP to RATE_LIMIT_IP (RATE_LIMIT_DEFAULT when unset) whatever the route, and records the requests
+// rejected with 401 Unauthorized as failed authentications, so guessing API keys, tokens or passwords
+// is limited. When Redis is unavailable requests are let through.
+func IPMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		p := current.Load()
+		if p == nil || !p.enabled {
+			c.Next()
+			return
+		}
+
+		ip := c.ClientIP()
+		failuresKey := config.RedisKey("RATE_LIMIT_AUTH_FAILURES:" + ip)
+		if blocked(c, failuresKey, p.authFailures) {
+			return
+		}
+		limit, ok := p.principals["ip"]
+		if !ok {
+			limit = p.fallback
+		}
+		if !enforce(c, config.RedisKey("RATE_LIMIT:ip:"+ip), limit, "principal_kind", "ip", "principal", ip) {
+			return
+		}
+
+		c.Next()
+		if c.Writer.Status() == http.StatusUnauthorized {
+			recordFailure(c, failuresKey, p.authFailures)
+		}
+	}
+}
+
+// enforce records the request in the sliding window at key and sets the RateLimit headers. It returns
+// false after rejecting the request with 429 Too Many Requests when the window is full.
+func enforce(c *gin.Context, key string, limit Limit, attrs ...any) bool {
+	now := time.Now()
+	result, err := slidingWindow.Run(context.Background(), config.GetRedisClient(), []string{key},
+		now.UnixMilli(), limit.Window.Milliseconds(), limit.Requests,
+		fmt.Sprintf("%d-%d", now.UnixNano(), rand.Int63())).Int64Slice()
+	if err != nil || len(result) != 3 {
+		// Fail open, Postgres is still protected by the cache
+		slog.ErrorContext(c.Request.Context(), "Rate limiter unavailable, allowing request", append(attrs, "error", err)...)
+		return true
+	}
+
+	allowed, remaining, resetMillis := result[0] == 1, result[1], result[2]
+	resetSeconds := ceilSeconds(resetMillis)
+	c.Header("RateLimit-Limit", strconv.Itoa(limit.Requests))
+	c.Header("RateLimit-Remaining", strconv.FormatInt(remaining, 10))
+	c.Header("RateLimit-Reset", strconv.Itoa(resetSeconds))
+	c.Header("RateLimit-Policy", fmt.Sprintf("%d;w=%d", limit.Requests, int(limit.Window.Seconds())))
+
+	if !allowed {
+		slog.InfoContext(c.Request.Context(), "Rate limit exceeded",
+			append([]any{"limit", limit.Requests, "window", limit.Window.String()}, attrs...)...)
+		tooManyRequests(c, resetSeconds,
+			fmt.Sprintf("limit of %d requests per %s exceeded, retry in %d seconds", limit.Requests, limit.Window, resetSeconds))
+		return false
+	}
+	return true
+}
+
+// blocked returns true after rejecting the request with 429 Too Many Requests when the failed
+// authentications of its client IP at key reached the limit
+func blocked(c *gin.Context, key string, limit Limit) bool {
+	result, err := windowCount.Run(context.Background(), config.GetRedisClient(), []string{key},
+		time.Now().UnixMilli(), limit.Window.Milliseconds()).Int64Slice()
+	if err != nil || len(result) != 2 {
+		slog.ErrorContext(c.Request.Context(), "Rate limiter unavailable, allowing request",
+			"principal_kind", "ip", "principal", c.ClientIP(), "error", err)
+		return false
+	}
+	if result[0] < int64(limit.Requests) {
+		return false
+	}
+	resetSeconds := ceilSeconds(result[1])
+	slog.InfoContext(c.Request.Context(), "Client blocked after failed authentications", "limit", limit.Requests,
+		"window", limit.Window.String(), "principal_kind", "ip", "principal", c.ClientIP())
+	tooManyRequests(c, resetSeconds,
+		fmt.Sprintf("%d failed authentications per %s, retry in %d seconds", limit.Requests, limit.Window, resetSeconds))
+	return true
+}
+
+// recordFailure records a failed authentication of the client IP at key
+func recordFailure(c *gin.Context, key string, limit Limit) {
+	now := time.Now()
+	err := slidingWindow.Run(context.Background(), config.GetRedisClient(), []string{key},
+		now.UnixMilli(), limit.Window.Milliseconds(), limit.Requests,
+		fmt.Sprintf("%d-%d", now.UnixNano(), rand.Int63())).Err()
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to record failed authentication",
+			"principal_kind", "ip", "principal", c.ClientIP(), "error", err)
+	}
+}
+
+func ceilSeconds(resetMillis int64) int {
+	return int(math.Ceil(float64(resetMillis) / 1000))
+}
+
+func tooManyRequests(c *gin.Context, retryAfter int, detail string) {
+	c.Header("Retry-After", strconv.Itoa(retryAfter))
+	c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{
+		"error":   "Too many requests",
+		"details": []string{detail},
+	})
+}
@@ -0,0 +1,197 @@
+package ratelimit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/arepala-uml/books-management-system/pkg/config"
+	"github.com/arepala-uml/books-management-system/pkg/testutil"
+	"github.com/gin-gonic/gin"
+)
+
+// setup enables rate limiting with the given limits over an in-memory Redis
+func setup(t *testing.T, settings map[string]string) *miniredis.Miniredis {
+	t.Helper()
+	settings["RATE_LIMIT_ENABLED"] = "true"
+	server := testutil.Redis(t, settings)
+	if err := Init(); err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+	return server
+}
+
+// serve sends a request from ip through the router and returns the response
+func serve(router *gin.Engine, method, path, ip string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	req.RemoteAddr = ip + ":40000"
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestParseLimit(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    Limit
+		wantErr bool
+	}{
+		{value: "100/1m", want: Limit{Requests: 100, Window: time.Minute}},
+		{value: " 5/30s ", want: Limit{Requests: 5, Window: 30 * time.Second}},
+		{value: "1/1ms", want: Limit{Requests: 1, Window: time.Millisecond}},
+		{value: "100", wantErr: true},
+		{value: "0/1m", wantErr: true},
+		{value: "-1/1m", wantErr: true},
+		{value: "ten/1m", wantErr: true},
+		{value: "10/1", wantErr: true},
+		{value: "10/1us", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseLimit(tt.value)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseLimit(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseLimit(%q) = %+v, want %+v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestLimitFor(t *testing.T) {
+	p, err := newPolicy(config.RateLimitConfig{
+		Default:      "300/1m",
+		IP:           "60/1m",
+		Routes:       "POST   /auth/token=10/1m, GET /books=120/1m",
+		AuthFailures: "10/5m",
+	})
+	if err != nil {
+		t.Fatalf("newPolicy() error = %v", err)
+	}
+	tests := []struct {
+		route, kind string
+		want        Limit
+	}{
+		{"POST /auth/token", "ip", Limit{10, time.Minute}},
+		{"GET /books", "user", Limit{120, time.Minute}},
+		{"GET /books/:id", "ip", Limit{60, time.Minute}},
+		{"GET /books/:id", "api_key", Limit{300, time.Minute}},
+	}
+	for _, tt := range tests {
+		if got := p.limitFor(tt.route, tt.kind); got != tt.want {
+			t.Errorf("limitFor(%q, %q) = %+v, want %+v", tt.route, tt.kind, got, tt.want)
+		}
+	}
+
+	for _, cfg := range []config.RateLimitConfig{
+		{Default: "300/1m", AuthFailures: "10/5m", Routes: "GET /books"},
+		{Default: "300/1m", AuthFailures: "10/5m", Routes: "GET /books=lots"},
+		{Default: "300/1m", AuthFailures: "10/5m", User: "1/never"},
+		{Default: "300/1m", AuthFailures: "often"},
+	} {
+		if _, err := newPolicy(cfg); err == nil {
+			t.Errorf("newPolicy(%+v) accepted an invalid limit", cfg)
+		}
+	}
+}
+
+func TestSlidingWindow(t *testing.T) {
+	setup(t, map[string]string{"RATE_LIMIT_ROUTES": "GET /limited=3/300ms"})
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/limited", Middleware(), func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	for i := 1; i <= 3; i++ {
+		w := serve(router, http.MethodGet, "/limited", "192.0.2.1")
+		if w.Code != http.StatusOK {
+			t.Fatalf("request %d status = %d, want %d", i, w.Code, http.StatusOK)
+		}
+		if got, want := w.Header().Get("RateLimit-Remaining"), strconv.Itoa(3-i); got != want {
+			t.Errorf("request %d RateLimit-Remaining = %s, want %s", i, got, want)
+		}
+	}
+	w := serve(router, http.MethodGet, "/limited", "192.0.2.1")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("request over the limit status = %d, want %d", w.Code, http.StatusTooManyRequests)
+	}
+	if got := w.Header().Get("Retry-After"); got != "1" {
+		t.Errorf("Retry-After = %q, want the window rounded up to 1 second", got)
+	}
+	if got := w.Header().Get("RateLimit-Policy"); got != "3;w=0" {
+		t.Errorf("RateLimit-Policy = %q, want 3;w=0", got)
+	}
+
+	// Each client IP has a window of its own
+	if w := serve(router, http.MethodGet, "/limited", "192.0.2.2"); w.Code != http.StatusOK {
+		t.Errorf("request of another IP status = %d, want %d", w.Code, http.StatusOK)
+	}
+
+	// Rejected requests aren't recorded, so the window frees once the first requests leave it
+	time.Sleep(350 * time.Millisecond)
+	if w := serve(router, http.MethodGet, "/limited", "192.0.2.1"); w.Code != http.StatusOK {
+		t.Errorf("request after the window status = %d, want %d", w.Code, http.StatusOK)
+	}
+}
+
+func TestIPMiddlewareBlocksFailedAuthentications(t *testing.T) {
+	setup(t, map[string]string{"RATE_LIMIT_IP": "100/1m", "RATE_LIMIT_AUTH_FAILURES": "2/1m"})
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(IPMiddleware())
+	router.GET("/open", func(c *gin.Context) { c.Status(http.StatusOK) })
+	router.GET("/denied", func(c *gin.Context) { c.AbortWithStatus(http.StatusUnauthorized) })
+
+	// Successful requests don't count as failures
+	for range 3 {
+		serve(router, http.MethodGet, "/open", "192.0.2.1")
+	}
+	for i := 1; i <= 2; i++ {
+		if w := serve(router, http.MethodGet, "/denied", "192.0.2.1"); w.Code != http.StatusUnauthorized {
+			t.Fatalf("failure %d status = %d, want %d", i, w.Code, http.StatusUnauthorized)
+		}
+	}
+
+	// The client IP is now blocked before reaching authentication, on every route
+	w := serve(router, http.MethodGet, "/open", "192.0.2.1")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("request after the failures status = %d, want %d", w.Code, http.StatusTooManyRequests)
+	}
+	if w.Header().Get("Retry-After") == "" {
+		t.Error("Retry-After is missing")
+	}
+	if w := serve(router, http.MethodGet, "/open", "192.0.2.2"); w.Code != http.StatusOK {
+		t.Errorf("request of another IP status = %d, want %d", w.Code, http.StatusOK)
+	}
+}
+
+func TestIPMiddlewareLimitsEveryRoute(t *testing.T) {
+	setup(t, map[string]string{"RATE_LIMIT_IP": "2/1m"})
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(IPMiddleware())
+	router.GET("/a", func(c *gin.Context) { c.Status(http.StatusOK) })
+	router.GET("/b", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	serve(router, http.MethodGet, "/a", "192.0.2.1")
+	serve(router, http.MethodGet, "/b", "192.0.2.1")
+	if w := serve(router, http.MethodGet, "/a", "192.0.2.1"); w.Code != http.StatusTooManyRequests {
+		t.Errorf("third request of the IP status = %d, want %d", w.Code, http.StatusTooManyRequests)
+	}
+}
+
+func TestMiddlewareFailsOpen(t *testing.T) {
+	server := setup(t, map[string]string{"RATE_LIMIT_ROUTES": "GET /limited=1/1m"})
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/limited", Middleware(), func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	server.Close()
+	for range 2 {
+		if w := serve(router, http.MethodGet, "/limited", "192.0.2.1"); w.Code != http.StatusOK {
+			t.Errorf("status without Redis = %d, want %d", w.Code, http.StatusOK)
+		}
+	}
+}
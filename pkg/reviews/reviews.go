@@ -0,0 +1,104 @@
+package reviews
+
+import (
+	"context"
+	"errors"
+
+	"github.com/arepala-uml/books-management-system/pkg/config"
+	"github.com/arepala-uml/books-management-system/pkg/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrAlreadyReviewed = errors.New("member has already reviewed this book")
+	ErrInvalidStatus   = errors.New("status must be one of pending, approved or rejected")
+)
+
+// Create stores a new pending review for a book
+func Create(ctx context.Context, review *models.Review) error {
+	review.ID = 0
+	review.Status = models.ReviewPending
+
+	db := config.GetDB().WithContext(ctx)
+	var existing int64
+	err := db.Model(&models.Review{}).
+		Where("book_id = ? AND member_id = ?", review.BookID, review.MemberID).
+		Count(&existing).Error
+	if err != nil {
+		return err
+	}
+	if existing > 0 {
+		return ErrAlreadyReviewed
+	}
+
+	err = db.Create(review).Error
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		// Lost a race with a concurrent review by the same member
+		return ErrAlreadyReviewed
+	}
+	return err
+}
+
+// List returns a page of the reviews of a book in the given moderation state, newest first,
+// along with the total number of such reviews
+func List(ctx context.Context, bookID int, status string, limit int, offset int) ([]models.Review, int64, error) {
+	reviews := make([]models.Review, 0)
+	var total int64
+
+	query := config.GetDB().WithContext(ctx).Model(&models.Review{}).Where("book_id = ? AND status = ?", bookID, status)
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	err := query.Order("created_at DESC, id DESC").Limit(limit).Offset(offset).Find(&reviews).Error
+	return reviews, total, err
+}
+
+// Moderate moves a review to a new moderation state and refreshes the rating aggregates of its book
+// in the same transaction. It returns the updated review and book.
+func Moderate(ctx context.Context, reviewID int, status string) (models.Review, models.Book, error) {
+	var review models.Review
+	var book models.Book
+	if !models.ValidReviewStatus(status) {
+		return review, book, ErrInvalidStatus
+	}
+
+	err := config.GetDB().WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&review, reviewID).Error; err != nil {
+			return err
+		}
+		// Lock the book so concurrent moderations of its reviews apply one after another
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&book, review.BookID).Error; err != nil {
+			return err
+		}
+
+		review.Status = status
+		if err := tx.Model(&review).Update("status", status).Error; err != nil {
+			return err
+		}
+		return refreshRating(tx, &book)
+	})
+	return review, book, err
+}
+
+// refreshRating recomputes the average rating and count of a book from its approved reviews
+func refreshRating(tx *gorm.DB, book *models.Book) error {
+	var aggregate struct {
+		Average float64
+		Count   int
+	}
+	err := tx.Model(&models.Review{}).
+		Where("book_id = ? AND status = ?", book.ID, models.ReviewApproved).
+		Select("COALESCE(AVG(rating), 0) AS average, COUNT(*) AS count").
+		Scan(&aggregate).Error
+	if err != nil {
+		return err
+	}
+
+	book.AverageRating = aggregate.Average
+	book.RatingCount = aggregate.Count
+	return tx.Model(book).Updates(map[string]interface{}{
+		"average_rating": book.AverageRating,
+		"rating_count":   book.RatingCount,
+	}).Error
+}
@@ -1,15 +1,89 @@
 package routes
 
 import (
+	"github.com/arepala-uml/books-management-system/pkg/auth"
+	"github.com/arepala-uml/books-management-system/pkg/config"
 	"github.com/arepala-uml/books-management-system/pkg/controllers"
+	"github.com/arepala-uml/books-management-system/pkg/health"
+	"github.com/arepala-uml/books-management-system/pkg/idempotency"
+	"github.com/arepala-uml/books-management-system/pkg/lifecycle"
+	"github.com/arepala-uml/books-management-system/pkg/metrics"
+	"github.com/arepala-uml/books-management-system/pkg/ratelimit"
 	"github.com/gin-gonic/gin"
 )
 
-// RegisterBookStoreRoutes registers the API routes for the book management store
-func RegisterBookStoreRoutes(r *gin.Engine) {
-	r.GET("/books", controllers.GetBooks)
-	r.GET("/books/:id", controllers.GetBook)
-	r.POST("/books", controllers.CreateBook)
-	r.PUT("/books/:id", controllers.UpdateBook)
-	r.DELETE("/books/:id", controllers.DeleteBook)
+// NewEngine returns a gin engine recovering from panics, which reads the client IP from X-Forwarded-For
+// only on connections from SERVER_TRUSTED_PROXIES
+func NewEngine() (*gin.Engine, error) {
+	r := gin.New()
+	if err := r.SetTrustedProxies(config.Get().Server.Proxies()); err != nil {
+		return nil, err
+	}
+	r.Use(gin.Recovery())
+	return r, nil
+}
+
+// RegisterBookStoreRoutes registers the API routes for the book management store. Work started by
+// a request to run in the background is run by app.
+func RegisterBookStoreRoutes(r *gin.Engine, app *lifecycle.Lifecycle) {
+	// Probes for the load balancer and the Prometheus scrape endpoint, without authentication or rate limits
+	r.GET("/healthz", health.Liveness)
+	r.GET("/readyz", health.Readiness)
+	r.GET("/metrics", metrics.Handler())
+
+	// Every other request is limited per client IP before authentication, and clients failing to
+	// authenticate too often are blocked
+	limited := r.Group("/", ratelimit.IPMiddleware())
+
+	// Token requests are also limited per route and client IP
+	limited.POST("/auth/token", ratelimit.Middleware(), controllers.IssueToken)
+
+	// Everything else requires a valid access token or API key and the permission of its group,
+	// is limited per user or API key, and writes can be retried safely with an Idempotency-Key
+	api := limited.Group("/", auth.Authenticate(), ratelimit.Middleware(), idempotency.Middleware())
+
+	booksRead := api.Group("/", auth.RequirePermission(auth.PermBooksRead))
+	booksRead.GET("/books", controllers.GetBooks)
+	booksRead.GET("/books/:id", controllers.GetBook)
+	booksRead.GET("/books/:id/reviews", controllers.GetReviews)
+
+	booksWrite := api.Group("/", auth.RequirePermission(auth.PermBooksWrite))
+	booksWrite.POST("/books", controllers.CreateBook)
+	booksWrite.PUT("/books/:id", controllers.UpdateBook)
+
+	booksDelete := api.Group("/", auth.RequirePermission(auth.PermBooksDelete))
+	booksDelete.DELETE("/books/:id", controllers.DeleteBook)
+
+	reviewsWrite := api.Group("/", auth.RequirePermission(auth.PermReviewsWrite))
+	reviewsWrite.POST("/books/:id/reviews", controllers.CreateReview)
+
+	reviewsModerate := api.Group("/", auth.RequirePermission(auth.PermReviewsModerate))
+	reviewsModerate.PUT("/reviews/:id/status", controllers.ModerateReview)
+
+	membersRead := api.Group("/", auth.RequirePermission(auth.PermMembersRead))
+	membersRead.GET("/members/:id/balance", controllers.GetMemberBalance)
+	membersRead.GET("/members/:id/statement", controllers.GetMemberStatement)
+
+	membersWrite := api.Group("/", auth.RequirePermission(auth.PermMembersWrite))
+	membersWrite.POST("/members", controllers.CreateMember)
+
+	ledgerWrite := api.Group("/", auth.RequirePermission(auth.PermLedgerWrite))
+	ledgerWrite.POST("/members/:id/payments", controllers.CreatePayment)
+	ledgerWrite.POST("/members/:id/waivers", controllers.CreateWaiver)
+
+	loansWrite := api.Group("/", auth.RequirePermission(auth.PermLoansWrite))
+	loansWrite.POST("/loans", controllers.CheckoutBook)
+	loansWrite.POST("/loans/:id/return", controllers.ReturnLoan)
+	loansWrite.POST("/loans/:id/lost", controllers.MarkLoanLost)
+
+	usersAdmin := api.Group("/", auth.RequirePermission(auth.PermUsersAdmin))
+	usersAdmin.GET("/users/:id/roles", controllers.GetUserRoles)
+	usersAdmin.PUT("/users/:id/roles", controllers.SetUserRoles)
+	usersAdmin.POST("/api-keys", idempotency.OmitResponse(), controllers.CreateAPIKey)
+	usersAdmin.GET("/api-keys", controllers.GetAPIKeys)
+	usersAdmin.DELETE("/api-keys/:id", controllers.RevokeAPIKey)
+
+	cacheAdmin := api.Group("/", auth.RequirePermission(auth.PermCacheAdmin))
+	cacheAdmin.POST("/admin/cache/warm", controllers.StartCacheWarm(app))
+	cacheAdmin.GET("/admin/cache/warm", controllers.GetCacheWarm)
 }
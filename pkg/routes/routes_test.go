@@ -0,0 +1,63 @@
+package routes
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/arepala-uml/books-management-system/pkg/lifecycle"
+	"github.com/arepala-uml/books-management-system/pkg/ratelimit"
+	"github.com/arepala-uml/books-management-system/pkg/testutil"
+	"github.com/gin-gonic/gin"
+)
+
+// TestForwardedForTrust checks the X-Forwarded-For header sets the client IP a request is limited by
+// only on connections from SERVER_TRUSTED_PROXIES
+func TestForwardedForTrust(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tests := []struct {
+		name    string
+		proxies string
+		remote  string
+		// forwarded returns the X-Forwarded-For header of the i-th request
+		forwarded func(i int) string
+		want      int
+	}{
+		{"spoofed by a client", "", "203.0.113.7", func(i int) string { return fmt.Sprintf("198.51.100.%d", i) }, http.StatusTooManyRequests},
+		{"spoofed through an untrusted proxy", "192.0.2.1", "203.0.113.7", func(i int) string { return fmt.Sprintf("198.51.100.%d", i) }, http.StatusTooManyRequests},
+		{"clients of a trusted proxy", "192.0.2.0/24", "192.0.2.1", func(i int) string { return fmt.Sprintf("198.51.100.%d", i) }, http.StatusUnauthorized},
+		{"client of a trusted proxy", "192.0.2.1", "192.0.2.1", func(int) string { return "198.51.100.1" }, http.StatusTooManyRequests},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testutil.Redis(t, map[string]string{
+				"RATE_LIMIT_ENABLED":     "true",
+				"RATE_LIMIT_IP":          "3/1m",
+				"SERVER_TRUSTED_PROXIES": tt.proxies,
+			})
+			if err := ratelimit.Init(); err != nil {
+				t.Fatal(err)
+			}
+			r, err := NewEngine()
+			if err != nil {
+				t.Fatalf("NewEngine() error = %v", err)
+			}
+			RegisterBookStoreRoutes(r, lifecycle.New())
+
+			// Requests without credentials are limited per client IP before they are refused
+			var code int
+			for i := 1; i <= 4; i++ {
+				req := httptest.NewRequest(http.MethodGet, "/books", nil)
+				req.RemoteAddr = tt.remote + ":40000"
+				req.Header.Set("X-Forwarded-For", tt.forwarded(i))
+				w := httptest.NewRecorder()
+				r.ServeHTTP(w, req)
+				code = w.Code
+			}
+			if code != tt.want {
+				t.Errorf("4th request status = %d, want %d", code, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,111 @@
+// Package testutil sets up the configuration and the stores the tests of the other packages run against,
+// so no test depends on app.env or on running services.
+package testutil
+
+import (
+	"maps"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/arepala-uml/books-management-system/pkg/config"
+	"github.com/golang-jwt/jwt/v5"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// required fills the settings without a default, so the configuration validates without app.env
+var required = map[string]string{
+	"POSTGRES_DB":   "books",
+	"POSTGRES_USER": "books",
+	"POSTGRES_HOST": "localhost",
+	"REDIS_HOST":    "localhost",
+	"KAFKA_HOST":    "localhost",
+	"KAFKA_PORT":    "9092",
+	"JWT_SECRET":    "a-test-secret-of-at-least-32-bytes",
+}
+
+// Config loads the configuration from the defaults and settings only, and makes it available through
+// config.Get. The settings are set as environment variables for the duration of the test.
+func Config(t testing.TB, settings map[string]string) *config.Config {
+	t.Helper()
+	env := maps.Clone(required)
+	maps.Copy(env, settings)
+	for key, value := range env {
+		t.Setenv(key, value)
+	}
+	// No app.env in the directory of the package under test, so only the environment is read
+	cfg, err := config.Init("")
+	if err != nil {
+		t.Fatalf("loading the configuration: %v", err)
+	}
+	return cfg
+}
+
+// Redis runs an in-memory Redis for the duration of the test, loads the configuration with Config
+// pointing at it and connects to it
+func Redis(t testing.TB, settings map[string]string) *miniredis.Miniredis {
+	t.Helper()
+	server := miniredis.RunT(t)
+	env := maps.Clone(settings)
+	if env == nil {
+		env = make(map[string]string)
+	}
+	env["REDIS_MODE"] = config.RedisModeStandalone
+	env["REDIS_HOST"] = server.Host()
+	env["REDIS_PORT"] = server.Port()
+	Config(t, env)
+	config.ConnectRedis()
+	t.Cleanup(func() { config.CloseRedis() })
+	return server
+}
+
+// DB opens a SQLite database for the duration of the test with the tables of models. SQLite ignores
+// FOR UPDATE, so tests against it cover the rules of the queries, not their locking.
+func DB(t testing.TB, models ...interface{}) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{
+		TranslateError: true,
+		Logger:         logger.Discard,
+	})
+	if err != nil {
+		t.Fatalf("opening SQLite: %v", err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	if err := db.AutoMigrate(models...); err != nil {
+		t.Fatalf("creating the tables: %v", err)
+	}
+	return db
+}
+
+// Token returns an HS256 access token of userID signed with the JWT_SECRET of the loaded configuration,
+// expiring after ttl. A negative ttl returns an expired token.
+func Token(t testing.TB, userID int, ttl time.Duration) string {
+	t.Helper()
+	cfg := config.Get().Auth
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"sub":      strconv.Itoa(userID),
+		"username": "user" + strconv.Itoa(userID),
+		"iat":      now.Unix(),
+		"exp":      now.Add(ttl).Unix(),
+	}
+	if cfg.JWTIssuer != "" {
+		claims["iss"] = cfg.JWTIssuer
+	}
+	if cfg.JWTAudience != "" {
+		claims["aud"] = cfg.JWTAudience
+	}
+	signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(cfg.JWTSecret.Reveal()))
+	if err != nil {
+		t.Fatalf("signing a token: %v", err)
+	}
+	return signed
+}
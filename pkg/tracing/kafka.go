@@ -0,0 +1,69 @@
+package tracing
+
+import (
+	"github.com/IBM/sarama"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// ProducerCarrier carries the trace context in the headers of a message being published
+type ProducerCarrier struct {
+	Message *sarama.ProducerMessage
+}
+
+var _ propagation.TextMapCarrier = ProducerCarrier{}
+
+func (c ProducerCarrier) Get(key string) string {
+	for _, h := range c.Message.Headers {
+		if string(h.Key) == key {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
+
+func (c ProducerCarrier) Set(key string, value string) {
+	for i, h := range c.Message.Headers {
+		if string(h.Key) == key {
+			c.Message.Headers[i].Value = []byte(value)
+			return
+		}
+	}
+	c.Message.Headers = append(c.Message.Headers, sarama.RecordHeader{Key: []byte(key), Value: []byte(value)})
+}
+
+func (c ProducerCarrier) Keys() []string {
+	keys := make([]string, 0, len(c.Message.Headers))
+	for _, h := range c.Message.Headers {
+		keys = append(keys, string(h.Key))
+	}
+	return keys
+}
+
+// ConsumerCarrier reads the trace context from the headers of a consumed message
+type ConsumerCarrier struct {
+	Message *sarama.ConsumerMessage
+}
+
+var _ propagation.TextMapCarrier = ConsumerCarrier{}
+
+func (c ConsumerCarrier) Get(key string) string {
+	for _, h := range c.Message.Headers {
+		if h != nil && string(h.Key) == key {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
+
+// Set is a no-op as consumed messages are read only
+func (c ConsumerCarrier) Set(string, string) {}
+
+func (c ConsumerCarrier) Keys() []string {
+	keys := make([]string, 0, len(c.Message.Headers))
+	for _, h := range c.Message.Headers {
+		if h != nil {
+			keys = append(keys, string(h.Key))
+		}
+	}
+	return keys
+}